@@ -0,0 +1,487 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gorilla/csrf"
+	"go.uber.org/zap"
+)
+
+// path prefix of the admin API
+const adminApiPrefix = "/admin/api/"
+
+// max size of admin API request body
+const adminApiMaxBody = 1 << 20
+
+// user as exposed by the admin API, hashes are never returned
+type AdminUser struct {
+	Username       string   `json:"username"`
+	Password       string   `json:"password,omitempty"`
+	Email          string   `json:"email,omitempty"`
+	AllowedDomains []string `json:"allowedDomains"`
+	Groups         []string `json:"groups"`
+	Admin          bool     `json:"admin"`
+	Disabled       bool     `json:"disabled"`
+	// missing for no limit
+	NotBefore          *time.Time `json:"notBefore,omitempty"`
+	ExpiresAt          *time.Time `json:"expiresAt,omitempty"`
+	MustChangePassword bool       `json:"mustChangePassword"`
+	Language           string     `json:"language,omitempty"`
+	Tokens             []string   `json:"tokens,omitempty"`
+}
+
+// partial update of a user, nil fields are left untouched
+type AdminUserUpdate struct {
+	Email          *string   `json:"email"`
+	AllowedDomains *[]string `json:"allowedDomains"`
+	Groups         *[]string `json:"groups"`
+	Admin          *bool     `json:"admin"`
+	Disabled       *bool     `json:"disabled"`
+	// zero time removes the limit
+	NotBefore          *time.Time `json:"notBefore"`
+	ExpiresAt          *time.Time `json:"expiresAt"`
+	MustChangePassword *bool      `json:"mustChangePassword"`
+	// empty for browser preference
+	Language *string `json:"language"`
+}
+
+type AdminSession struct {
+	Id        string    `json:"id"`
+	Username  string    `json:"username"`
+	Ip        string    `json:"ip"`
+	CreatedAt time.Time `json:"createdAt"`
+	ExpiresAt time.Time `json:"expiresAt"`
+}
+
+type AdminGroup struct {
+	AllowedDomains []string `json:"allowedDomains"`
+}
+
+// admin API is authenticated by bearer token, without cookies, so csrf check is useless
+func SkipCsrfForAdminApi(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasPrefix(r.URL.Path, adminApiPrefix) {
+			r = csrf.UnsafeSkipCheck(r)
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// return admin user from the admin token of the request, nil if none or not admin
+func GetAdminFromRequest(r *http.Request) *User {
+	token := GetBearerToken(r)
+	if token == "" {
+		return nil
+	}
+	u, t := GetUserFromToken(token)
+	switch {
+	case u == nil:
+		log.Error("admin: token not found", zap.String("ip", GetIp(r)))
+		return nil
+	case !t.Admin:
+		log.Error("admin: not an admin token", zap.String("username", u.Username), zap.String("token", t.Name))
+		return nil
+	case !u.Admin:
+		log.Error("admin: user is not admin", zap.String("username", u.Username))
+		return nil
+	case !t.Valid(GetHost(r)):
+		return nil
+	}
+	return u
+}
+
+// serve admin API, users are managed in database only
+func AdminApiHandler(w http.ResponseWriter, r *http.Request) {
+
+	ip := GetIp(r)
+	log.Sugar().Debug("admin: api requested", zap.String("ip", ip), "request", RedactRequest(r))
+
+	admin := GetAdminFromRequest(r)
+	if admin == nil {
+		// prevent bruteforce with sleeptime
+		time.Sleep(500 * time.Millisecond)
+		WriteJson(w, http.StatusUnauthorized, errors.New("admin: unauthorized"))
+		return
+	}
+	db := configuration.db
+	if db == nil {
+		WriteJson(w, http.StatusNotImplemented, errors.New("admin: no database configured"))
+		return
+	}
+	r.Body = http.MaxBytesReader(w, r.Body, adminApiMaxBody)
+
+	a := &adminRequest{w: w, r: r, db: db, admin: admin}
+	path := strings.Split(strings.Trim(strings.TrimPrefix(r.URL.Path, adminApiPrefix), "/"), "/")
+	switch {
+	case len(path) == 1 && path[0] == "users":
+		a.route(map[string]func(){"GET": a.listUsers, "POST": a.createUser})
+	case len(path) == 2 && path[0] == "users":
+		a.route(map[string]func(){"GET": func() { a.getUser(path[1]) }, "PATCH": func() { a.updateUser(path[1]) }, "DELETE": func() { a.deleteUser(path[1]) }})
+	case len(path) == 3 && path[0] == "users" && path[2] == "password":
+		a.route(map[string]func(){"POST": func() { a.setPassword(path[1]) }})
+	case len(path) == 3 && path[0] == "users" && path[2] == "sessions":
+		a.route(map[string]func(){"GET": func() { a.listSessions(path[1]) }, "DELETE": func() { a.revokeSessions(path[1]) }})
+	case len(path) == 1 && path[0] == "sessions":
+		a.route(map[string]func(){"GET": func() { a.listSessions("") }})
+	case len(path) == 2 && path[0] == "sessions":
+		a.route(map[string]func(){"DELETE": func() { a.revokeSession(path[1]) }})
+	case len(path) == 1 && path[0] == "groups":
+		a.route(map[string]func(){"GET": a.listGroups})
+	case len(path) == 2 && path[0] == "groups":
+		a.route(map[string]func(){"PUT": func() { a.setGroup(path[1]) }, "DELETE": func() { a.deleteGroup(path[1]) }})
+	default:
+		WriteJson(w, http.StatusNotFound, errors.New("admin: not found"))
+	}
+}
+
+// write v as json, errors are wrapped in an error object
+func WriteJson(w http.ResponseWriter, status int, v interface{}) {
+	if err, ok := v.(error); ok {
+		v = map[string]string{"error": err.Error()}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if v != nil {
+		json.NewEncoder(w).Encode(v)
+	}
+}
+
+// state of an admin API request
+type adminRequest struct {
+	w     http.ResponseWriter
+	r     *http.Request
+	db    *SqlStore
+	admin *User
+}
+
+// call handler of request method
+func (a *adminRequest) route(handlers map[string]func()) {
+	h, ok := handlers[a.r.Method]
+	if !ok {
+		methods := make([]string, 0, len(handlers))
+		for m := range handlers {
+			methods = append(methods, m)
+		}
+		sort.Strings(methods)
+		a.w.Header().Set("Allow", strings.Join(methods, ", "))
+		WriteJson(a.w, http.StatusMethodNotAllowed, errors.New("admin: method not allowed"))
+		return
+	}
+	h()
+}
+
+// decode json body in v, write an error and return false if invalid
+func (a *adminRequest) decode(v interface{}) bool {
+	d := json.NewDecoder(a.r.Body)
+	d.DisallowUnknownFields()
+	if err := d.Decode(v); err != nil {
+		WriteJson(a.w, http.StatusBadRequest, errors.New("admin: bad request body\n\t-> "+err.Error()))
+		return false
+	}
+	return true
+}
+
+// write store error with the matching status
+func (a *adminRequest) error(err error) {
+	if errors.Is(err, ErrNotFound) {
+		WriteJson(a.w, http.StatusNotFound, err)
+		return
+	}
+	log.Error("admin: store error", zap.String("admin", a.admin.Username), zap.Error(err))
+	WriteJson(a.w, http.StatusInternalServerError, err)
+}
+
+// record admin action on target
+func (a *adminRequest) audit(action, target string) {
+	auditLog.Add(a.admin.Username, "admin "+action, target, GetIp(a.r))
+}
+
+func (a *adminRequest) listUsers() {
+	users, err := a.db.ListUsers()
+	if err != nil {
+		a.error(err)
+		return
+	}
+	ret := make([]*AdminUser, 0, len(users))
+	for _, u := range users {
+		ret = append(ret, a.user(u))
+	}
+	WriteJson(a.w, http.StatusOK, ret)
+}
+
+func (a *adminRequest) getUser(username string) {
+	u := a.db.GetUser(username)
+	if u == nil {
+		a.error(ErrNotFound)
+		return
+	}
+	WriteJson(a.w, http.StatusOK, a.user(u))
+}
+
+func (a *adminRequest) createUser() {
+	req := &AdminUser{}
+	if !a.decode(req) {
+		return
+	}
+	if req.Username == "" || req.Password == "" {
+		WriteJson(a.w, http.StatusBadRequest, errors.New("admin: username and password are mandatory"))
+		return
+	}
+	if err := configuration.PasswordPolicy.Check(req.Username, req.Password); err != nil {
+		WriteJson(a.w, http.StatusBadRequest, err)
+		return
+	}
+	if req.Language != "" && !SupportedLanguage(req.Language) {
+		WriteJson(a.w, http.StatusBadRequest, ErrUnsupportedLanguage)
+		return
+	}
+	if err := validApiDomains(req.AllowedDomains); err != nil {
+		WriteJson(a.w, http.StatusBadRequest, err)
+		return
+	}
+	if a.db.GetUser(req.Username) != nil {
+		WriteJson(a.w, http.StatusConflict, errors.New("admin: user already exists"))
+		return
+	}
+	hash, err := configuration.PasswordHash.Hash(req.Password)
+	if err != nil {
+		a.error(err)
+		return
+	}
+	u := &User{
+		Username:           req.Username,
+		Password:           hash,
+		Email:              req.Email,
+		AllowedDomains:     req.AllowedDomains,
+		Groups:             req.Groups,
+		Admin:              req.Admin,
+		Disabled:           req.Disabled,
+		MustChangePassword: req.MustChangePassword,
+		Language:           req.Language,
+	}
+	if req.NotBefore != nil {
+		u.NotBefore = *req.NotBefore
+	}
+	if req.ExpiresAt != nil {
+		u.ExpiresAt = *req.ExpiresAt
+	}
+	if err := a.db.CreateUser(u); err != nil {
+		a.error(err)
+		return
+	}
+	a.audit("user created", u.Username)
+	WriteJson(a.w, http.StatusCreated, a.user(a.db.GetUser(u.Username)))
+}
+
+func (a *adminRequest) updateUser(username string) {
+	req := &AdminUserUpdate{}
+	if !a.decode(req) {
+		return
+	}
+	u := a.db.GetUser(username)
+	if u == nil {
+		a.error(ErrNotFound)
+		return
+	}
+	if req.Language != nil && *req.Language != "" && !SupportedLanguage(*req.Language) {
+		WriteJson(a.w, http.StatusBadRequest, ErrUnsupportedLanguage)
+		return
+	}
+	if username == a.admin.Username && ((req.Disabled != nil && *req.Disabled) || (req.Admin != nil && !*req.Admin)) {
+		WriteJson(a.w, http.StatusConflict, errors.New("admin: can't disable or demote yourself"))
+		return
+	}
+	if req.AllowedDomains != nil {
+		if err := validApiDomains(*req.AllowedDomains); err != nil {
+			WriteJson(a.w, http.StatusBadRequest, err)
+			return
+		}
+	}
+	// all or nothing, disabled user is logged out everywhere
+	err := a.db.UpdateUser(username, &UserUpdate{
+		Email:              req.Email,
+		AllowedDomains:     req.AllowedDomains,
+		Groups:             req.Groups,
+		Admin:              req.Admin,
+		Disabled:           req.Disabled,
+		NotBefore:          req.NotBefore,
+		ExpiresAt:          req.ExpiresAt,
+		MustChangePassword: req.MustChangePassword,
+		Language:           req.Language,
+	})
+	if err != nil {
+		a.error(err)
+		return
+	}
+	a.audit("user updated", username)
+	WriteJson(a.w, http.StatusOK, a.user(a.db.GetUser(username)))
+}
+
+func (a *adminRequest) deleteUser(username string) {
+	if username == a.admin.Username {
+		WriteJson(a.w, http.StatusConflict, errors.New("admin: can't delete yourself"))
+		return
+	}
+	if err := a.db.DeleteUser(username); err != nil {
+		a.error(err)
+		return
+	}
+	if err := a.db.RevokeUserSessions(username, ""); err != nil {
+		a.error(err)
+		return
+	}
+	a.audit("user deleted", username)
+	WriteJson(a.w, http.StatusNoContent, nil)
+}
+
+// replace user password, and log user out everywhere
+func (a *adminRequest) setPassword(username string) {
+	req := &struct {
+		Password string `json:"password"`
+	}{}
+	if !a.decode(req) {
+		return
+	}
+	if req.Password == "" {
+		WriteJson(a.w, http.StatusBadRequest, errors.New("admin: password is mandatory"))
+		return
+	}
+	if err := configuration.PasswordPolicy.Check(username, req.Password); err != nil {
+		WriteJson(a.w, http.StatusBadRequest, err)
+		return
+	}
+	hash, err := configuration.PasswordHash.Hash(req.Password)
+	if err != nil {
+		a.error(err)
+		return
+	}
+	if err := a.db.SetPassword(username, hash); err != nil {
+		a.error(err)
+		return
+	}
+	basicAuthCache.Forget(username)
+	if err := a.db.RevokeUserSessions(username, ""); err != nil {
+		a.error(err)
+		return
+	}
+	a.audit("password reset", username)
+	WriteJson(a.w, http.StatusNoContent, nil)
+}
+
+func (a *adminRequest) listSessions(username string) {
+	sessions, err := a.db.ListSessions(username)
+	if err != nil {
+		a.error(err)
+		return
+	}
+	ret := make([]*AdminSession, 0, len(sessions))
+	for _, s := range sessions {
+		ret = append(ret, &AdminSession{Id: s.Id, Username: s.Username, Ip: s.Ip, CreatedAt: s.CreatedAt, ExpiresAt: s.ExpiresAt})
+	}
+	WriteJson(a.w, http.StatusOK, ret)
+}
+
+func (a *adminRequest) revokeSessions(username string) {
+	if err := a.db.RevokeUserSessions(username, ""); err != nil {
+		a.error(err)
+		return
+	}
+	a.audit("sessions revoked", username)
+	WriteJson(a.w, http.StatusNoContent, nil)
+}
+
+func (a *adminRequest) revokeSession(id string) {
+	if err := a.db.RevokeSession(id); err != nil {
+		a.error(err)
+		return
+	}
+	a.audit("session revoked", id)
+	WriteJson(a.w, http.StatusNoContent, nil)
+}
+
+func (a *adminRequest) listGroups() {
+	groups, err := a.db.ListGroups()
+	if err != nil {
+		a.error(err)
+		return
+	}
+	ret := make(map[string]*AdminGroup, len(groups))
+	for name, domains := range groups {
+		ret[name] = &AdminGroup{AllowedDomains: domains}
+	}
+	WriteJson(a.w, http.StatusOK, ret)
+}
+
+// create or replace group
+func (a *adminRequest) setGroup(name string) {
+	req := &AdminGroup{}
+	if !a.decode(req) {
+		return
+	}
+	if err := validApiDomains(req.AllowedDomains); err != nil {
+		WriteJson(a.w, http.StatusBadRequest, err)
+		return
+	}
+	if err := a.db.SetGroupDomains(name, req.AllowedDomains); err != nil {
+		a.error(err)
+		return
+	}
+	a.audit("group updated", name)
+	WriteJson(a.w, http.StatusOK, req)
+}
+
+func (a *adminRequest) deleteGroup(name string) {
+	if err := a.db.DeleteGroup(name); err != nil {
+		a.error(err)
+		return
+	}
+	a.audit("group deleted", name)
+	WriteJson(a.w, http.StatusNoContent, nil)
+}
+
+// check allowed domains are valid patterns, as in configuration
+func validApiDomains(domains []string) error {
+	for _, d := range domains {
+		if _, err := CompileDomain(d); err != nil {
+			return errors.New("admin: bad allowedDomains\n\t-> " + err.Error())
+		}
+	}
+	return nil
+}
+
+// return user as exposed by admin API, with its own domains only
+func (a *adminRequest) user(u *User) *AdminUser {
+	if u == nil {
+		return nil
+	}
+	domains, err := a.db.GetUserDomains(u.Username)
+	if err != nil {
+		log.Error("admin: store error", zap.Error(err))
+	}
+	au := &AdminUser{
+		Username:           u.Username,
+		Email:              u.Email,
+		AllowedDomains:     domains,
+		Groups:             u.Groups,
+		Admin:              u.Admin,
+		Disabled:           u.Disabled,
+		MustChangePassword: u.MustChangePassword,
+		Language:           u.Language,
+	}
+	if !u.NotBefore.IsZero() {
+		au.NotBefore = &u.NotBefore
+	}
+	if !u.ExpiresAt.IsZero() {
+		au.ExpiresAt = &u.ExpiresAt
+	}
+	for name := range u.Tokens {
+		au.Tokens = append(au.Tokens, name)
+	}
+	sort.Strings(au.Tokens)
+	return au
+}
@@ -0,0 +1,203 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/csrf"
+	"github.com/stretchr/testify/assert"
+)
+
+// call admin API with token, return status and decoded body
+func adminRequestTest(t *testing.T, token, method, path, body string) (int, interface{}) {
+	r := httptest.NewRequest(method, path, strings.NewReader(body))
+	if token != "" {
+		r.Header.Set("Authorization", "Bearer "+token)
+	}
+	w := httptest.NewRecorder()
+	AdminApiHandler(w, r)
+	var ret interface{}
+	if w.Body.Len() > 0 {
+		assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &ret), w.Body.String())
+	}
+	return w.Code, ret
+}
+
+func TestGetAdminFromRequest(t *testing.T) {
+	testCases := []struct {
+		name     string
+		token    string
+		expected string
+	}{
+		{"ADMIN", "gfa_test_admin", "admin"},
+		{"NOT_ADMIN_USER", "gfa_test_jean_admin", ""},
+		{"NOT_ADMIN_TOKEN", "gfa_test_token", ""},
+		{"UNKNOWN", "gfa_nope", ""},
+		{"NONE", "", ""},
+	}
+	for _, tc := range testCases {
+		// shadow the test case to avoid modifying the test case
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			r := httptest.NewRequest("GET", adminApiPrefix+"users", nil)
+			if tc.token != "" {
+				r.Header.Set("Authorization", "Bearer "+tc.token)
+			}
+			u := GetAdminFromRequest(r)
+			if tc.expected == "" {
+				assert.Nil(t, u)
+				return
+			}
+			if assert.NotNil(t, u) {
+				assert.Equal(t, tc.expected, u.Username)
+			}
+		})
+	}
+
+	// admin tokens are refused on /verify
+	r := httptest.NewRequest("GET", "/verify", nil)
+	r.Header.Set("Authorization", "Bearer gfa_test_admin")
+	assert.Nil(t, GetValidUserFromToken(r, "any.url.com"))
+}
+
+func TestAdminApiHandler(t *testing.T) {
+	backup := configuration.db
+	defer func() { configuration.db = backup }()
+
+	// no database
+	configuration.db = nil
+	status, _ := adminRequestTest(t, "gfa_test_admin", "GET", adminApiPrefix+"users", "")
+	assert.Equal(t, http.StatusNotImplemented, status)
+
+	configuration.db = newTestSqlStore(t)
+	pwdBackup := configuration.PasswordHash
+	defer func() { configuration.PasswordHash = pwdBackup }()
+	configuration.PasswordHash = PasswordHash{Algorithm: HashBcrypt, BcryptCost: 4}
+
+	testCases := []struct {
+		name           string
+		token          string
+		method         string
+		path           string
+		body           string
+		expectedStatus int
+		expectedBody   string
+	}{
+		{"UNAUTHORIZED", "gfa_test_token", "GET", "users", "", http.StatusUnauthorized, `{"error":"admin: unauthorized"}`},
+		{"EMPTY_LIST", "gfa_test_admin", "GET", "users", "", http.StatusOK, `[]`},
+		{"CREATE", "gfa_test_admin", "POST", "users", `{"username":"bob","password":"secretpass","allowedDomains":["bob.com"],"groups":["dev"]}`, http.StatusCreated,
+			`{"username":"bob","allowedDomains":["bob.com"],"groups":["dev"],"admin":false,"disabled":false,"mustChangePassword":false}`},
+		{"CREATE_EXISTING", "gfa_test_admin", "POST", "users", `{"username":"bob","password":"secretpass"}`, http.StatusConflict, `{"error":"admin: user already exists"}`},
+		{"CREATE_NO_PASSWORD", "gfa_test_admin", "POST", "users", `{"username":"alice"}`, http.StatusBadRequest, `{"error":"admin: username and password are mandatory"}`},
+		{"CREATE_WEAK_PASSWORD", "gfa_test_admin", "POST", "users", `{"username":"alice","password":"short"}`, http.StatusBadRequest, `{"error":"Password must be at least 8 characters long"}`},
+		{"CREATE_BAD_DOMAIN", "gfa_test_admin", "POST", "users", `{"username":"alice","password":"secretpass","allowedDomains":["re:("]}`, http.StatusBadRequest, `{"error":"admin: bad allowedDomains\n\t-> domain: bad pattern 're:('\n\t-> error parsing regexp: missing closing ): ` + "`(?i)^(?:()$`" + `"}`},
+		{"CREATE_UNKNOWN_FIELD", "gfa_test_admin", "POST", "users", `{"username":"alice","password":"secret","nope":1}`, http.StatusBadRequest, ""},
+		{"GET", "gfa_test_admin", "GET", "users/bob", "", http.StatusOK,
+			`{"username":"bob","allowedDomains":["bob.com"],"groups":["dev"],"admin":false,"disabled":false,"mustChangePassword":false}`},
+		{"GET_UNKNOWN", "gfa_test_admin", "GET", "users/nope", "", http.StatusNotFound, `{"error":"db: not found"}`},
+		{"SET_GROUP", "gfa_test_admin", "PUT", "groups/dev", `{"allowedDomains":["git.dev.com"]}`, http.StatusOK, `{"allowedDomains":["git.dev.com"]}`},
+		{"SET_GROUP_BAD_DOMAIN", "gfa_test_admin", "PUT", "groups/dev", `{"allowedDomains":["re:("]}`, http.StatusBadRequest, `{"error":"admin: bad allowedDomains\n\t-> domain: bad pattern 're:('\n\t-> error parsing regexp: missing closing ): ` + "`(?i)^(?:()$`" + `"}`},
+		{"LIST_GROUPS", "gfa_test_admin", "GET", "groups", "", http.StatusOK, `{"dev":{"allowedDomains":["git.dev.com"]}}`},
+		{"UPDATE", "gfa_test_admin", "PATCH", "users/bob", `{"allowedDomains":["bob.org"],"admin":true}`, http.StatusOK,
+			`{"username":"bob","allowedDomains":["bob.org"],"groups":["dev"],"admin":true,"disabled":false,"mustChangePassword":false}`},
+		{"UPDATE_EMAIL", "gfa_test_admin", "PATCH", "users/bob", `{"email":"bob@bob.org"}`, http.StatusOK,
+			`{"username":"bob","email":"bob@bob.org","allowedDomains":["bob.org"],"groups":["dev"],"admin":true,"disabled":false,"mustChangePassword":false}`},
+		// nothing is applied, email is unchanged
+		{"UPDATE_BAD_DOMAIN", "gfa_test_admin", "PATCH", "users/bob", `{"email":"bad@bob.org","allowedDomains":["re:("]}`, http.StatusBadRequest, `{"error":"admin: bad allowedDomains\n\t-> domain: bad pattern 're:('\n\t-> error parsing regexp: missing closing ): ` + "`(?i)^(?:()$`" + `"}`},
+		{"UPDATE_VALIDITY", "gfa_test_admin", "PATCH", "users/bob", `{"expiresAt":"2030-01-02T00:00:00Z","mustChangePassword":true}`, http.StatusOK,
+			`{"username":"bob","email":"bob@bob.org","allowedDomains":["bob.org"],"groups":["dev"],"admin":true,"disabled":false,"expiresAt":"2030-01-02T00:00:00Z","mustChangePassword":true}`},
+		{"CLEAR_VALIDITY", "gfa_test_admin", "PATCH", "users/bob", `{"expiresAt":"0001-01-01T00:00:00Z","mustChangePassword":false}`, http.StatusOK,
+			`{"username":"bob","email":"bob@bob.org","allowedDomains":["bob.org"],"groups":["dev"],"admin":true,"disabled":false,"mustChangePassword":false}`},
+		{"UPDATE_LANGUAGE", "gfa_test_admin", "PATCH", "users/bob", `{"language":"fr"}`, http.StatusOK,
+			`{"username":"bob","email":"bob@bob.org","allowedDomains":["bob.org"],"groups":["dev"],"admin":true,"disabled":false,"mustChangePassword":false,"language":"fr"}`},
+		{"UPDATE_BAD_LANGUAGE", "gfa_test_admin", "PATCH", "users/bob", `{"language":"xx"}`, http.StatusBadRequest, `{"error":"i18n: unsupported language"}`},
+		{"CLEAR_LANGUAGE", "gfa_test_admin", "PATCH", "users/bob", `{"language":""}`, http.StatusOK,
+			`{"username":"bob","email":"bob@bob.org","allowedDomains":["bob.org"],"groups":["dev"],"admin":true,"disabled":false,"mustChangePassword":false}`},
+		{"UPDATE_UNKNOWN", "gfa_test_admin", "PATCH", "users/nope", `{"admin":true}`, http.StatusNotFound, `{"error":"db: not found"}`},
+		{"DISABLE_SELF", "gfa_test_admin", "PATCH", "users/admin", `{"disabled":true}`, http.StatusNotFound, `{"error":"db: not found"}`},
+		{"PASSWORD", "gfa_test_admin", "POST", "users/bob/password", `{"password":"newsecret"}`, http.StatusNoContent, ""},
+		{"PASSWORD_EMPTY", "gfa_test_admin", "POST", "users/bob/password", `{}`, http.StatusBadRequest, `{"error":"admin: password is mandatory"}`},
+		{"PASSWORD_WEAK", "gfa_test_admin", "POST", "users/bob/password", `{"password":"Bob12345678"}`, http.StatusBadRequest, `{"error":"Password must not contain username"}`},
+		{"PASSWORD_UNKNOWN", "gfa_test_admin", "POST", "users/nope/password", `{"password":"newsecret"}`, http.StatusNotFound, `{"error":"db: not found"}`},
+		{"REVOKE_UNKNOWN_SESSION", "gfa_test_admin", "DELETE", "sessions/nope", "", http.StatusNotFound, `{"error":"db: not found"}`},
+		{"DELETE_SELF", "gfa_test_admin", "DELETE", "users/admin", "", http.StatusConflict, `{"error":"admin: can't delete yourself"}`},
+		{"DELETE_GROUP", "gfa_test_admin", "DELETE", "groups/dev", "", http.StatusNoContent, ""},
+		{"DELETE", "gfa_test_admin", "DELETE", "users/bob", "", http.StatusNoContent, ""},
+		{"DELETE_UNKNOWN", "gfa_test_admin", "DELETE", "users/bob", "", http.StatusNotFound, `{"error":"db: not found"}`},
+		{"BAD_METHOD", "gfa_test_admin", "PUT", "users", "", http.StatusMethodNotAllowed, `{"error":"admin: method not allowed"}`},
+		{"BAD_PATH", "gfa_test_admin", "GET", "nope", "", http.StatusNotFound, `{"error":"admin: not found"}`},
+	}
+	// steps depend on each other, so no parallel run
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			status, body := adminRequestTest(t, tc.token, tc.method, adminApiPrefix+tc.path, tc.body)
+			assert.Equal(t, tc.expectedStatus, status)
+			if tc.expectedBody != "" {
+				b, _ := json.Marshal(body)
+				assert.JSONEq(t, tc.expectedBody, string(b))
+			}
+		})
+	}
+}
+
+func TestAdminApiSessions(t *testing.T) {
+	backup := configuration.db
+	defer func() { configuration.db = backup }()
+	s := newTestSqlStore(t)
+	configuration.db = s
+	pwdBackup := configuration.PasswordHash
+	defer func() { configuration.PasswordHash = pwdBackup }()
+	configuration.PasswordHash = PasswordHash{Algorithm: HashBcrypt, BcryptCost: 4}
+
+	assert.NoError(t, s.CreateUser(&User{Username: "bob", Password: "hash", AllowedDomains: []string{"url.net"}}))
+	c1 := CreateJwtCookie("bob", "1.2.3.4", []string{"url.net"})
+	c2 := CreateJwtCookie("bob", "1.2.3.4", []string{"url.net"})
+	CreateJwtCookie("jean", "1.2.3.4", []string{"url.net"})
+
+	status, body := adminRequestTest(t, "gfa_test_admin", "GET", adminApiPrefix+"users/bob/sessions", "")
+	assert.Equal(t, http.StatusOK, status)
+	assert.Len(t, body, 2)
+	_, body = adminRequestTest(t, "gfa_test_admin", "GET", adminApiPrefix+"sessions", "")
+	assert.Len(t, body, 3)
+
+	// revoke a single session
+	cl := GetValidJwtClaims(c1, "1.2.3.4", "url.net")
+	if assert.NotNil(t, cl) {
+		status, _ = adminRequestTest(t, "gfa_test_admin", "DELETE", adminApiPrefix+"sessions/"+cl.ID, "")
+		assert.Equal(t, http.StatusNoContent, status)
+		assert.Nil(t, GetValidJwtClaims(c1, "1.2.3.4", "url.net"))
+		assert.NotNil(t, GetValidJwtClaims(c2, "1.2.3.4", "url.net"))
+	}
+
+	// disabling user revokes its sessions, and user can't log in anymore
+	status, _ = adminRequestTest(t, "gfa_test_admin", "PATCH", adminApiPrefix+"users/bob", `{"disabled":true}`)
+	assert.Equal(t, http.StatusOK, status)
+	assert.Nil(t, GetValidJwtClaims(c2, "1.2.3.4", "url.net"))
+	assert.Nil(t, GetUser("bob"))
+	assert.NotNil(t, s.GetUser("bob"))
+
+	// revoke all sessions of a user
+	status, _ = adminRequestTest(t, "gfa_test_admin", "DELETE", adminApiPrefix+"users/jean/sessions", "")
+	assert.Equal(t, http.StatusNoContent, status)
+	_, body = adminRequestTest(t, "gfa_test_admin", "GET", adminApiPrefix+"sessions", "")
+	assert.Len(t, body, 0)
+}
+
+func TestSkipCsrfForAdminApi(t *testing.T) {
+	CSRF := csrf.Protect([]byte(configuration.CsrfSecretKey), csrf.Secure(true))
+	h := SkipCsrfForAdminApi(CSRF(NewRouter(nil)))
+
+	// admin API is reached without csrf token
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("POST", adminApiPrefix+"users", strings.NewReader("{}")))
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+
+	// other endpoints are still protected
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("POST", "/", nil))
+	assert.Equal(t, http.StatusForbidden, w.Code)
+}
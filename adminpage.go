@@ -0,0 +1,140 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/csrf"
+	"go.uber.org/zap"
+)
+
+// path of the admin page
+const adminPagePath = "/admin/"
+
+// return admin user from the jwt cookie of the request, nil if none or not admin
+func GetAdminFromCookie(r *http.Request) *User {
+	c, _ := r.Cookie(configuration.CookieName)
+	cl := GetValidJwtClaims(c, GetIp(r), GetHost(r))
+	if cl == nil || cl.ChangePassword {
+		return nil
+	}
+	u := GetUser(cl.Subject)
+	if u == nil || !u.Admin {
+		log.Error("admin: user is not admin", zap.String("username", cl.Subject))
+		return nil
+	}
+	return u
+}
+
+// render admin page, and apply posted action
+func AdminPageHandler(w http.ResponseWriter, r *http.Request) {
+
+	ip := GetIp(r)
+	log.Sugar().Debug("admin: page requested", zap.String("ip", ip), "request", RedactRequest(r))
+
+	admin := GetAdminFromCookie(r)
+	if admin == nil {
+		// prevent bruteforce with sleeptime
+		time.Sleep(500 * time.Millisecond)
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+	if r.URL.Path != adminPagePath {
+		http.NotFound(w, r)
+		return
+	}
+
+	data := map[string]interface{}{
+		"username": admin.Username,
+		"ip":       ip,
+		"csrf":     csrf.TemplateField(r),
+		"database": configuration.db != nil,
+		"nonce":    CspNonce(r),
+	}
+
+	status := http.StatusOK
+	if r.Method == http.MethodPost {
+		if err := AdminPageAction(admin, r.PostFormValue("action"), r.PostFormValue("target"), ip); err != nil {
+			status = http.StatusBadRequest
+			data["error"] = err.Error()
+		} else {
+			data["message"] = "Done"
+		}
+	}
+
+	if db := configuration.db; db != nil {
+		users, err := db.ListUsers()
+		if err != nil {
+			log.Error("admin: store error", zap.Error(err))
+		}
+		sessions, err := db.ListSessions("")
+		if err != nil {
+			log.Error("admin: store error", zap.Error(err))
+		}
+		data["users"] = users
+		data["sessions"] = sessions
+	}
+	data["events"] = auditLog.Recent()
+	data["lockouts"] = lockouts.List()
+
+	t, err := templates.Get(configuration.AdminHtmlFile, defaultLanguage)
+	if err != nil {
+		log.Error("admin: template error", zap.Error(err))
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(status)
+	if err := t.Execute(w, data); err != nil {
+		log.Error("admin: template error", zap.Error(err))
+	}
+}
+
+// apply an action of the admin page on target
+func AdminPageAction(admin *User, action, target, ip string) error {
+	if target == "" {
+		return errors.New("admin: missing target")
+	}
+	if action == "unlock" {
+		lockouts.Unlock(target)
+		auditLog.Add(admin.Username, "user unlocked", target, ip)
+		return nil
+	}
+
+	db := configuration.db
+	if db == nil {
+		return errors.New("admin: no database configured")
+	}
+	var err error
+	switch action {
+	case "disable", "enable":
+		if target == admin.Username {
+			return errors.New("admin: can't disable yourself")
+		}
+		u := db.GetUser(target)
+		if u == nil {
+			return ErrNotFound
+		}
+		if err = db.SetUserFlags(target, u.Admin, action == "disable"); err == nil && action == "disable" {
+			err = db.RevokeUserSessions(target, "")
+		}
+	case "delete":
+		if target == admin.Username {
+			return errors.New("admin: can't delete yourself")
+		}
+		if err = db.DeleteUser(target); err == nil {
+			err = db.RevokeUserSessions(target, "")
+		}
+	case "revoke":
+		err = db.RevokeSession(target)
+	case "revokeall":
+		err = db.RevokeUserSessions(target, "")
+	default:
+		return errors.New("admin: unknown action")
+	}
+	if err != nil {
+		return err
+	}
+	auditLog.Add(admin.Username, "admin "+action, target, ip)
+	return nil
+}
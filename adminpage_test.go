@@ -0,0 +1,117 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// call admin page as user, with posted form if any
+func adminPageRequestTest(username, method, path string, form url.Values) *httptest.ResponseRecorder {
+	var r *http.Request
+	if form != nil {
+		r = httptest.NewRequest(method, path, strings.NewReader(form.Encode()))
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	} else {
+		r = httptest.NewRequest(method, path, nil)
+	}
+	r.Header.Set("X-Real-IP", "1.2.3.4")
+	r.Header.Set("X-Forwarded-Host", "url.net")
+	if username != "" {
+		r.AddCookie(CreateJwtCookie(username, "1.2.3.4", []string{"url.net"}))
+	}
+	w := httptest.NewRecorder()
+	AdminPageHandler(w, r)
+	return w
+}
+
+func TestAdminPageHandler(t *testing.T) {
+	backup := configuration.db
+	defer func() { configuration.db = backup }()
+
+	// without database
+	configuration.db = nil
+	assert.Equal(t, http.StatusForbidden, adminPageRequestTest("", "GET", adminPagePath, nil).Code)
+	assert.Equal(t, http.StatusForbidden, adminPageRequestTest("jean", "GET", adminPagePath, nil).Code)
+	assert.Equal(t, http.StatusNotFound, adminPageRequestTest("admin", "GET", adminPagePath+"nope", nil).Code)
+	w := adminPageRequestTest("admin", "GET", adminPagePath, nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), "No database configured")
+
+	// with database
+	s := newTestSqlStore(t)
+	configuration.db = s
+	assert.NoError(t, s.CreateUser(&User{Username: "bob", Password: "hash", AllowedDomains: []string{"bob.com"}}))
+	lockouts.Fail("jean", 1, time.Minute)
+	defer lockouts.Unlock("jean")
+
+	w = adminPageRequestTest("admin", "GET", adminPagePath, nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+	body := w.Body.String()
+	assert.Contains(t, body, "bob.com")
+	assert.Contains(t, body, `value="jean"`)
+	assert.Contains(t, body, "Recent events")
+
+	w = adminPageRequestTest("admin", "POST", adminPagePath, url.Values{"action": {"disable"}, "target": {"bob"}})
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.True(t, s.GetUser("bob").Disabled)
+	assert.Equal(t, "admin disable", auditLog.Recent()[0].Action)
+
+	w = adminPageRequestTest("admin", "POST", adminPagePath, url.Values{"action": {"nope"}, "target": {"bob"}})
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Contains(t, w.Body.String(), "unknown action")
+}
+
+func TestAdminPageAction(t *testing.T) {
+	backup := configuration.db
+	defer func() { configuration.db = backup }()
+	admin := &User{Username: "admin", Admin: true}
+
+	configuration.db = nil
+	assert.ErrorContains(t, AdminPageAction(admin, "disable", "", ""), "missing target")
+	assert.ErrorContains(t, AdminPageAction(admin, "disable", "bob", ""), "no database")
+	lockouts.Fail("bob", 1, time.Minute)
+	assert.NoError(t, AdminPageAction(admin, "unlock", "bob", ""))
+	assert.False(t, lockouts.Locked("bob"))
+
+	s := newTestSqlStore(t)
+	configuration.db = s
+	assert.NoError(t, s.CreateUser(&User{Username: "bob", Password: "hash"}))
+	assert.NoError(t, s.CreateSession("id", "bob", "1.2.3.4", time.Now().Add(time.Hour)))
+	assert.NoError(t, s.CreateSession("id2", "bob", "1.2.3.4", time.Now().Add(time.Hour)))
+
+	testCases := []struct {
+		name                  string
+		action                string
+		target                string
+		expectedErrorContains string
+	}{
+		{"DISABLE_SELF", "disable", "admin", "yourself"},
+		{"DELETE_SELF", "delete", "admin", "yourself"},
+		{"DISABLE_UNKNOWN", "disable", "nope", "not found"},
+		{"DISABLE", "disable", "bob", ""},
+		{"ENABLE", "enable", "bob", ""},
+		{"REVOKE", "revoke", "id", ""},
+		{"REVOKE_UNKNOWN", "revoke", "nope", "not found"},
+		{"REVOKE_ALL", "revokeall", "bob", ""},
+		{"DELETE", "delete", "bob", ""},
+		{"DELETE_UNKNOWN", "delete", "bob", "not found"},
+	}
+	// steps depend on each other, so no parallel run
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			err := AdminPageAction(admin, tc.action, tc.target, "")
+			if tc.expectedErrorContains != "" {
+				assert.ErrorContains(t, err, tc.expectedErrorContains)
+				return
+			}
+			assert.NoError(t, err)
+		})
+	}
+	assert.True(t, s.SessionRevoked("id2"))
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// number of events kept in memory
+const auditLogSize = 200
+
+type AuditEvent struct {
+	Time   time.Time
+	Actor  string
+	Action string
+	Target string
+	Ip     string
+}
+
+// ring buffer of recent security events, for the admin page
+type AuditLog struct {
+	mu     sync.Mutex
+	events []AuditEvent
+	next   int
+}
+
+var auditLog = &AuditLog{}
+
+// record and log an event
+func (a *AuditLog) Add(actor, action, target, ip string) {
+	log.Info("audit: "+action, zap.String("actor", actor), zap.String("target", target), zap.String("ip", ip))
+	e := AuditEvent{Time: time.Now(), Actor: actor, Action: action, Target: target, Ip: ip}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if len(a.events) < auditLogSize {
+		a.events = append(a.events, e)
+		return
+	}
+	a.events[a.next] = e
+	a.next = (a.next + 1) % auditLogSize
+}
+
+// return events, newest first
+func (a *AuditLog) Recent() []AuditEvent {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	ret := make([]AuditEvent, 0, len(a.events))
+	for i := len(a.events) - 1; i >= 0; i-- {
+		ret = append(ret, a.events[(a.next+i)%len(a.events)])
+	}
+	return ret
+}
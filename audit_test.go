@@ -0,0 +1,30 @@
+package main
+
+import (
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAuditLog(t *testing.T) {
+	a := &AuditLog{}
+	assert.Empty(t, a.Recent())
+
+	a.Add("admin", "login", "url.net", "1.2.3.4")
+	a.Add("jean", "logout", "url.net", "1.2.3.4")
+	events := a.Recent()
+	if assert.Len(t, events, 2) {
+		assert.Equal(t, "logout", events[0].Action)
+		assert.Equal(t, "admin", events[1].Actor)
+	}
+
+	// oldest events are dropped
+	for i := 0; i < auditLogSize+10; i++ {
+		a.Add("user", "login", strconv.Itoa(i), "")
+	}
+	events = a.Recent()
+	assert.Len(t, events, auditLogSize)
+	assert.Equal(t, strconv.Itoa(auditLogSize+9), events[0].Target)
+	assert.Equal(t, "10", events[auditLogSize-1].Target)
+}
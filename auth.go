@@ -1,32 +1,126 @@
 package main
 
-import "go.uber.org/zap"
+import (
+	"errors"
+	"time"
+
+	"go.uber.org/zap"
+)
 
 type User struct {
 	Username       string
-	Password       string   `koanf:"Password"`
-	AllowedDomains []string `koanf:"AllowedDomains"`
+	Password       string               `koanf:"Password"`
+	AllowedDomains []string             `koanf:"AllowedDomains"`
+	Email          string               `koanf:"Email"`
+	Tokens         map[string]*ApiToken `koanf:"Tokens"`
+	Admin          bool                 `koanf:"Admin"`
+	Disabled       bool                 `koanf:"Disabled"`
+	// account validity period, RFC3339 dates (optional)
+	NotBefore time.Time `koanf:"NotBefore"`
+	ExpiresAt time.Time `koanf:"ExpiresAt"`
+	// user has to change password before accessing domains
+	MustChangePassword bool `koanf:"MustChangePassword"`
+	// language of login pages, overriding browser preference (optional)
+	Language string `koanf:"Language"`
+	// groups granting domains, database users only
+	Groups []string
+	// store the user comes from
+	store UserStore
 }
 
+// user facing errors of authentication
+var (
+	ErrBadCredentials     = errors.New("Bad credentials")
+	ErrUserDisabled       = errors.New("Account disabled")
+	ErrUserExpired        = errors.New("Account expired")
+	ErrUserNotYetActive   = errors.New("Account not active yet")
+	ErrMustChangePassword = errors.New("You must change your password")
+	ErrPasswordExpired    = errors.New("Password expired, contact an administrator")
+	ErrUnauthorizedAccess = errors.New("Unauthorized access")
+	ErrRestrictedArea     = errors.New("Restricted Area")
+)
+
 // Return valid user password and ip
 func GetValidUser(username, password, url string) *User {
+	u, _ := AuthenticateUser(username, password, url)
+	return u
+}
 
-	u := GetUser(username)
+// return user if password is valid, or the error to show
+// account status is only disclosed once password is verified
+func AuthenticateUser(username, password, url string) (*User, error) {
+
+	u := lookupUser(username)
 	if u == nil {
 		log.Info("user: not found", zap.String("username", username))
-		return nil
+		return nil, ErrBadCredentials
+	}
+
+	if lockouts.Locked(username) {
+		// compare anyway, so response time doesn't disclose the lock
+		CompareHash(u.Password, password)
+		log.Error("user: locked", zap.String("username", username))
+		return nil, ErrBadCredentials
 	}
 
 	if !CompareHash(u.Password, password) {
 		log.Error("user: bad password", zap.String("username", username))
-		return nil
+		if lockouts.Fail(username, configuration.LockoutThreshold, configuration.LockoutDuration*time.Minute) {
+			auditLog.Add(username, "user locked", username, "")
+		}
+		return nil, ErrBadCredentials
+	}
+	lockouts.Unlock(username)
+
+	if err := u.Active(); err != nil {
+		log.Error("user: inactive", zap.String("username", username), zap.Error(err))
+		return nil, err
+	}
+	// password can't be changed, so user would be stuck
+	if _, ok := u.store.(PasswordStore); u.MustChangePassword && !ok {
+		log.Error("user: password must be changed on read-only store", zap.String("username", username))
+		return nil, ErrPasswordExpired
 	}
 
 	if !u.Allowed(url) {
-		return nil
+		return nil, ErrBadCredentials
 	}
 
-	return u
+	if configuration.PasswordHash.NeedsRehash(u.Password) {
+		u.Rehash(password)
+	}
+
+	return u, nil
+}
+
+// return an user facing error if account is disabled, expired or not active yet
+func (u *User) Active() error {
+	now := time.Now()
+	switch {
+	case u.Disabled:
+		return ErrUserDisabled
+	case !u.NotBefore.IsZero() && now.Before(u.NotBefore):
+		return ErrUserNotYetActive
+	case !u.ExpiresAt.IsZero() && !now.Before(u.ExpiresAt):
+		return ErrUserExpired
+	}
+	return nil
+}
+
+// hash password with current parameters and save it, if user store is writable
+func (u *User) Rehash(password string) {
+	if _, ok := u.store.(PasswordStore); !ok {
+		log.Debug("user: outdated password hash, but store is read-only", zap.String("username", u.Username))
+		return
+	}
+	h, err := configuration.PasswordHash.Hash(password)
+	if err != nil {
+		log.Error("user: error hashing password", zap.String("username", u.Username), zap.Error(err))
+		return
+	}
+	if err := u.SetPassword(h); err != nil {
+		log.Error("user: error saving rehashed password", zap.String("username", u.Username), zap.Error(err))
+	}
 }
 
 // verify user allowed domains
@@ -37,19 +131,3 @@ func (u *User) Allowed(url string) (ret bool) {
 	}
 	return ret
 }
-
-// find user from configuration
-func GetUser(username string) *User {
-	if configuration.Users == nil || len(configuration.Users) == 0 {
-		log.Info("user: no user configured")
-		return nil
-	}
-
-	u, ok := configuration.Users[username]
-	if !ok {
-		return nil
-	}
-
-	u.Username = username
-	return u
-}
@@ -2,6 +2,7 @@ package main
 
 import (
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 )
@@ -28,8 +29,13 @@ func TestGetValidUser(t *testing.T) {
 		t.Run(tc.name, func(t *testing.T) {
 			t.Parallel()
 			user := GetValidUser(tc.username, tc.password, tc.url)
-			assert.Equal(t, tc.expecteduser, user)
-			if user != nil {
+			if tc.expecteduser == nil {
+				assert.Nil(t, user)
+				return
+			}
+			// copy of configured user, with its username
+			if assert.NotNil(t, user) {
+				assert.Equal(t, tc.expecteduser.Password, user.Password)
 				assert.Equal(t, tc.username, user.Username)
 			}
 		})
@@ -53,8 +59,13 @@ func TestGetUser(t *testing.T) {
 		t.Run(tc.name, func(t *testing.T) {
 			t.Parallel()
 			user := GetUser(tc.username)
-			assert.Equal(t, tc.expecteduser, user)
-			if user != nil {
+			if tc.expecteduser == nil {
+				assert.Nil(t, user)
+				return
+			}
+			// copy of configured user, with its username
+			if assert.NotNil(t, user) {
+				assert.Equal(t, tc.expecteduser.Password, user.Password)
 				assert.Equal(t, tc.username, user.Username)
 			}
 		})
@@ -87,3 +98,116 @@ func TestAllowed(t *testing.T) {
 		})
 	}
 }
+
+func TestGetValidUserLockout(t *testing.T) {
+	backup := configuration.LockoutThreshold
+	defer func() { configuration.LockoutThreshold = backup; lockouts.Unlock("admin") }()
+	configuration.LockoutThreshold = 2
+
+	assert.Nil(t, GetValidUser("admin", "bad", "any.url.com"))
+	// success resets failures
+	assert.NotNil(t, GetValidUser("admin", "pass", "any.url.com"))
+	assert.Nil(t, GetValidUser("admin", "bad", "any.url.com"))
+	assert.NotNil(t, GetValidUser("admin", "pass", "any.url.com"))
+
+	assert.Nil(t, GetValidUser("admin", "bad", "any.url.com"))
+	assert.Nil(t, GetValidUser("admin", "bad", "any.url.com"))
+	// locked, even with good password
+	assert.Nil(t, GetValidUser("admin", "pass", "any.url.com"))
+	assert.Equal(t, "user locked", auditLog.Recent()[0].Action)
+	lockouts.Unlock("admin")
+	assert.NotNil(t, GetValidUser("admin", "pass", "any.url.com"))
+}
+
+func TestRehash(t *testing.T) {
+	backup := configuration.PasswordHash
+	defer func() { configuration.PasswordHash = backup }()
+	configuration.PasswordHash = PasswordHash{Algorithm: "argon2id", Argon2Memory: 1024, Argon2Iterations: 1, Argon2Parallelism: 1}
+
+	old := "$2y$10$t6XPeRTf5.a.Gb3I/lYq7ukuOpx6fsJRstEXNfOP4jXjjGGZ2Af72"
+
+	// writable store
+	s := &testPasswordStore{users: map[string]*User{"admin": {Password: old}}}
+	u := s.GetUser("admin")
+	u.store = s
+	u.Rehash("pass")
+	assert.Equal(t, HashArgon2id, GetHashAlgorithm(s.users["admin"].Password))
+	assert.True(t, CompareHash(u.Password, "pass"))
+	assert.False(t, configuration.PasswordHash.NeedsRehash(u.Password))
+
+	// read-only store
+	u = &User{Username: "admin", Password: old, store: ConfigUserStore{}}
+	u.Rehash("pass")
+	assert.Equal(t, old, u.Password)
+}
+
+func TestUserActive(t *testing.T) {
+	past, future := time.Now().Add(-time.Hour), time.Now().Add(time.Hour)
+	testCases := []struct {
+		name     string
+		user     *User
+		expected error
+	}{
+		{"NOMINAL", &User{}, nil},
+		{"VALID_PERIOD", &User{NotBefore: past, ExpiresAt: future}, nil},
+		{"DISABLED", &User{Disabled: true, ExpiresAt: future}, ErrUserDisabled},
+		{"EXPIRED", &User{ExpiresAt: past}, ErrUserExpired},
+		{"NOT_YET_ACTIVE", &User{NotBefore: future}, ErrUserNotYetActive},
+	}
+	for _, tc := range testCases {
+		// shadow the test case to avoid modifying the test case
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			assert.Equal(t, tc.expected, tc.user.Active())
+		})
+	}
+}
+
+func TestAuthenticateUser(t *testing.T) {
+	backup := configuration.db
+	defer func() { configuration.db = backup }()
+	s := newTestSqlStore(t)
+	configuration.db = s
+	hash := GetHash("password")
+	for _, u := range []*User{
+		{Username: "disabled", Disabled: true},
+		{Username: "expired", ExpiresAt: time.Now().Add(-time.Minute)},
+		{Username: "future", NotBefore: time.Now().Add(time.Hour)},
+		{Username: "mustchange", MustChangePassword: true},
+	} {
+		u.Password, u.AllowedDomains = hash, []string{"url.net"}
+		assert.NoError(t, s.CreateUser(u))
+	}
+	configuration.Users["readonly"] = &User{Password: hash, AllowedDomains: []string{"url.net"}, MustChangePassword: true}
+	defer delete(configuration.Users, "readonly")
+
+	testCases := []struct {
+		name          string
+		username      string
+		password      string
+		expectedError error
+	}{
+		{"NOMINAL", "jean", "pwd", nil},
+		{"BAD_PASSWORD", "jean", "bad", ErrBadCredentials},
+		{"UNKNOWN", "nope", "pwd", ErrBadCredentials},
+		// status is not disclosed without the right password
+		{"DISABLED_BAD_PASSWORD", "disabled", "bad", ErrBadCredentials},
+		{"DISABLED", "disabled", "password", ErrUserDisabled},
+		{"EXPIRED", "expired", "password", ErrUserExpired},
+		{"NOT_YET_ACTIVE", "future", "password", ErrUserNotYetActive},
+		{"MUST_CHANGE", "mustchange", "password", nil},
+		{"MUST_CHANGE_READ_ONLY", "readonly", "password", ErrPasswordExpired},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			u, err := AuthenticateUser(tc.username, tc.password, "url.net")
+			assert.Equal(t, tc.expectedError, err)
+			assert.Equal(t, tc.expectedError == nil, u != nil)
+		})
+	}
+
+	// inactive users are unknown elsewhere
+	assert.Nil(t, GetUser("expired"))
+	assert.NotNil(t, lookupUser("expired"))
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"crypto/sha256"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// cache of successful basic auth verifications, to avoid running bcrypt on every request
+type BasicAuthCache struct {
+	mu      sync.Mutex
+	entries map[[32]byte]basicAuthCacheEntry
+}
+
+type basicAuthCacheEntry struct {
+	username string
+	expire   time.Time
+}
+
+var basicAuthCache = &BasicAuthCache{}
+
+// cache key, credentials are never stored in clear
+func basicAuthCacheKey(username, password, url string) [32]byte {
+	return sha256.Sum256([]byte(username + "\x00" + password + "\x00" + url))
+}
+
+// return true if credentials were validated less than ttl ago
+func (c *BasicAuthCache) Get(username, password, url string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e, ok := c.entries[basicAuthCacheKey(username, password, url)]
+	return ok && time.Now().Before(e.expire)
+}
+
+// remember valid credentials for ttl, and purge expired entries
+func (c *BasicAuthCache) Set(username, password, url string, ttl time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := time.Now()
+	if c.entries == nil {
+		c.entries = map[[32]byte]basicAuthCacheEntry{}
+	}
+	for k, e := range c.entries {
+		if now.After(e.expire) {
+			delete(c.entries, k)
+		}
+	}
+	c.entries[basicAuthCacheKey(username, password, url)] = basicAuthCacheEntry{username: username, expire: now.Add(ttl)}
+}
+
+// forget credentials of user, so an old password stops working when it is changed
+func (c *BasicAuthCache) Forget(username string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for k, e := range c.entries {
+		if e.username == username {
+			delete(c.entries, k)
+		}
+	}
+}
+
+// return true if basic auth is enabled for url
+func BasicAuthEnabled(url string) bool {
+	return len(configuration.BasicAuthDomains) > 0 && CompareDomains(configuration.BasicAuthDomains, url)
+}
+
+// return user from basic auth credentials of the request, nil if none or invalid
+func GetValidUserFromBasicAuth(username, password, url string) *User {
+	if username == "" || password == "" {
+		return nil
+	}
+	ttl := configuration.BasicAuthCacheTtl * time.Minute
+	if ttl > 0 && basicAuthCache.Get(username, password, url) {
+		// user may have been removed since last verification
+		if u := GetUser(username); u != nil && !u.MustChangePassword && u.Allowed(url) {
+			log.Debug("basic: cached credentials", zap.String("username", username))
+			return u
+		}
+	}
+	u := GetValidUser(username, password, url)
+	// password can't be changed with basic auth
+	if u != nil && u.MustChangePassword {
+		log.Error("basic: password must be changed", zap.String("username", username))
+		return nil
+	}
+	if u != nil && ttl > 0 {
+		basicAuthCache.Set(username, password, url, ttl)
+	}
+	return u
+}
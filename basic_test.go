@@ -0,0 +1,115 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBasicAuthCache(t *testing.T) {
+	c := &BasicAuthCache{}
+	assert.False(t, c.Get("jean", "pwd", "url.net"))
+
+	c.Set("jean", "pwd", "url.net", time.Hour)
+	assert.True(t, c.Get("jean", "pwd", "url.net"))
+	assert.False(t, c.Get("jean", "bad", "url.net"))
+	assert.False(t, c.Get("jean", "pwd", "other.net"))
+
+	// expired entries are ignored and purged
+	c.Set("admin", "pass", "url.net", -time.Second)
+	assert.False(t, c.Get("admin", "pass", "url.net"))
+	c.Set("jean", "pwd", "url.net", time.Hour)
+	assert.Len(t, c.entries, 1)
+
+	// credentials of a user are forgotten, whatever the url
+	c.Set("jean", "pwd", "other.net", time.Hour)
+	c.Set("admin", "pass", "url.net", time.Hour)
+	c.Forget("jean")
+	assert.False(t, c.Get("jean", "pwd", "url.net"))
+	assert.False(t, c.Get("jean", "pwd", "other.net"))
+	assert.True(t, c.Get("admin", "pass", "url.net"))
+}
+
+func TestBasicAuthEnabled(t *testing.T) {
+	backup := configuration.BasicAuthDomains
+	defer func() { configuration.BasicAuthDomains = backup }()
+
+	configuration.BasicAuthDomains = nil
+	assert.False(t, BasicAuthEnabled("git.url.net"))
+
+	configuration.BasicAuthDomains = []string{"git.url.net"}
+	assert.True(t, BasicAuthEnabled("git.url.net"))
+	assert.False(t, BasicAuthEnabled("allowed.com"))
+}
+
+func TestGetValidUserFromBasicAuth(t *testing.T) {
+	backup := *configuration
+	cacheBackup := basicAuthCache
+	defer func() { *configuration = backup; basicAuthCache = cacheBackup }()
+	basicAuthCache = &BasicAuthCache{}
+	configuration.BasicAuthCacheTtl = 60
+
+	assert.Nil(t, GetValidUserFromBasicAuth("", "", "url.net"))
+	assert.Nil(t, GetValidUserFromBasicAuth("jean", "bad", "url.net"))
+	assert.Nil(t, GetValidUserFromBasicAuth("jean", "pwd", "forbidden.net"))
+	assert.Empty(t, basicAuthCache.entries)
+
+	u := GetValidUserFromBasicAuth("jean", "pwd", "url.net")
+	if assert.NotNil(t, u) {
+		assert.Equal(t, "jean", u.Username)
+	}
+	assert.True(t, basicAuthCache.Get("jean", "pwd", "url.net"))
+
+	// cached credentials skip password verification
+	start := time.Now()
+	assert.NotNil(t, GetValidUserFromBasicAuth("jean", "pwd", "url.net"))
+	assert.Less(t, time.Since(start), 50*time.Millisecond)
+
+	// no cache
+	configuration.BasicAuthCacheTtl = 0
+	basicAuthCache = &BasicAuthCache{}
+	assert.NotNil(t, GetValidUserFromBasicAuth("jean", "pwd", "url.net"))
+	assert.Empty(t, basicAuthCache.entries)
+}
+
+func TestVerifyHandlerBasicAuth(t *testing.T) {
+	backup := configuration.BasicAuthDomains
+	defer func() { configuration.BasicAuthDomains = backup }()
+	configuration.BasicAuthDomains = []string{"url.net"}
+
+	testCases := []struct {
+		name             string
+		url              string
+		username         string
+		password         string
+		expectedHttpCode int
+		expectedAuth     bool
+	}{
+		{"NOMINAL", "url.net", "jean", "pwd", http.StatusOK, false},
+		{"BAD_PASSWORD", "url.net", "jean", "bad", http.StatusUnauthorized, true},
+		{"NO_CREDENTIALS", "url.net", "", "", http.StatusUnauthorized, true},
+		{"NOT_ENABLED", "allowed.com", "jean", "pwd", http.StatusForbidden, false},
+	}
+	for _, tc := range testCases {
+		// shadow the test case to avoid modifying the test case
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/verify", nil)
+			req.Host = tc.url
+			if tc.username != "" {
+				req.SetBasicAuth(tc.username, tc.password)
+			}
+			w := httptest.NewRecorder()
+			http.HandlerFunc(VerifyHandler)(w, req)
+			resp := w.Result()
+			assert.Equal(t, tc.expectedHttpCode, resp.StatusCode)
+			assert.Equal(t, tc.expectedAuth, resp.Header.Get("WWW-Authenticate") != "")
+			if tc.expectedHttpCode == http.StatusOK {
+				assert.Equal(t, tc.username, resp.Header.Get("Remote-User"))
+			}
+		})
+	}
+}
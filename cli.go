@@ -0,0 +1,419 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/knadh/koanf/v2"
+	flag "github.com/spf13/pflag"
+	"go.uber.org/zap"
+)
+
+// command line subcommand
+type command struct {
+	usage string
+	help  string
+	// command reads configuration, with --config and --log flags
+	config bool
+	// define flags of the command, optional
+	flags func(f *flag.FlagSet)
+	// run command, positional arguments are f.Args()
+	run func(f *flag.FlagSet, in io.Reader, out io.Writer) error
+}
+
+// subcommands, by name
+var commands = map[string]*command{
+	"serve": {
+		usage:  "serve [flags]",
+		help:   "Start the server (default command).",
+		config: true,
+		run:    runServe,
+	},
+	"hash": {
+		usage:  "hash [flags] [password|-]",
+		help:   "Hash a password following PasswordPolicy, read from stdin if not given.",
+		config: true,
+		flags: func(f *flag.FlagSet) {
+			f.String("algorithm", "", "Hash algorithm (bcrypt or argon2id), PasswordHash.Algorithm by default.")
+			f.Int("cost", 0, "Bcrypt cost or argon2id iterations, PasswordHash value by default.")
+		},
+		run: runHash,
+	},
+	"config validate": {
+		usage:  "config validate [flags]",
+		help:   "Check configuration without default values nor unknown keys, exit with an error listing all problems.",
+		config: true,
+		run:    runConfigValidate,
+	},
+	"user add": {
+		usage:  "user add [flags] <username>",
+		help:   "Create a user in DatabaseFile, its password is read from stdin.",
+		config: true,
+		flags: func(f *flag.FlagSet) {
+			f.String("email", "", "User email.")
+			f.StringSlice("domains", nil, "Domains allowed to the user.")
+			f.StringSlice("groups", nil, "Groups of the user.")
+			f.Bool("admin", false, "User is admin.")
+			f.Bool("must-change-password", false, "User has to change password on first login.")
+			f.String("language", "", "Language of login pages (en, fr), browser preference if empty.")
+		},
+		run: runUserAdd,
+	},
+	"user list": {
+		usage:  "user list [flags]",
+		help:   "List users of all stores.",
+		config: true,
+		run:    runUserList,
+	},
+	"token create": {
+		usage: "token create <username>",
+		help:  "Generate an API token, add its hash to the user Tokens.",
+		run:   runTokenCreate,
+	},
+	"token inspect": {
+		usage:  "token inspect [flags] [cookie|-]",
+		help:   "Decode and verify a GFA cookie, read from stdin if not given.",
+		config: true,
+		run:    runTokenInspect,
+	},
+}
+
+// run subcommand named by first arguments, "serve" if none
+func RunCommand(args []string, in io.Reader, out io.Writer) error {
+	name, rest := "serve", args
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		name, rest = args[0], args[1:]
+		if len(rest) > 0 {
+			if _, ok := commands[name+" "+rest[0]]; ok {
+				name, rest = name+" "+rest[0], rest[1:]
+			}
+		}
+	}
+	if name == "help" {
+		printUsage(out)
+		return nil
+	}
+	cmd, ok := commands[name]
+	if !ok {
+		printUsage(out)
+		return errors.New("main: unknown command '" + name + "'")
+	}
+
+	f := flag.NewFlagSet(name, flag.ContinueOnError)
+	f.SetOutput(out)
+	f.Usage = func() {
+		fmt.Fprintf(out, "Usage: go-forward-auth %s\n%s\n\n%s", cmd.usage, cmd.help, f.FlagUsages())
+	}
+	if cmd.flags != nil {
+		cmd.flags(f)
+	}
+	if cmd.config {
+		(&Config{}).defineFlags(f)
+	}
+	if err := f.Parse(rest); err != nil {
+		if err == flag.ErrHelp {
+			return nil
+		}
+		return errors.New("main: bad arguments\n\t-> " + err.Error())
+	}
+	return cmd.run(f, in, out)
+}
+
+// print available subcommands
+func printUsage(out io.Writer) {
+	names := make([]string, 0, len(commands))
+	for n := range commands {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	fmt.Fprintln(out, "Usage: go-forward-auth <command> [flags]")
+	fmt.Fprintln(out, "\nCommands:")
+	w := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+	for _, n := range names {
+		fmt.Fprintf(w, "  %s\t%s\n", commands[n].usage, commands[n].help)
+	}
+	w.Flush()
+	fmt.Fprintln(out, "\nRun 'go-forward-auth <command> --help' for the flags of a command.")
+}
+
+// load configuration of a command other than serve, logging warnings to stderr
+// configuration is validated (with default values) if valid is true
+func loadCommandConfiguration(f *flag.FlagSet, valid bool) (*Config, *koanf.Koanf, error) {
+	atom := initLogger(os.Stderr)
+	atom.SetLevel(zap.WarnLevel)
+	if lvl, _ := f.GetString("log"); lvl != "" {
+		if l, err := zap.ParseAtomicLevel(lvl); err == nil {
+			atom.SetLevel(l.Level())
+		}
+	}
+
+	// commands don't serve : no key pair generated, database opened by commands needing it
+	c, k := &Config{readOnly: true}, koanf.New(".")
+	load := c.LoadSources
+	if valid {
+		load = c.Load
+	}
+	if err := load(k, f); err != nil {
+		return nil, nil, err
+	}
+	configuration = c
+	return c, k, nil
+}
+
+// return first positional argument, or first line of in if none or "-"
+func argOrStdin(f *flag.FlagSet, in io.Reader) (string, error) {
+	if v := f.Arg(0); v != "" && v != "-" {
+		return v, nil
+	}
+	return readLine(in)
+}
+
+// return first line of in, without line ending
+func readLine(in io.Reader) (string, error) {
+	line, err := bufio.NewReader(in).ReadString('\n')
+	if err != nil && err != io.EOF {
+		return "", errors.New("main: error reading stdin\n\t-> " + err.Error())
+	}
+	return strings.TrimRight(line, "\r\n"), nil
+}
+
+func runServe(f *flag.FlagSet, in io.Reader, out io.Writer) error {
+	if err := loadConfigurationAndLogger(f); err != nil {
+		return errors.New("main: error loading configuration\n\t-> " + err.Error())
+	}
+	return errors.New("main: error loading server\n\t-> " + LoadServer().Error())
+}
+
+func runHash(f *flag.FlagSet, in io.Reader, out io.Writer) error {
+	c, _, err := loadCommandConfiguration(f, false)
+	if err != nil {
+		return err
+	}
+	password, err := argOrStdin(f, in)
+	if err != nil {
+		return err
+	}
+	if password == "" {
+		return errors.New("main: missing password")
+	}
+
+	// defaults for missing parameters, then flags are checked strictly
+	p := c.PasswordHash
+	p.Valid(true)
+	if a, _ := f.GetString("algorithm"); a != "" {
+		p.Algorithm = a
+	}
+	if cost, _ := f.GetInt("cost"); cost != 0 {
+		switch p.Algorithm {
+		case HashArgon2id:
+			p.Argon2Iterations = uint32(cost)
+		default:
+			p.BcryptCost = cost
+		}
+	}
+	if err := p.Valid(false); err != nil {
+		return err
+	}
+	if err := c.PasswordPolicy.Valid(true); err != nil {
+		return errors.New("config: bad PasswordPolicy\n\t-> " + err.Error())
+	}
+	if err := c.PasswordPolicy.Check("", password); err != nil {
+		return errors.New("config: password rejected\n\t-> " + err.Error())
+	}
+	h, err := p.Hash(password)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintln(out, h)
+	return nil
+}
+
+func runConfigValidate(f *flag.FlagSet, in io.Reader, out io.Writer) error {
+	c, k, err := loadCommandConfiguration(f, false)
+	if err != nil {
+		return err
+	}
+	if err := c.ValidStrict(k); err != nil {
+		return errors.New("config: configuration is not valid\n\t-> " + err.Error())
+	}
+	fmt.Fprintln(out, "Configuration is valid")
+	return nil
+}
+
+func runUserAdd(f *flag.FlagSet, in io.Reader, out io.Writer) error {
+	username := f.Arg(0)
+	if username == "" || f.NArg() > 1 {
+		return errors.New("main: expected one username")
+	}
+	c, _, err := loadCommandConfiguration(f, true)
+	if err != nil {
+		return err
+	}
+	if c.DatabaseFile == "" {
+		return errors.New("main: users can only be added to DatabaseFile")
+	}
+	if c.db, err = OpenSqlStore(c.DatabaseFile); err != nil {
+		return err
+	}
+	defer c.db.Close()
+	if lookupUser(username) != nil {
+		return errors.New("main: user " + username + " already exists")
+	}
+
+	password, err := readLine(in)
+	if err != nil {
+		return err
+	}
+	if err := c.PasswordPolicy.Check(username, password); err != nil {
+		return errors.New("config: password rejected\n\t-> " + err.Error())
+	}
+	hash, err := c.PasswordHash.Hash(password)
+	if err != nil {
+		return err
+	}
+	u := &User{Username: username, Password: hash}
+	u.Email, _ = f.GetString("email")
+	u.AllowedDomains, _ = f.GetStringSlice("domains")
+	u.Groups, _ = f.GetStringSlice("groups")
+	u.Admin, _ = f.GetBool("admin")
+	u.MustChangePassword, _ = f.GetBool("must-change-password")
+	if u.Language, _ = f.GetString("language"); u.Language != "" && !SupportedLanguage(u.Language) {
+		return ErrUnsupportedLanguage
+	}
+	if err := c.db.CreateUser(u); err != nil {
+		return err
+	}
+	auditLog.Add("cli", "user created", username, "")
+	fmt.Fprintln(out, "User "+username+" created")
+	return nil
+}
+
+func runUserList(f *flag.FlagSet, in io.Reader, out io.Writer) error {
+	c, _, err := loadCommandConfiguration(f, true)
+	if err != nil {
+		return err
+	}
+	if c.DatabaseFile != "" {
+		if c.db, err = OpenSqlStoreReadOnly(c.DatabaseFile); err != nil {
+			return err
+		}
+		defer c.db.Close()
+	}
+	w := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "USERNAME\tSTORE\tADMIN\tSTATUS\tDOMAINS")
+	row := func(u *User, store string) {
+		status := "active"
+		if err := u.Active(); err != nil {
+			status = err.Error()
+		}
+		fmt.Fprintf(w, "%s\t%s\t%t\t%s\t%s\n", u.Username, store, u.Admin, status, strings.Join(u.AllowedDomains, ","))
+	}
+
+	names := make([]string, 0, len(c.Users))
+	for n := range c.Users {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	for _, n := range names {
+		if u := ConfigUserStore(c.Users).GetUser(n); u != nil {
+			row(u, "config")
+		}
+	}
+	if c.db != nil {
+		users, err := c.db.ListUsers()
+		if err != nil {
+			return err
+		}
+		for _, u := range users {
+			row(u, "database")
+		}
+	}
+	for _, h := range c.HtpasswdFiles {
+		for _, n := range h.Usernames() {
+			row(h.GetUser(n), "htpasswd:"+h.Path)
+		}
+	}
+	return w.Flush()
+}
+
+func runTokenCreate(f *flag.FlagSet, in io.Reader, out io.Writer) error {
+	if f.NArg() != 1 {
+		return errors.New("main: expected one username")
+	}
+	token, hash := GenerateApiToken()
+	fmt.Fprintln(out, "Token of "+f.Arg(0)+" (shown once): "+token)
+	fmt.Fprintln(out, "Add its hash to the user Tokens: "+hash)
+	return nil
+}
+
+func runTokenInspect(f *flag.FlagSet, in io.Reader, out io.Writer) error {
+	c, _, err := loadCommandConfiguration(f, false)
+	if err != nil {
+		return err
+	}
+	token, err := argOrStdin(f, in)
+	if err != nil {
+		return err
+	}
+	// accept "name=value" as copied from a browser
+	if i := strings.LastIndex(token, "="); i >= 0 {
+		token = token[i+1:]
+	}
+
+	cl := &Claims{}
+	if _, _, err := jwt.NewParser().ParseUnverified(token, cl); err != nil {
+		return errors.New("jwt: cannot decode token\n\t-> " + err.Error())
+	}
+	w := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+	fmt.Fprintf(w, "Subject:\t%s\n", cl.Subject)
+	fmt.Fprintf(w, "Domains:\t%s\n", strings.Join(cl.Audience, ","))
+	fmt.Fprintf(w, "Ip:\t%s\n", cl.Ip)
+	fmt.Fprintf(w, "Session:\t%s\n", cl.ID)
+	fmt.Fprintf(w, "Issuer:\t%s\n", cl.Issuer)
+	for _, d := range []struct {
+		name string
+		date *jwt.NumericDate
+	}{{"Issued", cl.IssuedAt}, {"Not before", cl.NotBefore}, {"Expires", cl.ExpiresAt}} {
+		if d.date != nil {
+			fmt.Fprintf(w, "%s:\t%s\n", d.name, d.date.Time.Format(time.RFC3339))
+		}
+	}
+	if cl.ChangePassword {
+		fmt.Fprintf(w, "Restricted:\tpassword change only\n")
+	}
+	w.Flush()
+
+	if c.JwtSecretKey == "" {
+		return errors.New("jwt: JwtSecretKey is not set, cannot verify token")
+	}
+	_, err = jwt.ParseWithClaims(token, &Claims{}, func(t *jwt.Token) (interface{}, error) {
+		// Validate alg for security ("none" is not allowed)
+		if _, ok := t.Method.(*jwt.SigningMethodHMAC); !ok {
+			return nil, fmt.Errorf("unexpected signing method: %v", t.Header["alg"])
+		}
+		return []byte(c.JwtSecretKey), nil
+	})
+	if err != nil {
+		return errors.New("jwt: invalid token\n\t-> " + err.Error())
+	}
+	if c.DatabaseFile != "" {
+		db, err := OpenSqlStore(c.DatabaseFile)
+		if err != nil {
+			return err
+		}
+		defer db.Close()
+		if db.SessionRevoked(cl.ID) {
+			return errors.New("jwt: session revoked")
+		}
+	}
+	fmt.Fprintln(out, "Token is valid")
+	return nil
+}
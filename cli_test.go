@@ -0,0 +1,220 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// run command, restoring global configuration and logger after
+func runTestCommand(t *testing.T, args []string, stdin string) (string, error) {
+	backup, logbackup := configuration, log
+	defer func() { configuration, log = backup, logbackup }()
+	out := &bytes.Buffer{}
+	err := RunCommand(args, strings.NewReader(stdin), out)
+	return out.String(), err
+}
+
+func TestRunCommand(t *testing.T) {
+	testCases := []struct {
+		Name                  string
+		Args                  []string
+		Stdin                 string
+		ExpectedError         bool
+		ExpectedErrorContains string
+		ExpectedOutput        string
+	}{
+		{
+			Name:           "HELP",
+			Args:           []string{"help"},
+			ExpectedOutput: "token inspect",
+		},
+		{
+			Name:           "COMMAND_HELP",
+			Args:           []string{"hash", "--help"},
+			ExpectedOutput: "--algorithm",
+		},
+		{
+			Name:                  "UNKNOWN",
+			Args:                  []string{"nope"},
+			ExpectedError:         true,
+			ExpectedErrorContains: "unknown command 'nope'",
+			ExpectedOutput:        "Commands:",
+		},
+		{
+			Name:                  "BAD_FLAG",
+			Args:                  []string{"hash", "--nope"},
+			ExpectedError:         true,
+			ExpectedErrorContains: "bad arguments",
+		},
+		{
+			Name:           "HASH",
+			Args:           []string{"hash", "--config", "test.config.yml", "PASSWORD"},
+			ExpectedOutput: "$2a$",
+		},
+		{
+			Name:           "HASH_STDIN",
+			Args:           []string{"hash", "--config", "test.config.yml", "--algorithm", "argon2id", "--cost", "1"},
+			Stdin:          "PASSWORD\n",
+			ExpectedOutput: "$argon2id$v=19$m=19456,t=1,p=1$",
+		},
+		{
+			Name:                  "HASH_EMPTY",
+			Args:                  []string{"hash", "--config", "test.config.yml", "-"},
+			ExpectedError:         true,
+			ExpectedErrorContains: "missing password",
+		},
+		{
+			Name:                  "HASH_WEAK",
+			Args:                  []string{"hash", "--config", "test.config.yml", "short"},
+			ExpectedError:         true,
+			ExpectedErrorContains: "password rejected",
+		},
+		{
+			Name:                  "HASH_BAD_ALGORITHM",
+			Args:                  []string{"hash", "--config", "test.config.yml", "--algorithm", "md5", "PASSWORD"},
+			ExpectedError:         true,
+			ExpectedErrorContains: "bad Algorithm",
+		},
+		{
+			Name:                  "VALIDATE_BAD_FILE",
+			Args:                  []string{"config", "validate", "--config", "bad_file"},
+			ExpectedError:         true,
+			ExpectedErrorContains: "error loading file",
+		},
+		{
+			Name:           "VALIDATE",
+			Args:           []string{"config", "validate", "--config", "test.config.yml"},
+			ExpectedOutput: "Configuration is valid",
+		},
+		{
+			Name:           "TOKEN_CREATE",
+			Args:           []string{"token", "create", "jean"},
+			ExpectedOutput: "Token of jean (shown once): gfa_",
+		},
+		{
+			Name:                  "TOKEN_CREATE_NO_USER",
+			Args:                  []string{"token", "create"},
+			ExpectedError:         true,
+			ExpectedErrorContains: "expected one username",
+		},
+		{
+			Name:           "TOKEN_INSPECT",
+			Args:           []string{"token", "inspect", "--config", "test.config.yml", TestCookie["valid"].Value},
+			ExpectedOutput: "Token is valid",
+		},
+		{
+			Name:           "TOKEN_INSPECT_STDIN",
+			Args:           []string{"token", "inspect", "--config", "test.config.yml"},
+			Stdin:          "_test_gfa=" + TestCookie["valid"].Value + "\n",
+			ExpectedOutput: "Subject:     jean",
+		},
+		{
+			Name:                  "TOKEN_INSPECT_EXPIRED",
+			Args:                  []string{"token", "inspect", "--config", "test.config.yml", TestCookie["expired"].Value},
+			ExpectedError:         true,
+			ExpectedErrorContains: "token is expired",
+			ExpectedOutput:        "Domains:     url.net",
+		},
+		{
+			Name:                  "TOKEN_INSPECT_ALTERED",
+			Args:                  []string{"token", "inspect", "--config", "test.config.yml", TestCookie["altered"].Value},
+			ExpectedError:         true,
+			ExpectedErrorContains: "invalid token",
+		},
+		{
+			Name:                  "TOKEN_INSPECT_BAD_ALGO",
+			Args:                  []string{"token", "inspect", "--config", "test.config.yml", TestCookie["badalgo"].Value},
+			ExpectedError:         true,
+			ExpectedErrorContains: "unexpected signing method",
+		},
+		{
+			Name:                  "TOKEN_INSPECT_GARBAGE",
+			Args:                  []string{"token", "inspect", "--config", "test.config.yml", "FAKE"},
+			ExpectedError:         true,
+			ExpectedErrorContains: "cannot decode token",
+		},
+		{
+			Name:                  "USER_ADD_NO_DATABASE",
+			Args:                  []string{"user", "add", "--config", "test.config.yml", "paul"},
+			Stdin:                 "secretpass\n",
+			ExpectedError:         true,
+			ExpectedErrorContains: "only be added to DatabaseFile",
+		},
+	}
+	for _, tc := range testCases {
+		// shadow the test case to avoid modifying the test case
+		tc := tc
+		t.Run(tc.Name, func(t *testing.T) {
+			out, err := runTestCommand(t, tc.Args, tc.Stdin)
+			if tc.ExpectedError {
+				assert.ErrorContains(t, err, tc.ExpectedErrorContains)
+			} else {
+				assert.NoError(t, err)
+			}
+			assert.Contains(t, out, tc.ExpectedOutput)
+		})
+	}
+}
+
+func TestRunCommandUser(t *testing.T) {
+	t.Setenv("GFA_DATABASEFILE", filepath.Join(t.TempDir(), "gfa.db"))
+	config := []string{"--config", "test.config.yml"}
+
+	out, err := runTestCommand(t, append([]string{"user", "add", "--domains", "paul.com,paul.net", "--admin", "paul"}, config...), "secretpass\n")
+	assert.NoError(t, err)
+	assert.Equal(t, "User paul created\n", out)
+
+	_, err = runTestCommand(t, append([]string{"user", "add", "jean"}, config...), "secretpass\n")
+	assert.ErrorContains(t, err, "user jean already exists")
+	_, err = runTestCommand(t, append([]string{"user", "add", "pierre"}, config...), "short\n")
+	assert.ErrorContains(t, err, "password rejected")
+	_, err = runTestCommand(t, append([]string{"user", "add", "a", "b"}, config...), "")
+	assert.ErrorContains(t, err, "expected one username")
+
+	out, err = runTestCommand(t, append([]string{"user", "list"}, config...), "")
+	assert.NoError(t, err)
+	lines := strings.Split(strings.TrimSpace(out), "\n")
+	assert.Contains(t, lines[0], "USERNAME")
+	assert.Regexp(t, `^admin +config +true +active`, lines[1])
+	assert.Regexp(t, `^paul +database +true +active +paul.com,paul.net$`, lines[len(lines)-1])
+}
+
+func TestRunCommandReadOnly(t *testing.T) {
+	db := filepath.Join(t.TempDir(), "gfa.db")
+	t.Setenv("GFA_DATABASEFILE", db)
+	config := []string{"--config", "test.config.yml"}
+	os.Remove("gfa_server.key")
+	os.Remove("gfa_server.crt")
+
+	// neither key pair nor database are created
+	_, err := runTestCommand(t, append([]string{"config", "validate"}, config...), "")
+	assert.NotContains(t, fmt.Sprint(err), "DatabaseFile")
+	_, err = runTestCommand(t, append([]string{"user", "list"}, config...), "")
+	assert.Error(t, err)
+	assert.NoFileExists(t, db)
+	assert.NoFileExists(t, "gfa_server.key")
+	assert.NoFileExists(t, "gfa_server.crt")
+
+	// database is not migrated by list
+	_, err = runTestCommand(t, append([]string{"user", "add", "paul"}, config...), "secretpass\n")
+	assert.NoError(t, err)
+	s, err := OpenSqlStore(db)
+	if assert.NoError(t, err) {
+		_, err = s.db.Exec(`DELETE FROM schema_version WHERE version = ?`, len(migrations))
+		assert.NoError(t, err)
+		s.Close()
+	}
+	_, err = runTestCommand(t, append([]string{"user", "list"}, config...), "")
+	assert.ErrorContains(t, err, "start the server to migrate it")
+
+	// missing directory is reported
+	t.Setenv("GFA_DATABASEFILE", filepath.Join(db, "nope", "gfa.db"))
+	_, err = runTestCommand(t, append([]string{"config", "validate"}, config...), "")
+	assert.ErrorContains(t, err, "DatabaseFile")
+}
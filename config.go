@@ -5,7 +5,9 @@ import (
 	"encoding/base64"
 	"errors"
 	"fmt"
+	"net/url"
 	"os"
+	"strings"
 	"time"
 
 	flag "github.com/spf13/pflag" // POSIX compliant
@@ -19,117 +21,324 @@ import (
 type Config struct {
 	PrivateKey        string           `koanf:"PrivateKey"`
 	Certificate       string           `koanf:"Certificate"`
+	TlsMinVersion     string           `koanf:"TlsMinVersion"`
+	TlsCipherSuites   []string         `koanf:"TlsCipherSuites"`
+	ClientCert        *ClientCert      `koanf:"ClientCert"`
 	Port              uint             `koanf:"Port"`
+	Listeners         []*Listener      `koanf:"Listeners"`
 	CookieDomain      string           `koanf:"CookieDomain"`
 	CookieName        string           `koanf:"CookieName"`
 	TokenExpire       time.Duration    `koanf:"TokenExpire"`
 	TokenRefresh      time.Duration    `koanf:"TokenRefresh"`
 	HtmlFile          string           `koanf:"HtmlFile"`
+	AdminHtmlFile     string           `koanf:"AdminHtmlFile"`
+	Branding          Branding         `koanf:"Branding"`
+	Sites             []*Site          `koanf:"Sites"`
+	Language          string           `koanf:"Language"`
+	StaticDir         string           `koanf:"StaticDir"`
+	SecurityHeaders   SecurityHeaders  `koanf:"SecurityHeaders"`
 	JwtSecretKey      string           `koanf:"JwtSecretKey"`
 	CsrfSecretKey     string           `koanf:"CsrfSecretKey"`
 	LogLevel          string           `koanf:"LogLevel"`
 	MagicIp           string           `koanf:"MagicIp"`
+	BasicAuthDomains  []string         `koanf:"BasicAuthDomains"`
+	BasicAuthCacheTtl time.Duration    `koanf:"BasicAuthCacheTtl"`
 	Users             map[string]*User `koanf:"Users"`
+	HtpasswdFiles     []*HtpasswdFile  `koanf:"HtpasswdFiles"`
+	DatabaseFile      string           `koanf:"DatabaseFile"`
+	PasswordHash      PasswordHash     `koanf:"PasswordHash"`
+	PasswordPolicy    PasswordPolicy   `koanf:"PasswordPolicy"`
+	LockoutThreshold  uint             `koanf:"LockoutThreshold"`
+	LockoutDuration   time.Duration    `koanf:"LockoutDuration"`
+	Smtp              *Smtp            `koanf:"Smtp"`
+	PublicUrl         string           `koanf:"PublicUrl"`
+	ResetTokenExpire  time.Duration    `koanf:"ResetTokenExpire"`
+	MailRateLimit     uint             `koanf:"MailRateLimit"`
+	MagicLink         *MagicLink       `koanf:"MagicLink"`
+	// refuse to start on invalid or unknown keys, instead of using default values
+	Strict            bool `koanf:"Strict"`
 	ConfigurationFile []string
-	StringToHash      string
+	// true if key pair is generated by GFA
+	selfSigned bool
+	// set by commands : validation writes nothing, neither key pair nor database
+	readOnly bool
+	// opened DatabaseFile, nil if none
+	db *SqlStore
 }
 
 const defaultConfigurationFile = "default.config.yml"
 const defaultHtmlFile = "default.index.html"
+const defaultAdminHtmlFile = "default.admin.html"
 
 // validate data, and set default values if init is true
+// unset optional keys always take their default value, only invalid ones are errors
+// all problems are returned at once, as ConfigErrors
 func (c *Config) Valid(init bool) error {
+	errs := ConfigErrors{}
 
-	if c.PrivateKey == "" && c.Certificate == "" && init {
-		log.Info("config: generating default key pair for tls")
-		c.PrivateKey = "./gfa_server.key"
-		c.Certificate = "./gfa_server.crt"
-		GenerateKeyPair(2048, c.PrivateKey, c.Certificate)
-	}
-	if _, err := tls.LoadX509KeyPair(c.Certificate, c.PrivateKey); err != nil {
-		return errors.New("config: bad key pair\n\t-> " + err.Error())
+	if c.ClientCert != nil {
+		if err := c.ClientCert.Valid(); err != nil {
+			errs.add("ClientCert", errors.New("config: bad ClientCert\n\t-> "+err.Error()))
+		}
 	}
 	if c.Port < 1 || c.Port > 65534 {
 		if !init {
-			return errors.New("config: bad Port")
+			errs.add("Port", errors.New("config: bad Port"))
+		} else {
+			c.Port = 8000
+			log.Info("config: setting default value", zap.Uint("Port", c.Port))
+		}
+	}
+	// without listener, serve tls on Port, only kept once Port is valid
+	listeners := c.Listeners
+	if len(listeners) == 0 {
+		listeners = []*Listener{{Type: ListenerTls, Address: ":" + fmt.Sprint(c.Port)}}
+		if init {
+			c.Listeners = listeners
+		}
+	}
+	for i, l := range listeners {
+		if err := l.Valid(); err != nil {
+			errs.add(fmt.Sprintf("Listeners[%d]", i), errors.New("config: bad Listeners\n\t-> "+err.Error()))
+		}
+	}
+	if HasTlsListener(listeners) {
+		generated := c.PrivateKey == "" && c.Certificate == ""
+		if generated && init && !c.readOnly {
+			log.Info("config: generating default key pair for tls")
+			c.PrivateKey = "./gfa_server.key"
+			c.Certificate = "./gfa_server.crt"
+			c.selfSigned = true
+			if err := GenerateKeyPair(2048, c.PrivateKey, c.Certificate); err != nil {
+				log.Error("config: error generating key pair", zap.Error(err))
+			}
+		}
+		if generated && c.readOnly {
+			log.Info("config: no key pair, a self-signed one is generated on start")
+		} else if _, err := tls.LoadX509KeyPair(c.Certificate, c.PrivateKey); err != nil {
+			errs.add("Certificate", errors.New("config: bad key pair\n\t-> "+err.Error()))
+		}
+		if c.TlsMinVersion == "" {
+			c.TlsMinVersion = "1.2"
+			log.Info("config: setting default value", zap.String("TlsMinVersion", c.TlsMinVersion))
+		}
+		if _, err := ParseTlsVersion(c.TlsMinVersion); err != nil {
+			if !init {
+				errs.add("TlsMinVersion", errors.New("config: bad TlsMinVersion\n\t-> "+err.Error()))
+			} else {
+				c.TlsMinVersion = "1.2"
+				log.Info("config: setting default value", zap.String("TlsMinVersion", c.TlsMinVersion))
+			}
+		}
+		if _, err := ParseCipherSuites(c.TlsCipherSuites); err != nil {
+			if !init {
+				errs.add("TlsCipherSuites", errors.New("config: bad TlsCipherSuites\n\t-> "+err.Error()))
+			} else {
+				c.TlsCipherSuites = nil
+				log.Info("config: setting default value", zap.Strings("TlsCipherSuites", c.TlsCipherSuites))
+			}
 		}
-		c.Port = 8000
-		log.Info("config: setting default value", zap.Uint("Port", c.Port))
 	}
 	if c.CookieName == "" {
 		if !init {
-			return errors.New("config: missing CookieName")
+			errs.add("CookieName", errors.New("config: missing CookieName"))
+		} else {
+			c.CookieName = "GFA"
+			log.Info("config: setting default value", zap.String("CookieName", c.CookieName))
 		}
-		c.CookieName = "GFA"
-		log.Info("config: setting default value", zap.String("CookieName", c.CookieName))
 	}
 	if c.TokenExpire < 1 {
 		if !init {
-			return errors.New("config: TokenExpire is too small")
+			errs.add("TokenExpire", errors.New("config: TokenExpire is too small"))
+		} else {
+			c.TokenExpire = 90
+			log.Info("config: setting default value", zap.Duration("TokenExpire", c.TokenExpire))
 		}
-		c.TokenExpire = 90
-		log.Info("config: setting default value", zap.Duration("TokenExpire", c.TokenExpire))
 	}
 	if c.TokenRefresh >= c.TokenExpire {
 		if !init {
-			return errors.New("config: TokenRefresh must be smaller than TokenExpire")
+			errs.add("TokenRefresh", errors.New("config: TokenRefresh must be smaller than TokenExpire"))
+		} else {
+			c.TokenRefresh = c.TokenExpire / 2
+			log.Info("config: setting default value", zap.Duration("TokenRefresh", c.TokenRefresh))
 		}
-		c.TokenRefresh = c.TokenExpire / 2
-		log.Info("config: setting default value", zap.Duration("TokenRefresh", c.TokenRefresh))
 	}
 	if c.HtmlFile == "" {
 		if !init {
-			return errors.New("config: missing HtmlFile")
+			errs.add("HtmlFile", errors.New("config: missing HtmlFile"))
+		} else {
+			c.HtmlFile = defaultHtmlFile
+			log.Info("config: setting default value", zap.String("HtmlFile", c.HtmlFile))
 		}
-		c.HtmlFile = defaultHtmlFile
-		log.Info("config: setting default value", zap.String("HtmlFile", c.HtmlFile))
 	}
 	if _, err := os.Stat(c.HtmlFile); err != nil {
-		return errors.New("config: html template error\r\t-> " + err.Error())
+		errs.add("HtmlFile", errors.New("config: html template error\n\t-> "+err.Error()))
 	}
-	if len(c.JwtSecretKey) < 32 {
+	if c.AdminHtmlFile == "" {
+		c.AdminHtmlFile = defaultAdminHtmlFile
+		log.Info("config: setting default value", zap.String("AdminHtmlFile", c.AdminHtmlFile))
+	}
+	if _, err := os.Stat(c.AdminHtmlFile); err != nil {
+		errs.add("AdminHtmlFile", errors.New("config: admin html template error\n\t-> "+err.Error()))
+	}
+	if err := c.Branding.Valid(); err != nil {
+		errs.add("Branding", errors.New("config: bad Branding\n\t-> "+err.Error()))
+	}
+	for i, s := range c.Sites {
+		if s == nil {
+			errs.add(fmt.Sprintf("Sites[%d]", i), errors.New("config: empty site"))
+		} else if err := s.Valid(); err != nil {
+			errs.add(fmt.Sprintf("Sites[%d]", i), errors.New("config: bad site\n\t-> "+err.Error()))
+		}
+	}
+	if c.StaticDir != "" {
+		if fi, err := os.Stat(c.StaticDir); err != nil || !fi.IsDir() {
+			errs.add("StaticDir", errors.New("config: StaticDir must be a directory"))
+		}
+	}
+	if c.Language == "" {
+		c.Language = defaultLanguage
+		log.Info("config: setting default value", zap.String("Language", c.Language))
+	}
+	if !SupportedLanguage(c.Language) {
 		if !init {
-			return errors.New("config: JwtSecretKey is too small")
+			errs.add("Language", errors.New("config: unsupported Language, expected one of "+strings.Join(Languages(), ", ")))
+		} else {
+			c.Language = defaultLanguage
+			log.Info("config: setting default value", zap.String("Language", c.Language))
 		}
-		log.Info("config: JwtSecretKey provided is too weak, generating secure one...", zap.Int("length", len(c.JwtSecretKey)))
-		array := GenerateRandomBytes(64)
-		if len(*array) < 64 {
-			return errors.New("config : error generating JwtSecretKey")
+	}
+	if len(c.JwtSecretKey) < 32 {
+		if !init {
+			errs.add("JwtSecretKey", errors.New("config: JwtSecretKey is too small"))
+		} else {
+			log.Info("config: JwtSecretKey provided is too weak, generating secure one...", zap.Int("length", len(c.JwtSecretKey)))
+			array := GenerateRandomBytes(64)
+			if len(*array) < 64 {
+				return errors.New("config : error generating JwtSecretKey")
+			}
+			c.JwtSecretKey = string(*array)
 		}
-		c.JwtSecretKey = string(*array)
 	}
 	if len(c.CsrfSecretKey) != 32 {
 		if !init {
-			return errors.New("config: CsrfSecretKey must be 32 character long")
-		}
-		log.Info("config: CsrfSecretKey provided is too weak, generating secure one...", zap.Int("length", len(c.CsrfSecretKey)))
-		array := GenerateRandomBytes(32)
-		if len(*array) < 32 {
-			return errors.New("config : error generating CsrfSecretKey")
+			errs.add("CsrfSecretKey", errors.New("config: CsrfSecretKey must be 32 character long"))
+		} else {
+			log.Info("config: CsrfSecretKey provided is too weak, generating secure one...", zap.Int("length", len(c.CsrfSecretKey)))
+			array := GenerateRandomBytes(32)
+			if len(*array) < 32 {
+				return errors.New("config : error generating CsrfSecretKey")
+			}
+			c.CsrfSecretKey = string(*array)
 		}
-		c.CsrfSecretKey = string(*array)
 	}
+	// generated if unset
 	if len(c.MagicIp) < 12 {
+		if c.MagicIp != "" && !init {
+			errs.add("MagicIp", errors.New("config: MagicIp is too small"))
+		} else {
+			log.Info("config: MagicIp provided is too weak, generating secure one...", zap.Int("length", len(c.MagicIp)))
+			array := GenerateRandomBytes(12)
+			if len(*array) < 12 {
+				return errors.New("config : error generating MagicIp")
+			}
+			// magic ip need to be passed to jwt
+			c.MagicIp = base64.StdEncoding.EncodeToString(*array)
+		}
+	}
+	if c.BasicAuthCacheTtl < 0 {
+		if !init {
+			errs.add("BasicAuthCacheTtl", errors.New("config: BasicAuthCacheTtl must be positive"))
+		} else {
+			c.BasicAuthCacheTtl = 0
+			log.Info("config: setting default value", zap.Duration("BasicAuthCacheTtl", c.BasicAuthCacheTtl))
+		}
+	}
+	for i, h := range c.HtpasswdFiles {
+		if err := h.Valid(); err != nil {
+			errs.add(fmt.Sprintf("HtpasswdFiles[%d]", i), errors.New("config: bad HtpasswdFiles\n\t-> "+err.Error()))
+		}
+	}
+	if c.DatabaseFile != "" && c.readOnly {
+		if err := CheckDatabaseFile(c.DatabaseFile); err != nil {
+			errs.add("DatabaseFile", errors.New("config: bad DatabaseFile\n\t-> "+err.Error()))
+		}
+	} else if c.DatabaseFile != "" && (c.db == nil || c.db.Path != c.DatabaseFile) {
+		db, err := OpenSqlStore(c.DatabaseFile)
+		if err != nil {
+			errs.add("DatabaseFile", errors.New("config: bad DatabaseFile\n\t-> "+err.Error()))
+		}
+		c.db = db
+	}
+	if c.LockoutDuration == 0 {
+		c.LockoutDuration = 15
+		log.Info("config: setting default value", zap.Duration("LockoutDuration", c.LockoutDuration))
+	}
+	if c.LockoutDuration < 1 {
 		if !init {
-			return errors.New("config: MagicIp is too small")
+			errs.add("LockoutDuration", errors.New("config: LockoutDuration must be positive"))
+		} else {
+			c.LockoutDuration = 15
+			log.Info("config: setting default value", zap.Duration("LockoutDuration", c.LockoutDuration))
+		}
+	}
+	if c.Smtp != nil {
+		if err := c.Smtp.Valid(); err != nil {
+			errs.add("Smtp", errors.New("config: bad Smtp\n\t-> "+err.Error()))
+		}
+		// links sent by email must point to GFA
+		u, err := url.Parse(c.PublicUrl)
+		if err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+			errs.add("PublicUrl", errors.New("config: PublicUrl must be an absolute url when Smtp is set"))
 		}
-		log.Info("config: MagicIp provided is too weak, generating secure one...", zap.Int("length", len(c.MagicIp)))
-		array := GenerateRandomBytes(12)
-		if len(*array) < 12 {
-			return errors.New("config : error generating MagicIp")
+		c.PublicUrl = strings.TrimSuffix(c.PublicUrl, "/")
+	}
+	if c.MagicLink != nil {
+		if c.Smtp == nil {
+			errs.add("MagicLink", errors.New("config: MagicLink requires Smtp"))
+		} else if err := c.MagicLink.Valid(init); err != nil {
+			errs.add("MagicLink", errors.New("config: bad MagicLink\n\t-> "+err.Error()))
+		}
+	}
+	if c.ResetTokenExpire == 0 {
+		c.ResetTokenExpire = 30
+		log.Info("config: setting default value", zap.Duration("ResetTokenExpire", c.ResetTokenExpire))
+	}
+	if c.ResetTokenExpire < 1 {
+		if !init {
+			errs.add("ResetTokenExpire", errors.New("config: ResetTokenExpire must be positive"))
+		} else {
+			c.ResetTokenExpire = 30
+			log.Info("config: setting default value", zap.Duration("ResetTokenExpire", c.ResetTokenExpire))
 		}
-		// magic ip need to be passed to jwt
-		c.MagicIp = base64.StdEncoding.EncodeToString(*array)
+	}
+	if c.MailRateLimit == 0 {
+		c.MailRateLimit = 5
+		log.Info("config: setting default value", zap.Uint("MailRateLimit", c.MailRateLimit))
+	}
+	if c.SecurityHeaders.ContentSecurityPolicy == "" {
+		c.SecurityHeaders.ContentSecurityPolicy = defaultContentSecurityPolicy(c.PublicUrl, c.CookieDomain)
+	}
+	if err := c.SecurityHeaders.Valid(init); err != nil {
+		errs.add("SecurityHeaders", errors.New("config: bad SecurityHeaders\n\t-> "+err.Error()))
+	}
+	if err := c.PasswordHash.Valid(init); err != nil {
+		errs.add("PasswordHash", errors.New("config: bad PasswordHash\n\t-> "+err.Error()))
+	}
+	if err := c.PasswordPolicy.Valid(init); err != nil {
+		errs.add("PasswordPolicy", errors.New("config: bad PasswordPolicy\n\t-> "+err.Error()))
 	}
 	if _, err := zap.ParseAtomicLevel(c.LogLevel); err != nil || c.LogLevel == "" {
 		if !init {
-			return errors.New("config: bad LogLevel")
+			errs.add("LogLevel", errors.New("config: bad LogLevel"))
+		} else {
+			c.LogLevel = "info"
+			log.Info("config: setting default value", zap.String("LogLevel", c.LogLevel))
 		}
-		c.LogLevel = "info"
-		log.Info("config: setting default value", zap.String("LogLevel", c.LogLevel))
 	}
+	c.validUsers(&errs)
 
-	return nil
+	return errs.Err()
 }
 
 // load configuration from command line
@@ -140,17 +349,19 @@ func (c *Config) LoadCommandeLine(f *flag.FlagSet) {
 		os.Exit(0)
 	}
 
-	if !f.HasFlags() {
-		f.StringSlice("config", c.ConfigurationFile, "Link to one or more configurations files.")
-		f.String("log", c.LogLevel, "Select log level.")
-		f.String("hash", c.StringToHash, "Password to hash (if hash is set, program will exit after showing answer).")
-	}
+	c.defineFlags(f)
 
-	f.Parse(os.Args[1:])
+	// subcommands parse their own arguments
+	if !f.Parsed() {
+		f.Parse(os.Args[1:])
+	}
 
 	c.LogLevel, _ = f.GetString("log")
 	c.ConfigurationFile, _ = f.GetStringSlice("config")
-	c.StringToHash, _ = f.GetString("hash")
+	// configuration files can also be given by environment, flag wins
+	if e := os.Getenv(envPrefix + "CONFIG"); e != "" && !f.Changed("config") {
+		c.ConfigurationFile = strings.Split(e, ",")
+	}
 }
 
 // load configuration from file
@@ -174,31 +385,36 @@ func (c *Config) LoadFile(k *koanf.Koanf) (isDefault bool, err error) {
 	return isDefault, nil
 }
 
+// define flags common to commands reading configuration, if not already defined
+func (c *Config) defineFlags(f *flag.FlagSet) {
+	if f.Lookup("config") == nil {
+		f.StringSlice("config", c.ConfigurationFile, "Link to one or more configurations files.")
+		f.String("log", c.LogLevel, "Select log level.")
+		f.Bool("strict", c.Strict, "Refuse invalid or unknown configuration keys instead of using default values.")
+	}
+}
+
 // read configuration from file.
 // If file is flag is supplied by flag load it, if not load defined arbitrary path
 func (c *Config) Load(k *koanf.Koanf, f *flag.FlagSet) (err error) {
 
-	c.LoadCommandeLine(f)
-	if c.StringToHash != "" {
-		log.Debug("config: hashing string", zap.String("value", c.StringToHash))
-		log.Info("config: hashed string", zap.String("value", GetHash(c.StringToHash)))
-		return errors.New("config: not an error")
+	if err := c.LoadSources(k, f); err != nil {
+		return err
 	}
 
-	if d, err := c.LoadFile(k); err != nil {
-		if !d {
-			return errors.New("config: error loading file\n\t-> " + err.Error())
+	if c.Strict {
+		if err := c.ValidStrict(k); err != nil {
+			return err
 		}
-		log.Info("config: error loading default file", zap.Error(err))
+		log.Info("Configuration loaded", zap.Strings("files", c.ConfigurationFile))
+		return nil
 	}
 
-	// parse configuration in global configuration var
-	if err := k.Unmarshal("", c); err != nil {
-		return errors.New("config: error parsing configuration\n\t-> " + err.Error())
+	for _, e := range c.UnknownKeys(k) {
+		log.Warn("config: unknown key, ignored", zap.String("key", e.Path))
 	}
-
 	if err := c.Valid(false); err != nil {
-		log.Info("config: configuration is not valid", zap.Error(err))
+		log.Warn("config: configuration is not valid, using default values for invalid keys", zap.Error(err))
 		if err := c.Valid(true); err != nil {
 			return errors.New("config: default configuration is not valid either\n\t-> " + err.Error())
 		}
@@ -208,3 +424,31 @@ func (c *Config) Load(k *koanf.Koanf, f *flag.FlagSet) (err error) {
 
 	return nil
 }
+
+// read configuration from command line, files and environment, without validating it
+func (c *Config) LoadSources(k *koanf.Koanf, f *flag.FlagSet) error {
+
+	c.LoadCommandeLine(f)
+	if d, err := c.LoadFile(k); err != nil {
+		if !d {
+			return errors.New("config: error loading file\n\t-> " + err.Error())
+		}
+		log.Info("config: error loading default file", zap.Error(err))
+	}
+	if err := c.LoadEnv(k, os.Environ()); err != nil {
+		return err
+	}
+	// command line flags win over files and environment
+	if f.Changed("log") {
+		k.Set("LogLevel", c.LogLevel)
+	}
+	if strict, _ := f.GetBool("strict"); strict {
+		k.Set("Strict", true)
+	}
+
+	// parse configuration in global configuration var
+	if err := k.Unmarshal("", c); err != nil {
+		return errors.New("config: error parsing configuration\n\t-> " + err.Error())
+	}
+	return nil
+}
@@ -1,6 +1,9 @@
 package main
 
 import (
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
 	"time"
 
@@ -28,6 +31,19 @@ func TestValid(t *testing.T) {
 		SetTokenExpire        time.Duration
 		SetLogLevel           string
 		SetMagicIp            string
+		SetListeners          []*Listener
+		SetTlsMinVersion      string
+		SetTlsCipherSuites    []string
+		SetBasicAuthCacheTtl  time.Duration
+		SetHtpasswdFiles      []*HtpasswdFile
+		SetDatabaseFile       string
+		SetAdminHtmlFile      string
+		SetLockoutDuration    time.Duration
+		SetPasswordMinLength  uint
+		SetSmtp               *Smtp
+		SetPublicUrl          string
+		SetResetTokenExpire   time.Duration
+		SetMagicLink          *MagicLink
 	}{
 		{
 			Name:             "VALID_NOINIT",
@@ -131,6 +147,130 @@ func TestValid(t *testing.T) {
 			InitializeConfig:      true,
 			SetLogLevel:           "nope",
 		},
+		{
+			Name:                  "INVALIDLISTENER_NOINIT",
+			ExpectedError:         true,
+			ExpectedErrorContains: "bad Listeners",
+			InitializeConfig:      true,
+			SetListeners:          []*Listener{{Type: "udp", Address: ":53"}},
+		},
+		{
+			Name:             "HTTPLISTENER_NOTLS_NOINIT",
+			ExpectedError:    false,
+			InitializeConfig: true,
+			SetPrivateKey:    "TEST",
+			SetListeners:     []*Listener{{Type: "http", Address: ":8080"}},
+		},
+		{
+			Name:                  "INVALIDTLSMINVERSION_NOINIT",
+			ExpectedError:         true,
+			ExpectedErrorContains: "bad TlsMinVersion",
+			InitializeConfig:      true,
+			SetTlsMinVersion:      "0.9",
+		},
+		{
+			Name:                  "INVALIDTLSCIPHERSUITES_NOINIT",
+			ExpectedError:         true,
+			ExpectedErrorContains: "bad TlsCipherSuites",
+			InitializeConfig:      true,
+			SetTlsCipherSuites:    []string{"TLS_RSA_WITH_RC4_128_SHA"},
+		},
+		{
+			Name:               "INVALIDTLSCIPHERSUITES_INIT",
+			ExpectedError:      false,
+			Init:               true,
+			InitializeConfig:   true,
+			SetTlsMinVersion:   "0.9",
+			SetTlsCipherSuites: []string{"TLS_RSA_WITH_RC4_128_SHA"},
+		},
+		{
+			Name:                  "INVALIDBASICAUTHCACHETTL_NOINIT",
+			ExpectedError:         true,
+			ExpectedErrorContains: "BasicAuthCacheTtl must be positive",
+			InitializeConfig:      true,
+			SetBasicAuthCacheTtl:  -1,
+		},
+		{
+			Name:                  "INVALIDHTPASSWDFILES_NOINIT",
+			ExpectedError:         true,
+			ExpectedErrorContains: "bad HtpasswdFiles",
+			InitializeConfig:      true,
+			SetHtpasswdFiles:      []*HtpasswdFile{{Path: "bad_file"}},
+		},
+		{
+			Name:                  "INVALIDDATABASEFILE_NOINIT",
+			ExpectedError:         true,
+			ExpectedErrorContains: "bad DatabaseFile",
+			InitializeConfig:      true,
+			SetDatabaseFile:       "missing_dir/gfa.db",
+		},
+		{
+			Name:                  "INVALIDADMINHTML_NOINIT",
+			ExpectedError:         true,
+			ExpectedErrorContains: "admin html template error",
+			InitializeConfig:      true,
+			SetAdminHtmlFile:      "bad_file",
+		},
+		{
+			Name:                  "INVALIDLOCKOUTDURATION_NOINIT",
+			ExpectedError:         true,
+			ExpectedErrorContains: "LockoutDuration must be positive",
+			InitializeConfig:      true,
+			SetLockoutDuration:    -1,
+		},
+		{
+			Name:                  "INVALIDPASSWORDPOLICY_NOINIT",
+			ExpectedError:         true,
+			ExpectedErrorContains: "bad PasswordPolicy",
+			InitializeConfig:      true,
+			SetPasswordMinLength:  1000,
+		},
+		{
+			Name:                  "INVALIDSMTP_NOINIT",
+			ExpectedError:         true,
+			ExpectedErrorContains: "bad Smtp",
+			InitializeConfig:      true,
+			SetSmtp:               &Smtp{Address: "localhost", From: "gfa@example.com"},
+			SetPublicUrl:          "https://auth.example.com",
+		},
+		{
+			Name:                  "MISSINGPUBLICURL_NOINIT",
+			ExpectedError:         true,
+			ExpectedErrorContains: "PublicUrl must be an absolute url",
+			InitializeConfig:      true,
+			SetSmtp:               &Smtp{Address: "localhost:25", From: "gfa@example.com"},
+			SetPublicUrl:          "/relative",
+		},
+		{
+			Name:             "VALIDSMTP_NOINIT",
+			ExpectedError:    false,
+			InitializeConfig: true,
+			SetSmtp:          &Smtp{Address: "localhost:25", From: "GFA <gfa@example.com>"},
+			SetPublicUrl:     "https://auth.example.com/",
+		},
+		{
+			Name:                  "MAGICLINKWITHOUTSMTP_NOINIT",
+			ExpectedError:         true,
+			ExpectedErrorContains: "MagicLink requires Smtp",
+			InitializeConfig:      true,
+			SetMagicLink:          &MagicLink{Expire: 15, EmailDomains: []*MagicLinkDomain{{EmailDomain: "example.com", AllowedDomains: []string{"url.net"}}}},
+		},
+		{
+			Name:                  "INVALIDMAGICLINK_NOINIT",
+			ExpectedError:         true,
+			ExpectedErrorContains: "bad MagicLink",
+			InitializeConfig:      true,
+			SetSmtp:               &Smtp{Address: "localhost:25", From: "gfa@example.com"},
+			SetPublicUrl:          "https://auth.example.com",
+			SetMagicLink:          &MagicLink{Expire: 15},
+		},
+		{
+			Name:                  "INVALIDRESETTOKENEXPIRE_NOINIT",
+			ExpectedError:         true,
+			ExpectedErrorContains: "ResetTokenExpire must be positive",
+			InitializeConfig:      true,
+			SetResetTokenExpire:   -1,
+		},
 		{
 			Name:             "VALID_INIT",
 			ExpectedError:    false,
@@ -151,6 +291,29 @@ func TestValid(t *testing.T) {
 				c.Valid(true)
 			}
 
+			if tc.SetListeners != nil {
+				c.Listeners = tc.SetListeners
+			}
+			if tc.SetTlsMinVersion != "" {
+				c.TlsMinVersion = tc.SetTlsMinVersion
+			}
+			if tc.SetTlsCipherSuites != nil {
+				c.TlsCipherSuites = tc.SetTlsCipherSuites
+			}
+			if tc.SetHtpasswdFiles != nil {
+				c.HtpasswdFiles = tc.SetHtpasswdFiles
+			}
+			if tc.SetDatabaseFile != "" {
+				c.DatabaseFile = tc.SetDatabaseFile
+			}
+			if tc.SetSmtp != nil {
+				c.Smtp = tc.SetSmtp
+				c.PublicUrl = tc.SetPublicUrl
+			}
+			if tc.SetMagicLink != nil {
+				c.MagicLink = tc.SetMagicLink
+			}
+
 			// setting vals
 			switch {
 			case tc.SetCert != "":
@@ -179,6 +342,16 @@ func TestValid(t *testing.T) {
 				c.LogLevel = tc.SetLogLevel
 			case tc.SetMagicIp != "":
 				c.MagicIp = tc.SetMagicIp
+			case tc.SetBasicAuthCacheTtl != 0:
+				c.BasicAuthCacheTtl = tc.SetBasicAuthCacheTtl
+			case tc.SetAdminHtmlFile != "":
+				c.AdminHtmlFile = tc.SetAdminHtmlFile
+			case tc.SetLockoutDuration != 0:
+				c.LockoutDuration = tc.SetLockoutDuration
+			case tc.SetPasswordMinLength != 0:
+				c.PasswordPolicy.MinLength = tc.SetPasswordMinLength
+			case tc.SetResetTokenExpire != 0:
+				c.ResetTokenExpire = tc.SetResetTokenExpire
 			}
 
 			err := c.Valid(tc.Init)
@@ -196,6 +369,9 @@ func TestValid(t *testing.T) {
 				assert.LessOrEqual(t, c.TokenRefresh, c.TokenExpire)
 				assert.GreaterOrEqual(t, c.Port, uint(1))
 				assert.LessOrEqual(t, c.Port, uint(65534))
+				if c.Smtp != nil {
+					assert.Equal(t, "https://auth.example.com", c.PublicUrl)
+				}
 			}
 		})
 	}
@@ -236,15 +412,14 @@ func TestLoadCommandeLine(t *testing.T) {
 	assert.Equal(t, "debug", c.LogLevel)
 	assert.Equal(t, []string{"test"}, c.ConfigurationFile)
 
-	// conf hash
-	if err := f.Set("hash", "pass"); err != nil {
-		assert.NoError(t, err)
-		t.FailNow() // panic if failed
-	}
+	// flags defined and parsed by a subcommand
+	f = flag.NewFlagSet("hash", flag.ContinueOnError)
+	f.String("algorithm", "", "")
+	c.defineFlags(f)
+	assert.NoError(t, f.Parse([]string{"--algorithm", "argon2id", "--log", "warn", "PASSWORD"}))
 	c.LoadCommandeLine(f)
-	assert.Equal(t, "debug", c.LogLevel)
-	assert.Equal(t, []string{"test"}, c.ConfigurationFile)
-	assert.Equal(t, "pass", c.StringToHash)
+	assert.Equal(t, "warn", c.LogLevel)
+	assert.Equal(t, []string{"PASSWORD"}, f.Args())
 }
 
 func TestLoadFile(t *testing.T) {
@@ -335,7 +510,6 @@ func TestLoad(t *testing.T) {
 		expectedErrorContains string
 	}{
 		{"bad_conf", "config", "bad_conf", "error loading file"},
-		{"hash", "hash", "PASSWORD", "not an error"},
 	}
 
 	for _, tc := range testCase {
@@ -357,3 +531,22 @@ func TestLoad(t *testing.T) {
 
 	}
 }
+
+func TestLoadDefaultListener(t *testing.T) {
+	content, err := os.ReadFile("test.config.yml")
+	assert.NoError(t, err)
+	file := filepath.Join(t.TempDir(), "noport.config.yml")
+	assert.NoError(t, os.WriteFile(file, []byte(strings.Replace(string(content), "Port: 9999\n", "", 1)), 0600))
+
+	f := flag.NewFlagSet("noport", flag.ContinueOnError)
+	c := &Config{}
+	c.defineFlags(f)
+	assert.NoError(t, f.Parse([]string{"--config", file}))
+	assert.NoError(t, c.Load(koanf.New("."), f))
+
+	// listener uses the default Port, not the unset one of the first validation
+	assert.Equal(t, uint(8000), c.Port)
+	if assert.Len(t, c.Listeners, 1) {
+		assert.Equal(t, ":8000", c.Listeners[0].Address)
+	}
+}
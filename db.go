@@ -0,0 +1,711 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+	_ "modernc.org/sqlite"
+)
+
+var ErrNotFound = errors.New("db: not found")
+
+// schema migrations, applied in order, never modify an existing one
+var migrations = []string{
+	// 1: initial schema
+	`CREATE TABLE users (
+		username   TEXT PRIMARY KEY,
+		password   TEXT NOT NULL,
+		created_at INTEGER NOT NULL,
+		updated_at INTEGER NOT NULL
+	);
+	CREATE TABLE user_domains (
+		username TEXT NOT NULL REFERENCES users(username) ON DELETE CASCADE,
+		domain   TEXT NOT NULL,
+		PRIMARY KEY (username, domain)
+	);
+	CREATE TABLE groups (
+		name TEXT PRIMARY KEY
+	);
+	CREATE TABLE group_domains (
+		group_name TEXT NOT NULL REFERENCES groups(name) ON DELETE CASCADE,
+		domain     TEXT NOT NULL,
+		PRIMARY KEY (group_name, domain)
+	);
+	CREATE TABLE user_groups (
+		username   TEXT NOT NULL REFERENCES users(username) ON DELETE CASCADE,
+		group_name TEXT NOT NULL REFERENCES groups(name) ON DELETE CASCADE,
+		PRIMARY KEY (username, group_name)
+	);
+	CREATE TABLE tokens (
+		username   TEXT NOT NULL REFERENCES users(username) ON DELETE CASCADE,
+		name       TEXT NOT NULL,
+		hash       TEXT NOT NULL UNIQUE,
+		expires_at INTEGER NOT NULL DEFAULT 0,
+		revoked    INTEGER NOT NULL DEFAULT 0,
+		PRIMARY KEY (username, name)
+	);
+	CREATE TABLE token_domains (
+		username   TEXT NOT NULL,
+		token_name TEXT NOT NULL,
+		domain     TEXT NOT NULL,
+		PRIMARY KEY (username, token_name, domain),
+		FOREIGN KEY (username, token_name) REFERENCES tokens(username, name) ON DELETE CASCADE
+	);
+	-- sessions may belong to users of any store, so no foreign key
+	CREATE TABLE sessions (
+		id         TEXT PRIMARY KEY,
+		username   TEXT NOT NULL,
+		ip         TEXT NOT NULL,
+		created_at INTEGER NOT NULL,
+		expires_at INTEGER NOT NULL,
+		revoked    INTEGER NOT NULL DEFAULT 0
+	);
+	CREATE INDEX sessions_username ON sessions(username);`,
+	// 2: admin role, disabled users and admin tokens
+	`ALTER TABLE users ADD COLUMN admin INTEGER NOT NULL DEFAULT 0;
+	ALTER TABLE users ADD COLUMN disabled INTEGER NOT NULL DEFAULT 0;
+	ALTER TABLE tokens ADD COLUMN admin INTEGER NOT NULL DEFAULT 0;`,
+	// 3: email, for password reset
+	`ALTER TABLE users ADD COLUMN email TEXT NOT NULL DEFAULT '';
+	CREATE INDEX users_email ON users(email COLLATE NOCASE);`,
+	// 4: account validity period and forced password change
+	`ALTER TABLE users ADD COLUMN not_before INTEGER NOT NULL DEFAULT 0;
+	ALTER TABLE users ADD COLUMN expires_at INTEGER NOT NULL DEFAULT 0;
+	ALTER TABLE users ADD COLUMN must_change_password INTEGER NOT NULL DEFAULT 0;`,
+	// 5: language of login pages
+	`ALTER TABLE users ADD COLUMN language TEXT NOT NULL DEFAULT '';`,
+}
+
+// users, groups, tokens and sessions stored in a SQLite database
+type SqlStore struct {
+	Path string
+	db   *sql.DB
+	// serialize writes, SQLite allows only one writer
+	mu sync.Mutex
+}
+
+type Session struct {
+	Id        string
+	Username  string
+	Ip        string
+	CreatedAt time.Time
+	ExpiresAt time.Time
+	Revoked   bool
+}
+
+// open database, creating it if needed, and apply migrations
+func OpenSqlStore(path string) (*SqlStore, error) {
+	if path == "" {
+		return nil, errors.New("db: missing path")
+	}
+	db, err := sql.Open("sqlite", "file:"+path+"?_pragma=foreign_keys(1)&_pragma=busy_timeout(5000)&_pragma=journal_mode(WAL)")
+	if err != nil {
+		return nil, errors.New("db: error opening database\n\t-> " + err.Error())
+	}
+	s := &SqlStore{Path: path, db: db}
+	if err := s.Migrate(); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+// open existing database without writing it, its schema must be up to date
+func OpenSqlStoreReadOnly(path string) (*SqlStore, error) {
+	if err := CheckDatabaseFile(path); err != nil {
+		return nil, err
+	}
+	db, err := sql.Open("sqlite", "file:"+path+"?mode=ro&_pragma=busy_timeout(5000)")
+	if err != nil {
+		return nil, errors.New("db: error opening database\n\t-> " + err.Error())
+	}
+	var v int
+	if err := db.QueryRow(`SELECT COALESCE(MAX(version), 0) FROM schema_version`).Scan(&v); err != nil {
+		db.Close()
+		return nil, errors.New("db: error reading schema version\n\t-> " + err.Error())
+	}
+	if v != len(migrations) {
+		db.Close()
+		return nil, errors.New("db: schema version " + strconv.Itoa(v) + " is not " + strconv.Itoa(len(migrations)) + ", start the server to migrate it")
+	}
+	return &SqlStore{Path: path, db: db}, nil
+}
+
+// check database can be created or opened at path, without touching it
+func CheckDatabaseFile(path string) error {
+	if path == "" {
+		return errors.New("db: missing path")
+	}
+	fi, err := os.Stat(path)
+	switch {
+	case err == nil && !fi.Mode().IsRegular():
+		return errors.New("db: " + path + " is not a file")
+	case err == nil:
+		return nil
+	case !errors.Is(err, fs.ErrNotExist):
+		return errors.New("db: error reading database\n\t-> " + err.Error())
+	}
+	// created on first start
+	if fi, err := os.Stat(filepath.Dir(path)); err != nil || !fi.IsDir() {
+		return errors.New("db: missing directory of " + path)
+	}
+	return nil
+}
+
+func (s *SqlStore) Close() error {
+	return s.db.Close()
+}
+
+// return current schema version, 0 for an empty database
+func (s *SqlStore) SchemaVersion() (int, error) {
+	if _, err := s.db.Exec(`CREATE TABLE IF NOT EXISTS schema_version (version INTEGER NOT NULL)`); err != nil {
+		return 0, errors.New("db: error reading schema version\n\t-> " + err.Error())
+	}
+	var v int
+	if err := s.db.QueryRow(`SELECT COALESCE(MAX(version), 0) FROM schema_version`).Scan(&v); err != nil {
+		return 0, errors.New("db: error reading schema version\n\t-> " + err.Error())
+	}
+	return v, nil
+}
+
+// apply missing migrations, each one in its own transaction
+func (s *SqlStore) Migrate() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	v, err := s.SchemaVersion()
+	if err != nil {
+		return err
+	}
+	if v > len(migrations) {
+		return errors.New("db: schema version " + strconv.Itoa(v) + " is newer than supported, upgrade GFA")
+	}
+	for i := v; i < len(migrations); i++ {
+		err := s.tx(func(tx *sql.Tx) error {
+			if _, err := tx.Exec(migrations[i]); err != nil {
+				return err
+			}
+			_, err := tx.Exec(`INSERT INTO schema_version (version) VALUES (?)`, i+1)
+			return err
+		})
+		if err != nil {
+			return errors.New("db: error applying migration " + strconv.Itoa(i+1) + "\n\t-> " + err.Error())
+		}
+		log.Info("db: migration applied", zap.String("path", s.Path), zap.Int("version", i+1))
+	}
+	return nil
+}
+
+// run f in a transaction, rollback on error
+func (s *SqlStore) tx(f func(tx *sql.Tx) error) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	if err := f(tx); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+// return user with its domains (own and from groups), groups and tokens, nil if not found
+func (s *SqlStore) GetUser(username string) *User {
+	u := &User{Username: username}
+	var notBefore, expiresAt int64
+	err := s.db.QueryRow(`SELECT password, email, admin, disabled, not_before, expires_at, must_change_password, language FROM users WHERE username = ?`, username).
+		Scan(&u.Password, &u.Email, &u.Admin, &u.Disabled, &notBefore, &expiresAt, &u.MustChangePassword, &u.Language)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil
+	}
+	if err != nil {
+		log.Error("db: error reading user", zap.String("username", username), zap.Error(err))
+		return nil
+	}
+	u.NotBefore, u.ExpiresAt = fromUnix(notBefore), fromUnix(expiresAt)
+	if err := s.loadUser(u); err != nil {
+		log.Error("db: error reading user", zap.String("username", username), zap.Error(err))
+		return nil
+	}
+	return u
+}
+
+// load domains, groups and tokens of user
+func (s *SqlStore) loadUser(u *User) (err error) {
+	if u.AllowedDomains, err = s.strings(`
+		SELECT domain FROM user_domains WHERE username = ?1
+		UNION
+		SELECT gd.domain FROM group_domains gd JOIN user_groups ug ON ug.group_name = gd.group_name WHERE ug.username = ?1
+		ORDER BY 1`, u.Username); err != nil {
+		return err
+	}
+	if u.Groups, err = s.strings(`SELECT group_name FROM user_groups WHERE username = ? ORDER BY 1`, u.Username); err != nil {
+		return err
+	}
+
+	rows, err := s.db.Query(`SELECT name, hash, expires_at, revoked, admin FROM tokens WHERE username = ?`, u.Username)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		t := &ApiToken{}
+		var expiresAt int64
+		if err := rows.Scan(&t.Name, &t.Hash, &expiresAt, &t.Revoked, &t.Admin); err != nil {
+			return err
+		}
+		t.ExpiresAt = fromUnix(expiresAt)
+		if u.Tokens == nil {
+			u.Tokens = map[string]*ApiToken{}
+		}
+		u.Tokens[t.Name] = t
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	for _, t := range u.Tokens {
+		if t.AllowedDomains, err = s.strings(`SELECT domain FROM token_domains WHERE username = ? AND token_name = ? ORDER BY 1`, u.Username, t.Name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// return users ordered by name
+func (s *SqlStore) ListUsers() ([]*User, error) {
+	names, err := s.strings(`SELECT username FROM users ORDER BY 1`)
+	if err != nil {
+		return nil, errors.New("db: error listing users\n\t-> " + err.Error())
+	}
+	users := make([]*User, 0, len(names))
+	for _, n := range names {
+		if u := s.GetUser(n); u != nil {
+			users = append(users, u)
+		}
+	}
+	return users, nil
+}
+
+// create user with its own domains and groups, groups are created if needed
+func (s *SqlStore) CreateUser(u *User) error {
+	if u == nil || u.Username == "" || u.Password == "" {
+		return errors.New("db: username and password are mandatory")
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	err := s.tx(func(tx *sql.Tx) error {
+		now := time.Now().Unix()
+		if _, err := tx.Exec(`INSERT INTO users (username, password, email, admin, disabled, not_before, expires_at, must_change_password, language, created_at, updated_at) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+			u.Username, u.Password, u.Email, u.Admin, u.Disabled, toUnix(u.NotBefore), toUnix(u.ExpiresAt), u.MustChangePassword, u.Language, now, now); err != nil {
+			return err
+		}
+		if err := setUserDomains(tx, u.Username, u.AllowedDomains); err != nil {
+			return err
+		}
+		return setUserGroups(tx, u.Username, u.Groups)
+	})
+	if err != nil {
+		return errors.New("db: error creating user\n\t-> " + err.Error())
+	}
+	log.Info("db: user created", zap.String("username", u.Username))
+	return nil
+}
+
+// changes of a user, nil fields are left unchanged
+type UserUpdate struct {
+	Email          *string
+	AllowedDomains *[]string
+	Groups         *[]string
+	Admin          *bool
+	Disabled       *bool
+	// zero time removes the limit
+	NotBefore          *time.Time
+	ExpiresAt          *time.Time
+	MustChangePassword *bool
+	// empty for browser preference
+	Language *string
+}
+
+// apply all changes in a single transaction, disabled user sessions are revoked
+func (s *SqlStore) UpdateUser(username string, up *UserUpdate) error {
+	return s.userTx("updating user", username, func(tx *sql.Tx) error {
+		set := func(column string, value any) error {
+			_, err := tx.Exec(`UPDATE users SET `+column+` = ? WHERE username = ?`, value, username)
+			return err
+		}
+		var err error
+		if up.Email != nil {
+			err = set("email", *up.Email)
+		}
+		if err == nil && up.Admin != nil {
+			err = set("admin", *up.Admin)
+		}
+		if err == nil && up.Disabled != nil {
+			err = set("disabled", *up.Disabled)
+		}
+		if err == nil && up.Disabled != nil && *up.Disabled {
+			_, err = tx.Exec(`UPDATE sessions SET revoked = 1 WHERE username = ?`, username)
+		}
+		if err == nil && up.NotBefore != nil {
+			err = set("not_before", toUnix(*up.NotBefore))
+		}
+		if err == nil && up.ExpiresAt != nil {
+			err = set("expires_at", toUnix(*up.ExpiresAt))
+		}
+		if err == nil && up.MustChangePassword != nil {
+			err = set("must_change_password", *up.MustChangePassword)
+		}
+		if err == nil && up.Language != nil {
+			err = set("language", *up.Language)
+		}
+		if err == nil && up.AllowedDomains != nil {
+			err = setUserDomains(tx, username, *up.AllowedDomains)
+		}
+		if err == nil && up.Groups != nil {
+			err = setUserGroups(tx, username, *up.Groups)
+		}
+		return err
+	})
+}
+
+// update user admin role and state
+func (s *SqlStore) SetUserFlags(username string, admin, disabled bool) error {
+	return s.UpdateUser(username, &UserUpdate{Admin: &admin, Disabled: &disabled})
+}
+
+// set account validity period, zero times for no limit
+func (s *SqlStore) SetUserValidity(username string, notBefore, expiresAt time.Time) error {
+	return s.UpdateUser(username, &UserUpdate{NotBefore: &notBefore, ExpiresAt: &expiresAt})
+}
+
+func (s *SqlStore) SetMustChangePassword(username string, mustChange bool) error {
+	return s.UpdateUser(username, &UserUpdate{MustChangePassword: &mustChange})
+}
+
+func (s *SqlStore) SetUserEmail(username, email string) error {
+	return s.UpdateUser(username, &UserUpdate{Email: &email})
+}
+
+// set language of login pages, empty for browser preference
+func (s *SqlStore) SetUserLanguage(username, language string) error {
+	return s.UpdateUser(username, &UserUpdate{Language: &language})
+}
+
+// return user having email (case insensitive), nil if none
+func (s *SqlStore) GetUserByEmail(email string) *User {
+	var username string
+	err := s.db.QueryRow(`SELECT username FROM users WHERE email = ? COLLATE NOCASE AND email != ''`, email).Scan(&username)
+	if err != nil {
+		if !errors.Is(err, sql.ErrNoRows) {
+			log.Error("db: error reading user", zap.Error(err))
+		}
+		return nil
+	}
+	return s.GetUser(username)
+}
+
+// return user own domains, without the ones from groups
+func (s *SqlStore) GetUserDomains(username string) ([]string, error) {
+	d, err := s.strings(`SELECT domain FROM user_domains WHERE username = ? ORDER BY 1`, username)
+	if err != nil {
+		return nil, errors.New("db: error reading domains\n\t-> " + err.Error())
+	}
+	return d, nil
+}
+
+// delete user, its domains, groups membership and tokens
+func (s *SqlStore) DeleteUser(username string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.exec("deleting user", `DELETE FROM users WHERE username = ?`, username)
+}
+
+func (s *SqlStore) SetPassword(username, hash string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.exec("updating password", `UPDATE users SET password = ?, updated_at = ? WHERE username = ?`, hash, time.Now().Unix(), username)
+}
+
+// replace user own domains
+func (s *SqlStore) SetUserDomains(username string, domains []string) error {
+	return s.UpdateUser(username, &UserUpdate{AllowedDomains: &domains})
+}
+
+// replace user groups, groups are created if needed
+func (s *SqlStore) SetUserGroups(username string, groups []string) error {
+	return s.UpdateUser(username, &UserUpdate{Groups: &groups})
+}
+
+// return groups with their domains
+func (s *SqlStore) ListGroups() (map[string][]string, error) {
+	names, err := s.strings(`SELECT name FROM groups ORDER BY 1`)
+	if err != nil {
+		return nil, errors.New("db: error listing groups\n\t-> " + err.Error())
+	}
+	groups := make(map[string][]string, len(names))
+	for _, n := range names {
+		if groups[n], err = s.strings(`SELECT domain FROM group_domains WHERE group_name = ? ORDER BY 1`, n); err != nil {
+			return nil, errors.New("db: error listing groups\n\t-> " + err.Error())
+		}
+	}
+	return groups, nil
+}
+
+// create group if needed, and replace its domains
+func (s *SqlStore) SetGroupDomains(group string, domains []string) error {
+	if group == "" {
+		return errors.New("db: group name is mandatory")
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	err := s.tx(func(tx *sql.Tx) error {
+		if _, err := tx.Exec(`INSERT OR IGNORE INTO groups (name) VALUES (?)`, group); err != nil {
+			return err
+		}
+		if _, err := tx.Exec(`DELETE FROM group_domains WHERE group_name = ?`, group); err != nil {
+			return err
+		}
+		for _, d := range domains {
+			if _, err := tx.Exec(`INSERT OR IGNORE INTO group_domains (group_name, domain) VALUES (?, ?)`, group, d); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return errors.New("db: error updating group\n\t-> " + err.Error())
+	}
+	return nil
+}
+
+// delete group, its members lose the group domains
+func (s *SqlStore) DeleteGroup(group string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.exec("deleting group", `DELETE FROM groups WHERE name = ?`, group)
+}
+
+// add token to user, t.Hash must be set
+func (s *SqlStore) AddToken(username string, t *ApiToken) error {
+	if t == nil || t.Name == "" || t.Hash == "" {
+		return errors.New("db: token name and hash are mandatory")
+	}
+	return s.userTx("adding token", username, func(tx *sql.Tx) error {
+		if _, err := tx.Exec(`INSERT INTO tokens (username, name, hash, expires_at, revoked, admin) VALUES (?, ?, ?, ?, ?, ?)`,
+			username, t.Name, t.Hash, toUnix(t.ExpiresAt), t.Revoked, t.Admin); err != nil {
+			return err
+		}
+		for _, d := range t.AllowedDomains {
+			if _, err := tx.Exec(`INSERT OR IGNORE INTO token_domains (username, token_name, domain) VALUES (?, ?, ?)`, username, t.Name, d); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (s *SqlStore) RevokeToken(username, name string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.exec("revoking token", `UPDATE tokens SET revoked = 1 WHERE username = ? AND name = ?`, username, name)
+}
+
+func (s *SqlStore) DeleteToken(username, name string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.exec("deleting token", `DELETE FROM tokens WHERE username = ? AND name = ?`, username, name)
+}
+
+// return user owning the token hash, and the token
+func (s *SqlStore) GetUserFromTokenHash(hash string) (*User, *ApiToken) {
+	var username string
+	err := s.db.QueryRow(`SELECT username FROM tokens WHERE hash = ?`, hash).Scan(&username)
+	if err != nil {
+		if !errors.Is(err, sql.ErrNoRows) {
+			log.Error("db: error reading token", zap.Error(err))
+		}
+		return nil, nil
+	}
+	u := s.GetUser(username)
+	if u == nil {
+		return nil, nil
+	}
+	for _, t := range u.Tokens {
+		if t.Hash == hash {
+			return u, t
+		}
+	}
+	return nil, nil
+}
+
+// record a new session, and purge expired ones
+func (s *SqlStore) CreateSession(id, username, ip string, expiresAt time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	err := s.tx(func(tx *sql.Tx) error {
+		now := time.Now().Unix()
+		if _, err := tx.Exec(`DELETE FROM sessions WHERE expires_at < ?`, now); err != nil {
+			return err
+		}
+		_, err := tx.Exec(`INSERT INTO sessions (id, username, ip, created_at, expires_at) VALUES (?, ?, ?, ?, ?)`, id, username, ip, now, expiresAt.Unix())
+		return err
+	})
+	if err != nil {
+		return errors.New("db: error creating session\n\t-> " + err.Error())
+	}
+	return nil
+}
+
+// return true only if session is known and revoked
+// unknown sessions (created before database was enabled, or by another instance) are accepted
+func (s *SqlStore) SessionRevoked(id string) bool {
+	var revoked bool
+	err := s.db.QueryRow(`SELECT revoked FROM sessions WHERE id = ?`, id).Scan(&revoked)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		log.Error("db: error reading session", zap.Error(err))
+	}
+	return revoked
+}
+
+func (s *SqlStore) RevokeSession(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.exec("revoking session", `UPDATE sessions SET revoked = 1 WHERE id = ?`, id)
+}
+
+// revoke every session of user, except the given one (may be empty)
+func (s *SqlStore) RevokeUserSessions(username, except string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, err := s.db.Exec(`UPDATE sessions SET revoked = 1 WHERE username = ? AND id != ?`, username, except)
+	if err != nil {
+		return errors.New("db: error revoking sessions\n\t-> " + err.Error())
+	}
+	return nil
+}
+
+// return active sessions of user, all users if username is empty
+func (s *SqlStore) ListSessions(username string) ([]*Session, error) {
+	rows, err := s.db.Query(`SELECT id, username, ip, created_at, expires_at, revoked FROM sessions
+		WHERE (?1 = '' OR username = ?1) AND expires_at >= ?2 AND revoked = 0 ORDER BY created_at`, username, time.Now().Unix())
+	if err != nil {
+		return nil, errors.New("db: error listing sessions\n\t-> " + err.Error())
+	}
+	defer rows.Close()
+	sessions := []*Session{}
+	for rows.Next() {
+		ss := &Session{}
+		var createdAt, expiresAt int64
+		if err := rows.Scan(&ss.Id, &ss.Username, &ss.Ip, &createdAt, &expiresAt, &ss.Revoked); err != nil {
+			return nil, errors.New("db: error listing sessions\n\t-> " + err.Error())
+		}
+		ss.CreatedAt, ss.ExpiresAt = fromUnix(createdAt), fromUnix(expiresAt)
+		sessions = append(sessions, ss)
+	}
+	return sessions, rows.Err()
+}
+
+// run a single statement, ErrNotFound if no row is affected
+func (s *SqlStore) exec(action, query string, args ...any) error {
+	res, err := s.db.Exec(query, args...)
+	if err != nil {
+		return errors.New("db: error " + action + "\n\t-> " + err.Error())
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// run f in a transaction after checking user exists
+func (s *SqlStore) userTx(action, username string, f func(tx *sql.Tx) error) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	err := s.tx(func(tx *sql.Tx) error {
+		var n int
+		if err := tx.QueryRow(`SELECT COUNT(*) FROM users WHERE username = ?`, username).Scan(&n); err != nil {
+			return err
+		}
+		if n == 0 {
+			return ErrNotFound
+		}
+		if _, err := tx.Exec(`UPDATE users SET updated_at = ? WHERE username = ?`, time.Now().Unix(), username); err != nil {
+			return err
+		}
+		return f(tx)
+	})
+	if errors.Is(err, ErrNotFound) {
+		return ErrNotFound
+	}
+	if err != nil {
+		return errors.New("db: error " + action + "\n\t-> " + err.Error())
+	}
+	return nil
+}
+
+// return first column of rows
+func (s *SqlStore) strings(query string, args ...any) ([]string, error) {
+	rows, err := s.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	ret := []string{}
+	for rows.Next() {
+		var v string
+		if err := rows.Scan(&v); err != nil {
+			return nil, err
+		}
+		ret = append(ret, v)
+	}
+	return ret, rows.Err()
+}
+
+func setUserDomains(tx *sql.Tx, username string, domains []string) error {
+	if _, err := tx.Exec(`DELETE FROM user_domains WHERE username = ?`, username); err != nil {
+		return err
+	}
+	for _, d := range domains {
+		if _, err := tx.Exec(`INSERT OR IGNORE INTO user_domains (username, domain) VALUES (?, ?)`, username, d); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func setUserGroups(tx *sql.Tx, username string, groups []string) error {
+	if _, err := tx.Exec(`DELETE FROM user_groups WHERE username = ?`, username); err != nil {
+		return err
+	}
+	for _, g := range groups {
+		if _, err := tx.Exec(`INSERT OR IGNORE INTO groups (name) VALUES (?)`, g); err != nil {
+			return err
+		}
+		if _, err := tx.Exec(`INSERT OR IGNORE INTO user_groups (username, group_name) VALUES (?, ?)`, username, g); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// zero time is stored as 0
+func toUnix(t time.Time) int64 {
+	if t.IsZero() {
+		return 0
+	}
+	return t.Unix()
+}
+
+func fromUnix(v int64) time.Time {
+	if v == 0 {
+		return time.Time{}
+	}
+	return time.Unix(v, 0).UTC()
+}
@@ -0,0 +1,261 @@
+package main
+
+import (
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// open an empty database in a temp dir
+func newTestSqlStore(t *testing.T) *SqlStore {
+	s, err := OpenSqlStore(filepath.Join(t.TempDir(), "gfa.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { s.Close() })
+	return s
+}
+
+func TestOpenSqlStore(t *testing.T) {
+	_, err := OpenSqlStore("")
+	assert.ErrorContains(t, err, "missing path")
+	_, err = OpenSqlStore(filepath.Join(t.TempDir(), "missing", "gfa.db"))
+	assert.Error(t, err)
+
+	path := filepath.Join(t.TempDir(), "gfa.db")
+	s, err := OpenSqlStore(path)
+	assert.NoError(t, err)
+	v, err := s.SchemaVersion()
+	assert.NoError(t, err)
+	assert.Equal(t, len(migrations), v)
+
+	// reopening doesn't apply migrations again
+	assert.NoError(t, s.CreateUser(&User{Username: "jean", Password: "hash"}))
+	s.Close()
+	s, err = OpenSqlStore(path)
+	assert.NoError(t, err)
+	assert.NotNil(t, s.GetUser("jean"))
+
+	// database from a newer version
+	_, err = s.db.Exec(`INSERT INTO schema_version (version) VALUES (?)`, len(migrations)+1)
+	assert.NoError(t, err)
+	s.Close()
+	_, err = OpenSqlStore(path)
+	assert.ErrorContains(t, err, "upgrade GFA")
+}
+
+func TestSqlStoreUsers(t *testing.T) {
+	s := newTestSqlStore(t)
+
+	assert.Error(t, s.CreateUser(&User{Username: "jean"}))
+	assert.NoError(t, s.CreateUser(&User{
+		Username:       "jean",
+		Password:       "$2y$10$PpzVO0zStuQKJAHmdIBqQuagxkc732nnGR.Iet4SE5tJR1FjOuo.6",
+		AllowedDomains: []string{"allowed.com"},
+		Groups:         []string{"dev"},
+	}))
+	assert.ErrorContains(t, s.CreateUser(&User{Username: "jean", Password: "other"}), "error creating user")
+	assert.NoError(t, s.SetGroupDomains("dev", []string{"git.dev.com", "allowed.com"}))
+
+	u := s.GetUser("jean")
+	if assert.NotNil(t, u) {
+		assert.Equal(t, []string{"allowed.com", "git.dev.com"}, u.AllowedDomains)
+		assert.Equal(t, []string{"dev"}, u.Groups)
+		assert.True(t, CompareHash(u.Password, "pwd"))
+	}
+	assert.Nil(t, s.GetUser("nope"))
+
+	assert.NoError(t, s.SetPassword("jean", "new"))
+	assert.Equal(t, "new", s.GetUser("jean").Password)
+	assert.ErrorIs(t, s.SetPassword("nope", "new"), ErrNotFound)
+
+	assert.NoError(t, s.SetUserDomains("jean", []string{"other.com"}))
+	assert.NoError(t, s.SetUserGroups("jean", []string{"ops"}))
+	assert.ErrorIs(t, s.SetUserDomains("nope", nil), ErrNotFound)
+	u = s.GetUser("jean")
+	assert.Equal(t, []string{"other.com"}, u.AllowedDomains)
+	assert.Equal(t, []string{"ops"}, u.Groups)
+
+	groups, err := s.ListGroups()
+	assert.NoError(t, err)
+	assert.Equal(t, map[string][]string{"dev": {"allowed.com", "git.dev.com"}, "ops": {}}, groups)
+
+	// group deletion removes membership
+	assert.NoError(t, s.SetGroupDomains("ops", []string{"ops.com"}))
+	assert.Contains(t, s.GetUser("jean").AllowedDomains, "ops.com")
+	assert.NoError(t, s.DeleteGroup("ops"))
+	assert.ErrorIs(t, s.DeleteGroup("ops"), ErrNotFound)
+	assert.Empty(t, s.GetUser("jean").Groups)
+
+	assert.NoError(t, s.CreateUser(&User{Username: "admin", Password: "hash"}))
+	users, err := s.ListUsers()
+	assert.NoError(t, err)
+	if assert.Len(t, users, 2) {
+		assert.Equal(t, "admin", users[0].Username)
+	}
+
+	assert.NoError(t, s.DeleteUser("jean"))
+	assert.ErrorIs(t, s.DeleteUser("jean"), ErrNotFound)
+	assert.Nil(t, s.GetUser("jean"))
+}
+
+func TestSqlStoreTokens(t *testing.T) {
+	s := newTestSqlStore(t)
+	assert.NoError(t, s.CreateUser(&User{Username: "jean", Password: "hash", AllowedDomains: []string{"allowed.com"}}))
+
+	token, hash := GenerateApiToken()
+	assert.Error(t, s.AddToken("jean", &ApiToken{Name: "ci"}))
+	assert.ErrorIs(t, s.AddToken("nope", &ApiToken{Name: "ci", Hash: hash}), ErrNotFound)
+	expires := time.Now().Add(time.Hour).Truncate(time.Second)
+	assert.NoError(t, s.AddToken("jean", &ApiToken{Name: "ci", Hash: hash, AllowedDomains: []string{"allowed.com"}, ExpiresAt: expires}))
+
+	u, tk := s.GetUserFromTokenHash(hash)
+	if assert.NotNil(t, u) && assert.NotNil(t, tk) {
+		assert.Equal(t, "jean", u.Username)
+		assert.Equal(t, "ci", tk.Name)
+		assert.Equal(t, []string{"allowed.com"}, tk.AllowedDomains)
+		assert.True(t, expires.Equal(tk.ExpiresAt))
+	}
+	u, _ = s.GetUserFromTokenHash(HashApiToken("nope"))
+	assert.Nil(t, u)
+
+	// token is found through global lookup
+	backup := configuration.db
+	defer func() { configuration.db = backup }()
+	configuration.db = s
+	r := httptest.NewRequest("GET", "/verify", nil)
+	r.Header.Set("Authorization", "Bearer "+token)
+	if u := GetValidUserFromToken(r, "allowed.com"); assert.NotNil(t, u) {
+		assert.Equal(t, s, u.store)
+	}
+
+	assert.NoError(t, s.RevokeToken("jean", "ci"))
+	assert.Nil(t, GetValidUserFromToken(r, "allowed.com"))
+	assert.NoError(t, s.DeleteToken("jean", "ci"))
+	assert.ErrorIs(t, s.DeleteToken("jean", "ci"), ErrNotFound)
+}
+
+func TestSqlStoreSessions(t *testing.T) {
+	s := newTestSqlStore(t)
+
+	assert.NoError(t, s.CreateSession("old", "jean", "1.2.3.4", time.Now().Add(-time.Hour)))
+	assert.NoError(t, s.CreateSession("a", "jean", "1.2.3.4", time.Now().Add(time.Hour)))
+	assert.NoError(t, s.CreateSession("b", "jean", "1.2.3.4", time.Now().Add(time.Hour)))
+	assert.NoError(t, s.CreateSession("c", "admin", "1.2.3.4", time.Now().Add(time.Hour)))
+
+	sessions, err := s.ListSessions("jean")
+	assert.NoError(t, err)
+	assert.Len(t, sessions, 2)
+	sessions, _ = s.ListSessions("")
+	assert.Len(t, sessions, 3)
+
+	assert.False(t, s.SessionRevoked("a"))
+	assert.False(t, s.SessionRevoked("unknown"))
+	assert.NoError(t, s.RevokeSession("a"))
+	assert.ErrorIs(t, s.RevokeSession("unknown"), ErrNotFound)
+	assert.True(t, s.SessionRevoked("a"))
+
+	assert.NoError(t, s.RevokeUserSessions("jean", ""))
+	assert.True(t, s.SessionRevoked("b"))
+	assert.False(t, s.SessionRevoked("c"))
+	sessions, _ = s.ListSessions("")
+	assert.Len(t, sessions, 1)
+}
+
+func TestSessionRevocation(t *testing.T) {
+	s := newTestSqlStore(t)
+	backup := configuration.db
+	defer func() { configuration.db = backup }()
+	configuration.db = s
+
+	c := CreateJwtCookie("jean", "1.2.3.4", []string{"url.net"})
+	cl := GetValidJwtClaims(c, "1.2.3.4", "url.net")
+	if assert.NotNil(t, cl) {
+		sessions, _ := s.ListSessions("jean")
+		assert.Len(t, sessions, 1)
+		assert.NoError(t, s.RevokeSession(cl.ID))
+		assert.Nil(t, GetValidJwtClaims(c, "1.2.3.4", "url.net"))
+	}
+
+	// sessions created before database was enabled are accepted
+	assert.NotNil(t, GetValidJwtClaims(TestCookie["valid"], "1.2.3.4", "url.net"))
+}
+
+func TestSqlStoreEmail(t *testing.T) {
+	s := newTestSqlStore(t)
+
+	assert.NoError(t, s.CreateUser(&User{Username: "jean", Password: "hash", Email: "Jean@Example.com"}))
+	assert.NoError(t, s.CreateUser(&User{Username: "pierre", Password: "hash"}))
+	assert.Equal(t, "Jean@Example.com", s.GetUser("jean").Email)
+
+	// case insensitive, users without email never match
+	if u := s.GetUserByEmail("jean@example.com"); assert.NotNil(t, u) {
+		assert.Equal(t, "jean", u.Username)
+	}
+	assert.Nil(t, s.GetUserByEmail(""))
+
+	assert.NoError(t, s.SetUserEmail("pierre", "pierre@example.com"))
+	assert.Equal(t, "pierre", s.GetUserByEmail("PIERRE@example.com").Username)
+	assert.ErrorIs(t, s.SetUserEmail("nope", "nope@example.com"), ErrNotFound)
+}
+
+func TestSqlStoreUserValidity(t *testing.T) {
+	s := newTestSqlStore(t)
+	notBefore, expiresAt := time.Unix(1700000000, 0).UTC(), time.Unix(1900000000, 0).UTC()
+
+	assert.NoError(t, s.CreateUser(&User{Username: "jean", Password: "hash", ExpiresAt: expiresAt, MustChangePassword: true}))
+	u := s.GetUser("jean")
+	assert.True(t, u.NotBefore.IsZero())
+	assert.Equal(t, expiresAt, u.ExpiresAt)
+	assert.True(t, u.MustChangePassword)
+
+	assert.NoError(t, s.SetUserValidity("jean", notBefore, time.Time{}))
+	assert.NoError(t, s.SetMustChangePassword("jean", false))
+	u = s.GetUser("jean")
+	assert.Equal(t, notBefore, u.NotBefore)
+	assert.True(t, u.ExpiresAt.IsZero())
+	assert.False(t, u.MustChangePassword)
+
+	assert.ErrorIs(t, s.SetUserValidity("nope", notBefore, expiresAt), ErrNotFound)
+	assert.ErrorIs(t, s.SetMustChangePassword("nope", true), ErrNotFound)
+}
+
+func TestSqlStoreUserLanguage(t *testing.T) {
+	s := newTestSqlStore(t)
+
+	assert.NoError(t, s.CreateUser(&User{Username: "jean", Password: "hash", Language: "fr"}))
+	assert.Equal(t, "fr", s.GetUser("jean").Language)
+	assert.NoError(t, s.SetUserLanguage("jean", ""))
+	assert.Empty(t, s.GetUser("jean").Language)
+	assert.ErrorIs(t, s.SetUserLanguage("nope", "fr"), ErrNotFound)
+}
+
+func TestSqlStoreUpdateUser(t *testing.T) {
+	s := newTestSqlStore(t)
+	assert.NoError(t, s.CreateUser(&User{Username: "jean", Password: "hash", Email: "jean@example.com"}))
+	assert.NoError(t, s.CreateSession("s1", "jean", "1.2.3.4", time.Now().Add(time.Hour)))
+
+	email, disabled, groups := "jean@example.org", true, []string{"ops"}
+	assert.NoError(t, s.UpdateUser("jean", &UserUpdate{Email: &email, Disabled: &disabled, Groups: &groups}))
+	u := s.GetUser("jean")
+	assert.Equal(t, "jean@example.org", u.Email)
+	assert.True(t, u.Disabled)
+	assert.Equal(t, []string{"ops"}, u.Groups)
+	// disabled user is logged out
+	sessions, err := s.ListSessions("jean")
+	assert.NoError(t, err)
+	assert.Empty(t, sessions)
+
+	// failure rolls back the whole update
+	_, err = s.db.Exec(`DROP TABLE user_domains`)
+	assert.NoError(t, err)
+	email, domains := "other@example.org", []string{"jean.com"}
+	assert.Error(t, s.UpdateUser("jean", &UserUpdate{Email: &email, AllowedDomains: &domains}))
+	assert.NoError(t, s.db.QueryRow(`SELECT email FROM users WHERE username = ?`, "jean").Scan(&email))
+	assert.Equal(t, "jean@example.org", email)
+
+	assert.ErrorIs(t, s.UpdateUser("nope", &UserUpdate{Email: &email}), ErrNotFound)
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"errors"
+	"regexp"
+	"strings"
+	"sync"
+
+	"go.uber.org/zap"
+)
+
+// prefix of allowed domains given as regular expressions
+const domainRegexpPrefix = "re:"
+
+// characters of regular expressions not found in host names, "." and "*" aside
+const domainRegexpChars = `\^$+?()[]{}|`
+
+// compiled allowed domains, by pattern, or the error if invalid
+var domainPatterns sync.Map
+
+// return the regular expression matching hosts allowed by pattern, anchored at both ends
+//   - "re:<regexp>" is a regular expression
+//   - "*" allows every host
+//   - "example.com" only allows this host, "*.example.com" allows its direct subdomains ("*" matches within a label)
+//   - patterns written as regular expressions before globs were supported (".*example.com") are still read as such, but deprecated
+func domainRegexp(pattern string) string {
+	switch {
+	case strings.HasPrefix(pattern, domainRegexpPrefix):
+		pattern = strings.TrimPrefix(pattern, domainRegexpPrefix)
+	case pattern == "*":
+		pattern = ".*"
+	case legacyDomainRegexp(pattern):
+		// read as before, warned about when compiled
+	default:
+		pattern = strings.ReplaceAll(regexp.QuoteMeta(pattern), `\*`, `[^.]*`)
+	}
+	// host names are case insensitive
+	return `(?i)^(?:` + pattern + `)$`
+}
+
+// return true if pattern looks like a regular expression written before the "re:" prefix (".*example.com")
+func legacyDomainRegexp(pattern string) bool {
+	if strings.HasPrefix(pattern, domainRegexpPrefix) {
+		return false
+	}
+	return strings.Contains(pattern, ".*") || strings.ContainsAny(pattern, domainRegexpChars)
+}
+
+// compile allowed domain pattern, once
+func CompileDomain(pattern string) (*regexp.Regexp, error) {
+	if r, ok := domainPatterns.Load(pattern); ok {
+		if err, ok := r.(error); ok {
+			return nil, err
+		}
+		return r.(*regexp.Regexp), nil
+	}
+	if legacyDomainRegexp(pattern) {
+		log.Warn("domain: pattern read as a regular expression, deprecated, add the 're:' prefix", zap.String("pattern", pattern))
+	}
+	r, err := regexp.Compile(domainRegexp(pattern))
+	if err != nil {
+		log.Error("domain: bad pattern", zap.String("pattern", pattern), zap.Error(err))
+		err = errors.New("domain: bad pattern '" + pattern + "'\n\t-> " + err.Error())
+		domainPatterns.Store(pattern, err)
+		return nil, err
+	}
+	domainPatterns.Store(pattern, r)
+	return r, nil
+}
+
+// return true if domain (without port) is allowed by pattern
+func MatchDomain(pattern, domain string) bool {
+	r, err := CompileDomain(pattern)
+	return err == nil && r.MatchString(domain)
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDomainRegexp(t *testing.T) {
+	testCases := []struct {
+		Name     string
+		Pattern  string
+		Expected string
+	}{
+		{"EXACT", "example.com", `(?i)^(?:example\.com)$`},
+		{"GLOB", "*.example.com", `(?i)^(?:[^.]*\.example\.com)$`},
+		{"ALL", "*", `(?i)^(?:.*)$`},
+		{"REGEXP", "re:(a|b).example.com", `(?i)^(?:(a|b).example.com)$`},
+		{"LEGACY", ".*example.com", `(?i)^(?:.*example.com)$`},
+		{"LEGACY_CHARS", "(a|b).com", `(?i)^(?:(a|b).com)$`},
+	}
+	for _, tc := range testCases {
+		// shadow the test case to avoid modifying the test case
+		tc := tc
+		t.Run(tc.Name, func(t *testing.T) {
+			t.Parallel()
+			assert.Equal(t, tc.Expected, domainRegexp(tc.Pattern))
+		})
+	}
+}
+
+func TestLegacyDomainRegexp(t *testing.T) {
+	assert.True(t, legacyDomainRegexp(".*example.com"))
+	assert.True(t, legacyDomainRegexp("(a|b).com"))
+	assert.False(t, legacyDomainRegexp("re:.*example.com"))
+	assert.False(t, legacyDomainRegexp("*.example.com"))
+	assert.False(t, legacyDomainRegexp("example.com"))
+}
+
+func TestCompileDomain(t *testing.T) {
+	r1, err := CompileDomain("*.compile.com")
+	assert.NoError(t, err)
+	r2, err := CompileDomain("*.compile.com")
+	assert.NoError(t, err)
+	// compiled once
+	assert.Same(t, r1, r2)
+
+	for i := 0; i < 2; i++ {
+		r, err := CompileDomain("re:[a-")
+		assert.Nil(t, r)
+		assert.ErrorContains(t, err, "bad pattern")
+	}
+	assert.False(t, MatchDomain("re:[a-", "a"))
+}
@@ -0,0 +1,220 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/knadh/koanf/v2"
+	"go.uber.org/zap"
+	"gopkg.in/yaml.v3"
+)
+
+// prefix of environment variables overriding configuration
+const envPrefix = "GFA_"
+
+// suffix of environment variables giving the path of a file containing the value
+const envFileSuffix = "_FILE"
+
+// configuration key settable by environment variable
+type envKey struct {
+	path string
+	typ  reflect.Type
+	// path of the map containing the key, and key in this map, if any
+	mapPath string
+	mapKey  string
+}
+
+// map of structs, set by GFA_<MAP>_<KEY>_<FIELD> variables
+type envMap struct {
+	path   string
+	fields map[string]envKey
+}
+
+// list keys of configuration type t, by environment variable name
+// nesting is separated by "_", as koanf keys never contain one
+func listEnvKeys(t reflect.Type, path, name string, keys map[string]envKey, maps map[string]envMap) {
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		tag := f.Tag.Get("koanf")
+		if tag == "" {
+			continue
+		}
+		p, n := path+tag, name+strings.ToUpper(tag)
+		keys[n] = envKey{p, f.Type, "", ""}
+
+		ft := f.Type
+		if ft.Kind() == reflect.Pointer {
+			ft = ft.Elem()
+		}
+		switch {
+		case ft.Kind() == reflect.Struct && ft != reflect.TypeOf(time.Time{}):
+			listEnvKeys(ft, p+".", n+"_", keys, maps)
+		case ft.Kind() == reflect.Map && ft.Elem().Kind() == reflect.Pointer && ft.Elem().Elem().Kind() == reflect.Struct:
+			fields := map[string]envKey{}
+			listEnvKeys(ft.Elem().Elem(), "", "", fields, map[string]envMap{})
+			maps[n+"_"] = envMap{p + ".", fields}
+			keys[n] = envKey{p, f.Type, p + ".", ""}
+		}
+	}
+}
+
+// return configuration key of environment variable name, false if unknown
+func findEnvKey(name string, keys map[string]envKey, maps map[string]envMap) (envKey, bool) {
+	if k, ok := keys[name]; ok {
+		return k, true
+	}
+	// GFA_USERS_JEAN_PASSWORD : map key can contain "_", field can't
+	// map key keeps its case, it is matched case-insensitively with existing keys when set
+	for prefix, m := range maps {
+		if !strings.HasPrefix(name, prefix) {
+			continue
+		}
+		rest := name[len(prefix):]
+		i := strings.LastIndex(rest, "_")
+		if i < 1 {
+			continue
+		}
+		if f, ok := m.fields[rest[i+1:]]; ok {
+			return envKey{m.path + rest[:i] + "." + f.path, f.typ, m.path, rest[:i]}, true
+		}
+	}
+	return envKey{}, false
+}
+
+// maps can be written in files as a list of single-key maps, as Users
+// convert such a list to a map, so values from environment are merged into it
+// return the existing key matching key case-insensitively, key otherwise
+func mergeableEnvMap(k *koanf.Koanf, path, key string) string {
+	path = strings.TrimSuffix(path, ".")
+	if list, ok := k.Get(path).([]interface{}); ok {
+		m := map[string]interface{}{}
+		for _, e := range list {
+			if em, ok := e.(map[string]interface{}); ok {
+				for n, v := range em {
+					m[n] = v
+				}
+			}
+		}
+		k.Delete(path)
+		k.Set(path, m)
+	}
+	for _, existing := range k.MapKeys(path) {
+		if strings.EqualFold(existing, key) {
+			return existing
+		}
+	}
+	return key
+}
+
+// convert environment variable value to the type koanf expects for key
+func (k envKey) value(v string) (interface{}, error) {
+	t := k.typ
+	if t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	switch t.Kind() {
+	// durations are numbers of minutes or seconds, not "1h" strings
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		if t == reflect.TypeOf(time.Duration(0)) {
+			return strconv.ParseInt(v, 10, 64)
+		}
+		return v, nil
+	case reflect.Slice:
+		if t.Elem().Kind() == reflect.String {
+			list := []string{}
+			for _, s := range strings.Split(v, ",") {
+				if s = strings.TrimSpace(s); s != "" {
+					list = append(list, s)
+				}
+			}
+			return list, nil
+		}
+		fallthrough
+	// complex values are given as YAML (or JSON)
+	case reflect.Map, reflect.Struct:
+		if t == reflect.TypeOf(time.Time{}) {
+			return v, nil
+		}
+		var ret interface{}
+		if err := yaml.Unmarshal([]byte(v), &ret); err != nil {
+			return nil, err
+		}
+		return ret, nil
+	}
+	return v, nil
+}
+
+// load configuration from GFA_* environment variables, over configuration files
+// a variable suffixed by _FILE gives the path of a file containing the value, as for docker and kubernetes secrets
+func (c *Config) LoadEnv(k *koanf.Koanf, environ []string) error {
+	if k == nil {
+		return errors.New("config: no koanf provided")
+	}
+	keys, maps := map[string]envKey{}, map[string]envMap{}
+	listEnvKeys(reflect.TypeOf(c).Elem(), "", envPrefix, keys, maps)
+
+	values := map[string]string{}
+	for _, e := range environ {
+		name, v, _ := strings.Cut(e, "=")
+		if strings.HasPrefix(name, envPrefix) {
+			values[name] = v
+		}
+	}
+	// sorted, so a whole map or struct is set before the variables setting its fields
+	names := make([]string, 0, len(values))
+	for name := range values {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		v, fromFile := values[name], false
+		key, ok := findEnvKey(name, keys, maps)
+		if !ok && strings.HasSuffix(name, envFileSuffix) {
+			base := strings.TrimSuffix(name, envFileSuffix)
+			if _, set := values[base]; set {
+				return errors.New("config: both " + base + " and " + name + " are set")
+			}
+			if key, ok = findEnvKey(base, keys, maps); ok {
+				content, err := os.ReadFile(v)
+				if err != nil {
+					return errors.New("config: error reading " + name + "\n\t-> " + err.Error())
+				}
+				v, fromFile = strings.TrimRight(string(content), "\r\n"), true
+			}
+		}
+		if !ok {
+			// GFA_CONFIG is read with command line flags
+			if name != envPrefix+"CONFIG" {
+				log.Info("config: unknown environment variable", zap.String("name", name))
+			}
+			continue
+		}
+		val, err := key.value(v)
+		if err != nil {
+			return errors.New("config: bad value of " + name + "\n\t-> " + err.Error())
+		}
+		switch {
+		case key.mapPath == key.path+".":
+			mergeableEnvMap(k, key.mapPath, "")
+		// field of a map value : <map>.<map key>.<field>
+		case key.mapPath != "":
+			// koanf paths are split on "."
+			if strings.Contains(key.mapKey, ".") {
+				return errors.New("config: bad name " + name + "\n\t-> '.' not allowed in key '" + key.mapKey + "'")
+			}
+			field := strings.TrimPrefix(key.path, key.mapPath+key.mapKey+".")
+			key.path = key.mapPath + mergeableEnvMap(k, key.mapPath, key.mapKey) + "." + field
+		}
+		if err := k.Set(key.path, val); err != nil {
+			return errors.New("config: error setting " + name + "\n\t-> " + err.Error())
+		}
+		log.Debug("config: value from environment", zap.String("name", name), zap.String("key", key.path), zap.Bool("file", fromFile))
+	}
+	return nil
+}
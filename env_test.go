@@ -0,0 +1,172 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	flag "github.com/spf13/pflag" // POSIX compliant
+
+	"github.com/knadh/koanf/v2"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLoadEnv(t *testing.T) {
+	secret := filepath.Join(t.TempDir(), "secret")
+	assert.NoError(t, os.WriteFile(secret, []byte("secret-from-file\n"), 0600))
+
+	testCases := []struct {
+		Name                  string
+		ExpectedError         bool
+		ExpectedErrorContains string
+		Environ               []string
+		Check                 func(t *testing.T, c *Config)
+	}{
+		{
+			Name:    "EMPTY",
+			Environ: []string{"HOME=/root", "GFA_CONFIG=test.config.yml"},
+			Check: func(t *testing.T, c *Config) {
+				assert.Equal(t, uint(9999), c.Port)
+			},
+		},
+		{
+			Name:    "SCALAR",
+			Environ: []string{"GFA_PORT=9000", "GFA_LOGLEVEL=debug", "GFA_TOKENEXPIRE=30"},
+			Check: func(t *testing.T, c *Config) {
+				assert.Equal(t, uint(9000), c.Port)
+				assert.Equal(t, "debug", c.LogLevel)
+				assert.Equal(t, time.Duration(30), c.TokenExpire)
+			},
+		},
+		{
+			Name:    "NESTED",
+			Environ: []string{"GFA_PASSWORDPOLICY_MINLENGTH=12", "GFA_SMTP_ADDRESS=localhost:25"},
+			Check: func(t *testing.T, c *Config) {
+				assert.Equal(t, uint(12), c.PasswordPolicy.MinLength)
+				if assert.NotNil(t, c.Smtp) {
+					assert.Equal(t, "localhost:25", c.Smtp.Address)
+				}
+			},
+		},
+		{
+			Name:    "LIST",
+			Environ: []string{"GFA_BASICAUTHDOMAINS=a.com, b.com,"},
+			Check: func(t *testing.T, c *Config) {
+				assert.Equal(t, []string{"a.com", "b.com"}, c.BasicAuthDomains)
+			},
+		},
+		{
+			Name:    "YAML",
+			Environ: []string{`GFA_USERS={"paul": {"password": "hash", "allowedDomains": ["paul.com"]}}`},
+			Check: func(t *testing.T, c *Config) {
+				if assert.Contains(t, c.Users, "paul") {
+					assert.Equal(t, []string{"paul.com"}, c.Users["paul"].AllowedDomains)
+				}
+				assert.Contains(t, c.Users, "jean")
+			},
+		},
+		{
+			Name:    "MAP_FIELD",
+			Environ: []string{"GFA_USERS_JEAN_PASSWORD=newhash", "GFA_USERS_JEAN_ADMIN=true"},
+			Check: func(t *testing.T, c *Config) {
+				if assert.Contains(t, c.Users, "jean") {
+					assert.Equal(t, "newhash", c.Users["jean"].Password)
+					assert.True(t, c.Users["jean"].Admin)
+					assert.Len(t, c.Users["jean"].AllowedDomains, 3)
+				}
+				assert.Contains(t, c.Users, "admin")
+			},
+		},
+		{
+			Name:    "MAP_NEW_KEY",
+			Environ: []string{"GFA_USERS_Paul_Smith_PASSWORD=hash"},
+			Check: func(t *testing.T, c *Config) {
+				if assert.Contains(t, c.Users, "Paul_Smith") {
+					assert.Equal(t, "hash", c.Users["Paul_Smith"].Password)
+				}
+				assert.NotContains(t, c.Users, "paul_smith")
+			},
+		},
+		{
+			Name:                  "MAP_KEY_DOT",
+			ExpectedError:         true,
+			ExpectedErrorContains: "'.' not allowed in key 'jean.doe'",
+			Environ:               []string{"GFA_USERS_jean.doe_PASSWORD=hash"},
+		},
+		{
+			Name:    "FILE",
+			Environ: []string{"GFA_JWTSECRETKEY_FILE=" + secret},
+			Check: func(t *testing.T, c *Config) {
+				assert.Equal(t, "secret-from-file", c.JwtSecretKey)
+			},
+		},
+		{
+			Name:                  "BOTH",
+			ExpectedError:         true,
+			ExpectedErrorContains: "both GFA_JWTSECRETKEY and GFA_JWTSECRETKEY_FILE",
+			Environ:               []string{"GFA_JWTSECRETKEY=secret", "GFA_JWTSECRETKEY_FILE=" + secret},
+		},
+		{
+			Name:                  "BAD_FILE",
+			ExpectedError:         true,
+			ExpectedErrorContains: "error reading GFA_CSRFSECRETKEY_FILE",
+			Environ:               []string{"GFA_CSRFSECRETKEY_FILE=bad_file"},
+		},
+		{
+			Name:                  "BAD_DURATION",
+			ExpectedError:         true,
+			ExpectedErrorContains: "bad value of GFA_TOKENEXPIRE",
+			Environ:               []string{"GFA_TOKENEXPIRE=1h"},
+		},
+		{
+			Name:    "UNKNOWN",
+			Environ: []string{"GFA_NOTHING=1", "GFA_USERS_JEAN_NOTHING=1"},
+			Check: func(t *testing.T, c *Config) {
+				assert.Equal(t, uint(9999), c.Port)
+			},
+		},
+	}
+	for _, tc := range testCases {
+		// shadow the test case to avoid modifying the test case
+		tc := tc
+		t.Run(tc.Name, func(t *testing.T) {
+			t.Parallel()
+			k := koanf.New(".")
+			c := &Config{ConfigurationFile: []string{"test.config.yml"}}
+			_, err := c.LoadFile(k)
+			assert.NoError(t, err)
+
+			err = c.LoadEnv(k, tc.Environ)
+			if tc.ExpectedError {
+				assert.ErrorContains(t, err, tc.ExpectedErrorContains)
+				return
+			}
+			assert.NoError(t, err)
+			assert.NoError(t, k.Unmarshal("", c))
+			tc.Check(t, c)
+		})
+	}
+
+	assert.ErrorContains(t, (&Config{}).LoadEnv(nil, nil), "no koanf")
+}
+
+func TestLoadEnvPrecedence(t *testing.T) {
+	t.Setenv("GFA_CONFIG", "test.config.yml")
+	t.Setenv("GFA_PORT", "9000")
+	t.Setenv("GFA_LOGLEVEL", "warn")
+
+	f := flag.NewFlagSet("precedence", flag.ContinueOnError)
+	c := &Config{}
+	c.LoadCommandeLine(f)
+	assert.Equal(t, []string{"test.config.yml"}, c.ConfigurationFile)
+
+	// command line wins over environment, which wins over files
+	f.Set("log", "error")
+	c.LoadCommandeLine(f)
+	k := koanf.New(".")
+	err := c.Load(k, f)
+	assert.NoError(t, err)
+	assert.Equal(t, uint(9000), c.Port)
+	assert.Equal(t, "error", c.LogLevel)
+}
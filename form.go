@@ -17,6 +17,8 @@ type FormData struct {
 	// anyip is a checkbox, see here : https://github.com/gorilla/schema/issues/1
 	AnyIp bool   `schema:"anyip" sql:"default: false"`
 	Csrf  string `schema:"csrf"`
+	// url to redirect to after login, when posted without javascript
+	Return string `schema:"return"`
 }
 
 // Extract FormData from request HEADER
@@ -35,6 +37,22 @@ func GetFormData(r *http.Request) (f *FormData) {
 	return f
 }
 
+// Extract FormData from request body, as posted without javascript
+func GetPostFormData(r *http.Request) *FormData {
+	if err := r.ParseForm(); err != nil {
+		log.Error("formdata: error parsing body", zap.Error(err))
+		return nil
+	}
+
+	f := &FormData{}
+	if err := decoder.Decode(f, r.PostForm); err != nil {
+		log.Error("formdata: error decoding formdata", zap.Error(err))
+		return nil
+	}
+
+	return f
+}
+
 // Generate FormData
 func GenerateFormData(username string) *FormData {
 	return &FormData{
@@ -42,17 +60,17 @@ func GenerateFormData(username string) *FormData {
 	}
 }
 
-// Validate FormData and user
-func GetValidUserFromFormData(f *FormData, url string) *User {
+// Validate FormData and user, return the error to show if invalid
+func GetValidUserFromFormData(f *FormData, url string) (*User, error) {
 	if f == nil {
 		log.Error("formdata: no formdata provided")
-		return nil
+		return nil, ErrBadCredentials
 	}
 
 	if f.Password == "" || f.Username == "" {
 		log.Error("formdata: missing password or username")
-		return nil
+		return nil, ErrBadCredentials
 	}
 
-	return GetValidUser(f.Username, f.Password, url)
+	return AuthenticateUser(f.Username, f.Password, url)
 }
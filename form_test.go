@@ -2,6 +2,8 @@ package main
 
 import (
 	"net/http"
+	"net/http/httptest"
+	"strings"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -82,11 +84,13 @@ func TestGetValidUserFromFormData(t *testing.T) {
 		tc := tc
 		t.Run(tc.name, func(t *testing.T) {
 			t.Parallel()
-			u := GetValidUserFromFormData(tc.formData, tc.url)
+			u, err := GetValidUserFromFormData(tc.formData, tc.url)
 			if tc.nilUser {
 				assert.Nil(t, u)
+				assert.ErrorIs(t, err, ErrBadCredentials)
 			} else {
 				assert.NotNil(t, u)
+				assert.NoError(t, err)
 			}
 			if u != nil {
 				assert.Equal(t, tc.expectedUsername, u.Username)
@@ -97,3 +101,37 @@ func TestGetValidUserFromFormData(t *testing.T) {
 	}
 
 }
+
+func TestGetPostFormData(t *testing.T) {
+	testCases := []struct {
+		name             string
+		body             string
+		nilFormData      bool
+		expectedUsername string
+		expectedReturn   string
+		expectedAnyIp    bool
+	}{
+		{"NOMINAL", "username=jacques&password=test&csrf=test", false, "jacques", "", false},
+		{"RETURN", "username=jacques&password=test&return=https%3A%2F%2Fapp.com%2F&anyip=on", false, "jacques", "https://app.com/", true},
+		{"NO_PASSWORD", "username=jacques", true, "", "", false},
+		{"TOO_MUCH", "username=jacques&password=test&MOAR=TEST", true, "", "", false},
+		{"EMPTY", "", true, "", "", false},
+	}
+	for _, tc := range testCases {
+		// shadow the test case to avoid modifying the test case
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			r := httptest.NewRequest("POST", "/login", strings.NewReader(tc.body))
+			r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			f := GetPostFormData(r)
+			if tc.nilFormData {
+				assert.Nil(t, f)
+			} else if assert.NotNil(t, f) {
+				assert.Equal(t, tc.expectedUsername, f.Username)
+				assert.Equal(t, tc.expectedReturn, f.Return)
+				assert.Equal(t, tc.expectedAnyIp, f.AnyIp)
+			}
+		})
+	}
+}
@@ -0,0 +1,482 @@
+package main
+
+import (
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"hash"
+	"strconv"
+	"strings"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+	"golang.org/x/crypto/pbkdf2"
+)
+
+type PasswordHash struct {
+	Algorithm         string `koanf:"Algorithm"`
+	BcryptCost        int    `koanf:"BcryptCost"`
+	Argon2Memory      uint32 `koanf:"Argon2Memory"`
+	Argon2Iterations  uint32 `koanf:"Argon2Iterations"`
+	Argon2Parallelism uint8  `koanf:"Argon2Parallelism"`
+}
+
+const (
+	HashBcrypt   = "bcrypt"
+	HashArgon2id = "argon2id"
+	HashPbkdf2   = "pbkdf2"
+	HashShaCrypt = "sha-crypt"
+	HashMd5Crypt = "md5-crypt"
+	HashSha1     = "sha1"
+	HashUnknown  = ""
+)
+
+// default parameters, as recommended by OWASP
+var defaultPasswordHash = PasswordHash{
+	Algorithm:         HashBcrypt,
+	BcryptCost:        12,
+	Argon2Memory:      19 * 1024,
+	Argon2Iterations:  2,
+	Argon2Parallelism: 1,
+}
+
+// bounds of argon2id parameters, also applied to stored hashes : their cost is paid at each login
+const (
+	argon2MinMemory      = 8
+	argon2MaxMemory      = 256 * 1024 // KiB
+	argon2MaxIterations  = 64
+	argon2MaxParallelism = 16
+)
+
+// bounds of rounds of stored pbkdf2 and sha-crypt hashes, for the same reason
+const (
+	pbkdf2MaxRounds   = 10000000
+	shaCryptMaxRounds = 10000000
+)
+
+// alphabet used by crypt(3) formats
+const cryptAlphabet = "./0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz"
+
+// base64 variant used by passlib ("." instead of "+", no padding)
+var adaptedEncoding = base64.NewEncoding("ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz0123456789./").WithPadding(base64.NoPadding)
+
+// validate parameters, and set default values if init is true
+// unset parameters always take their default value
+func (p *PasswordHash) Valid(init bool) error {
+	if p.Algorithm == "" {
+		p.Algorithm = defaultPasswordHash.Algorithm
+	}
+	if p.BcryptCost == 0 {
+		p.BcryptCost = defaultPasswordHash.BcryptCost
+	}
+	if p.Argon2Memory == 0 {
+		p.Argon2Memory = defaultPasswordHash.Argon2Memory
+	}
+	if p.Argon2Iterations == 0 {
+		p.Argon2Iterations = defaultPasswordHash.Argon2Iterations
+	}
+	if p.Argon2Parallelism == 0 {
+		p.Argon2Parallelism = defaultPasswordHash.Argon2Parallelism
+	}
+	if p.Algorithm != HashBcrypt && p.Algorithm != HashArgon2id {
+		if !init {
+			return errors.New("hash: bad Algorithm '" + p.Algorithm + "' (bcrypt or argon2id)")
+		}
+		p.Algorithm = defaultPasswordHash.Algorithm
+	}
+	if p.BcryptCost < bcrypt.MinCost || p.BcryptCost > bcrypt.MaxCost {
+		if !init {
+			return fmt.Errorf("hash: BcryptCost must be between %d and %d", bcrypt.MinCost, bcrypt.MaxCost)
+		}
+		p.BcryptCost = defaultPasswordHash.BcryptCost
+	}
+	if p.Argon2Memory < argon2MinMemory || p.Argon2Memory > argon2MaxMemory {
+		if !init {
+			return fmt.Errorf("hash: Argon2Memory must be between %d and %d KiB", argon2MinMemory, argon2MaxMemory)
+		}
+		p.Argon2Memory = defaultPasswordHash.Argon2Memory
+	}
+	if p.Argon2Iterations < 1 || p.Argon2Iterations > argon2MaxIterations {
+		if !init {
+			return fmt.Errorf("hash: Argon2Iterations must be between 1 and %d", argon2MaxIterations)
+		}
+		p.Argon2Iterations = defaultPasswordHash.Argon2Iterations
+	}
+	if p.Argon2Parallelism < 1 || p.Argon2Parallelism > argon2MaxParallelism {
+		if !init {
+			return fmt.Errorf("hash: Argon2Parallelism must be between 1 and %d", argon2MaxParallelism)
+		}
+		p.Argon2Parallelism = defaultPasswordHash.Argon2Parallelism
+	}
+	return nil
+}
+
+// return hash of password with given parameters
+func (p *PasswordHash) Hash(password string) (string, error) {
+	params := *p
+	params.Valid(true)
+
+	switch params.Algorithm {
+	case HashArgon2id:
+		salt := *GenerateRandomBytes(16)
+		key := argon2.IDKey([]byte(password), salt, params.Argon2Iterations, params.Argon2Memory, params.Argon2Parallelism, 32)
+		return fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s",
+			argon2.Version, params.Argon2Memory, params.Argon2Iterations, params.Argon2Parallelism,
+			base64.RawStdEncoding.EncodeToString(salt), base64.RawStdEncoding.EncodeToString(key)), nil
+	default:
+		h, err := bcrypt.GenerateFromPassword([]byte(password), params.BcryptCost)
+		return string(h), err
+	}
+}
+
+// return true if hash was not generated with these parameters
+func (p *PasswordHash) NeedsRehash(h string) bool {
+	params := *p
+	params.Valid(true)
+
+	switch GetHashAlgorithm(h) {
+	case HashBcrypt:
+		cost, err := bcrypt.Cost([]byte(h))
+		return params.Algorithm != HashBcrypt || err != nil || cost != params.BcryptCost
+	case HashArgon2id:
+		a, err := parseArgon2id(h)
+		return params.Algorithm != HashArgon2id || err != nil ||
+			a.memory != params.Argon2Memory || a.iterations != params.Argon2Iterations || a.parallelism != params.Argon2Parallelism
+	default:
+		return true
+	}
+}
+
+// detect algorithm from hash format
+func GetHashAlgorithm(h string) string {
+	switch {
+	case strings.HasPrefix(h, "$2a$"), strings.HasPrefix(h, "$2b$"), strings.HasPrefix(h, "$2y$"):
+		return HashBcrypt
+	case strings.HasPrefix(h, "$argon2id$"):
+		return HashArgon2id
+	case strings.HasPrefix(h, "$pbkdf2"):
+		return HashPbkdf2
+	case strings.HasPrefix(h, "$5$"), strings.HasPrefix(h, "$6$"):
+		return HashShaCrypt
+	case strings.HasPrefix(h, "$apr1$"), strings.HasPrefix(h, "$1$"):
+		return HashMd5Crypt
+	case strings.HasPrefix(h, "{SHA}"):
+		return HashSha1
+	default:
+		return HashUnknown
+	}
+}
+
+// compare password with hash, whatever the supported format
+func ComparePasswordHash(h, password string) bool {
+	switch GetHashAlgorithm(h) {
+	case HashBcrypt:
+		return bcrypt.CompareHashAndPassword([]byte(h), []byte(password)) == nil
+	case HashArgon2id:
+		return compareArgon2id(h, password)
+	case HashPbkdf2:
+		return comparePbkdf2(h, password)
+	case HashShaCrypt:
+		return compareShaCrypt(h, password)
+	case HashMd5Crypt:
+		return compareMd5Crypt(h, password)
+	case HashSha1:
+		// unsalted, only supported for htpasswd compatibility
+		sum := sha1.Sum([]byte(password))
+		return subtle.ConstantTimeCompare([]byte(h), []byte("{SHA}"+base64.StdEncoding.EncodeToString(sum[:]))) == 1
+	default:
+		return false
+	}
+}
+
+type argon2idHash struct {
+	memory      uint32
+	iterations  uint32
+	parallelism uint8
+	salt        []byte
+	key         []byte
+}
+
+// parse PHC string "$argon2id$v=19$m=65536,t=3,p=4$<salt>$<key>"
+func parseArgon2id(h string) (*argon2idHash, error) {
+	parts := strings.Split(h, "$")
+	if len(parts) != 6 {
+		return nil, errors.New("hash: bad argon2id format")
+	}
+	var version int
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil || version != argon2.Version {
+		return nil, errors.New("hash: unsupported argon2id version")
+	}
+	a := &argon2idHash{}
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &a.memory, &a.iterations, &a.parallelism); err != nil {
+		return nil, errors.New("hash: bad argon2id parameters")
+	}
+	// argon2.IDKey panics on zero values, and huge ones would exhaust resources
+	if a.memory < argon2MinMemory || a.memory > argon2MaxMemory || a.iterations < 1 || a.iterations > argon2MaxIterations ||
+		a.parallelism < 1 || a.parallelism > argon2MaxParallelism {
+		return nil, errors.New("hash: argon2id parameters out of bounds")
+	}
+	var err error
+	if a.salt, err = base64.RawStdEncoding.DecodeString(parts[4]); err != nil {
+		return nil, err
+	}
+	if a.key, err = base64.RawStdEncoding.DecodeString(parts[5]); err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
+func compareArgon2id(h, password string) bool {
+	a, err := parseArgon2id(h)
+	if err != nil || len(a.key) == 0 {
+		return false
+	}
+	key := argon2.IDKey([]byte(password), a.salt, a.iterations, a.memory, a.parallelism, uint32(len(a.key)))
+	return subtle.ConstantTimeCompare(key, a.key) == 1
+}
+
+// compare passlib format "$pbkdf2-sha256$<rounds>$<salt>$<key>"
+func comparePbkdf2(h, password string) bool {
+	parts := strings.Split(h, "$")
+	if len(parts) != 5 {
+		return false
+	}
+	var hf func() hash.Hash
+	switch parts[1] {
+	case "pbkdf2":
+		hf = sha1.New
+	case "pbkdf2-sha256":
+		hf = sha256.New
+	case "pbkdf2-sha512":
+		hf = sha512.New
+	default:
+		return false
+	}
+	rounds, err := strconv.Atoi(parts[2])
+	if err != nil || rounds < 1 || rounds > pbkdf2MaxRounds {
+		return false
+	}
+	salt, err := adaptedEncoding.DecodeString(parts[3])
+	if err != nil {
+		return false
+	}
+	expected, err := adaptedEncoding.DecodeString(parts[4])
+	if err != nil || len(expected) == 0 {
+		return false
+	}
+	key := pbkdf2.Key([]byte(password), salt, rounds, len(expected), hf)
+	return subtle.ConstantTimeCompare(key, expected) == 1
+}
+
+// compare SHA-crypt "$5$" (sha256) and "$6$" (sha512) hashes, with optional "rounds=<n>$"
+func compareShaCrypt(h, password string) bool {
+	parts := strings.Split(h, "$")
+	if len(parts) < 4 {
+		return false
+	}
+	rounds, customRounds := 5000, false
+	if strings.HasPrefix(parts[2], "rounds=") {
+		r, err := strconv.Atoi(strings.TrimPrefix(parts[2], "rounds="))
+		if err != nil || r > shaCryptMaxRounds {
+			return false
+		}
+		rounds, customRounds = r, true
+		parts = append(parts[:2], parts[3:]...)
+	}
+	if len(parts) != 4 {
+		return false
+	}
+	computed := ShaCrypt(parts[1], password, parts[2], rounds, customRounds)
+	return subtle.ConstantTimeCompare([]byte(computed), []byte(h)) == 1
+}
+
+// byte order of the final digest in SHA-crypt encoding
+var shaCryptOrder = map[string][][3]int{
+	"5": {{0, 10, 20}, {21, 1, 11}, {12, 22, 2}, {3, 13, 23}, {24, 4, 14}, {15, 25, 5}, {6, 16, 26}, {27, 7, 17}, {18, 28, 8}, {9, 19, 29}},
+	"6": {{0, 21, 42}, {22, 43, 1}, {44, 2, 23}, {3, 24, 45}, {25, 46, 4}, {47, 5, 26}, {6, 27, 48}, {28, 49, 7}, {50, 8, 29}, {9, 30, 51}, {31, 52, 10},
+		{53, 11, 32}, {12, 33, 54}, {34, 55, 13}, {56, 14, 35}, {15, 36, 57}, {37, 58, 16}, {59, 17, 38}, {18, 39, 60}, {40, 61, 19}, {62, 20, 41}},
+}
+
+// encode n characters from 24 bits, crypt(3) style
+func cryptEncode24(b2, b1, b0 byte, n int) []byte {
+	w := uint(b2)<<16 | uint(b1)<<8 | uint(b0)
+	out := make([]byte, n)
+	for i := 0; i < n; i++ {
+		out[i] = cryptAlphabet[w&0x3f]
+		w >>= 6
+	}
+	return out
+}
+
+// repeat digest to fill length bytes
+func repeatDigest(d []byte, length int) []byte {
+	out := make([]byte, 0, length)
+	for len(out)+len(d) <= length {
+		out = append(out, d...)
+	}
+	return append(out, d[:length-len(out)]...)
+}
+
+// compute SHA-crypt hash, id is "5" (sha256) or "6" (sha512)
+// see https://www.akkadia.org/drepper/SHA-crypt.txt
+func ShaCrypt(id, password, salt string, rounds int, customRounds bool) string {
+	var hf func() hash.Hash
+	switch id {
+	case "5":
+		hf = sha256.New
+	case "6":
+		hf = sha512.New
+	default:
+		return ""
+	}
+	if len(salt) > 16 {
+		salt = salt[:16]
+	}
+	if rounds < 1000 {
+		rounds = 1000
+	}
+	if rounds > 999999999 {
+		rounds = 999999999
+	}
+	p, s := []byte(password), []byte(salt)
+
+	b := hf()
+	b.Write(p)
+	b.Write(s)
+	b.Write(p)
+	digestB := b.Sum(nil)
+
+	a := hf()
+	a.Write(p)
+	a.Write(s)
+	a.Write(repeatDigest(digestB, len(p)))
+	for i := len(p); i > 0; i >>= 1 {
+		if i&1 != 0 {
+			a.Write(digestB)
+		} else {
+			a.Write(p)
+		}
+	}
+	digestA := a.Sum(nil)
+
+	dp := hf()
+	for range p {
+		dp.Write(p)
+	}
+	pBytes := repeatDigest(dp.Sum(nil), len(p))
+
+	ds := hf()
+	for i := 0; i < 16+int(digestA[0]); i++ {
+		ds.Write(s)
+	}
+	sBytes := repeatDigest(ds.Sum(nil), len(s))
+
+	c := digestA
+	for i := 0; i < rounds; i++ {
+		h := hf()
+		if i&1 != 0 {
+			h.Write(pBytes)
+		} else {
+			h.Write(c)
+		}
+		if i%3 != 0 {
+			h.Write(sBytes)
+		}
+		if i%7 != 0 {
+			h.Write(pBytes)
+		}
+		if i&1 != 0 {
+			h.Write(c)
+		} else {
+			h.Write(pBytes)
+		}
+		c = h.Sum(nil)
+	}
+
+	out := "$" + id + "$"
+	if customRounds {
+		out += "rounds=" + strconv.Itoa(rounds) + "$"
+	}
+	out += salt + "$"
+	encoded := []byte{}
+	for _, o := range shaCryptOrder[id] {
+		encoded = append(encoded, cryptEncode24(c[o[0]], c[o[1]], c[o[2]], 4)...)
+	}
+	if id == "5" {
+		encoded = append(encoded, cryptEncode24(0, c[31], c[30], 3)...)
+	} else {
+		encoded = append(encoded, cryptEncode24(0, 0, c[63], 2)...)
+	}
+	return out + string(encoded)
+}
+
+// compare MD5-crypt "$1$" and Apache "$apr1$" hashes
+func compareMd5Crypt(h, password string) bool {
+	parts := strings.Split(h, "$")
+	if len(parts) != 4 {
+		return false
+	}
+	computed := Md5Crypt("$"+parts[1]+"$", password, parts[2])
+	return subtle.ConstantTimeCompare([]byte(computed), []byte(h)) == 1
+}
+
+// compute MD5-crypt hash, magic is "$1$" or "$apr1$"
+func Md5Crypt(magic, password, salt string) string {
+	if len(salt) > 8 {
+		salt = salt[:8]
+	}
+	p, s := []byte(password), []byte(salt)
+
+	alt := md5.New()
+	alt.Write(p)
+	alt.Write(s)
+	alt.Write(p)
+	digestAlt := alt.Sum(nil)
+
+	ctx := md5.New()
+	ctx.Write(p)
+	ctx.Write([]byte(magic))
+	ctx.Write(s)
+	ctx.Write(repeatDigest(digestAlt, len(p)))
+	for i := len(p); i > 0; i >>= 1 {
+		if i&1 != 0 {
+			ctx.Write([]byte{0})
+		} else if len(p) > 0 {
+			ctx.Write(p[:1])
+		}
+	}
+	final := ctx.Sum(nil)
+
+	for i := 0; i < 1000; i++ {
+		h := md5.New()
+		if i&1 != 0 {
+			h.Write(p)
+		} else {
+			h.Write(final)
+		}
+		if i%3 != 0 {
+			h.Write(s)
+		}
+		if i%7 != 0 {
+			h.Write(p)
+		}
+		if i&1 != 0 {
+			h.Write(final)
+		} else {
+			h.Write(p)
+		}
+		final = h.Sum(nil)
+	}
+
+	encoded := []byte{}
+	for _, o := range [][3]int{{0, 6, 12}, {1, 7, 13}, {2, 8, 14}, {3, 9, 15}, {4, 10, 5}} {
+		encoded = append(encoded, cryptEncode24(final[o[0]], final[o[1]], final[o[2]], 4)...)
+	}
+	encoded = append(encoded, cryptEncode24(0, 0, final[11], 2)...)
+	return magic + salt + "$" + string(encoded)
+}
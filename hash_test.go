@@ -0,0 +1,166 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPasswordHashValid(t *testing.T) {
+	testCases := []struct {
+		name                  string
+		params                PasswordHash
+		init                  bool
+		expectedErrorContains string
+		expectedAlgorithm     string
+		expectedBcryptCost    int
+	}{
+		{"DEFAULT", PasswordHash{}, true, "", HashBcrypt, 12},
+		{"UNSET", PasswordHash{}, false, "", HashBcrypt, 12},
+		{"VALID", PasswordHash{Algorithm: "bcrypt", BcryptCost: 10, Argon2Memory: 1024, Argon2Iterations: 1, Argon2Parallelism: 1}, false, "", HashBcrypt, 10},
+		{"ARGON2ID", PasswordHash{Algorithm: "argon2id"}, true, "", HashArgon2id, 12},
+		{"BCRYPT_COST", PasswordHash{BcryptCost: 14}, true, "", HashBcrypt, 14},
+		{"BAD_ALGORITHM", PasswordHash{Algorithm: "md5"}, false, "bad Algorithm", "", 0},
+		{"BAD_ALGORITHM_INIT", PasswordHash{Algorithm: "md5"}, true, "", HashBcrypt, 12},
+		{"BAD_COST", PasswordHash{Algorithm: "bcrypt", BcryptCost: 99}, false, "BcryptCost", "", 0},
+		{"BAD_COST_INIT", PasswordHash{BcryptCost: 99}, true, "", HashBcrypt, 12},
+		{"BAD_ARGON2_MEMORY", PasswordHash{Algorithm: "argon2id", BcryptCost: 10, Argon2Memory: 1 << 30}, false, "Argon2Memory", "", 0},
+		{"BAD_ARGON2_ITERATIONS", PasswordHash{Algorithm: "argon2id", BcryptCost: 10, Argon2Memory: 1024, Argon2Iterations: 100}, false, "Argon2Iterations", "", 0},
+		{"BAD_ARGON2_PARALLELISM", PasswordHash{Algorithm: "argon2id", BcryptCost: 10, Argon2Memory: 1024, Argon2Iterations: 1, Argon2Parallelism: 255}, false, "Argon2Parallelism", "", 0},
+	}
+	for _, tc := range testCases {
+		// shadow the test case to avoid modifying the test case
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			err := tc.params.Valid(tc.init)
+			if tc.expectedErrorContains != "" {
+				assert.ErrorContains(t, err, tc.expectedErrorContains)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tc.expectedAlgorithm, tc.params.Algorithm)
+			assert.Equal(t, tc.expectedBcryptCost, tc.params.BcryptCost)
+			assert.NotZero(t, tc.params.Argon2Memory)
+			assert.NotZero(t, tc.params.Argon2Iterations)
+			assert.NotZero(t, tc.params.Argon2Parallelism)
+		})
+	}
+}
+
+func TestPasswordHashHash(t *testing.T) {
+	testCases := []struct {
+		name           string
+		params         PasswordHash
+		expectedPrefix string
+	}{
+		{"BCRYPT", PasswordHash{Algorithm: "bcrypt", BcryptCost: 4}, "$2a$04$"},
+		{"ARGON2ID", PasswordHash{Algorithm: "argon2id", Argon2Memory: 1024, Argon2Iterations: 1, Argon2Parallelism: 1}, "$argon2id$v=19$m=1024,t=1,p=1$"},
+	}
+	for _, tc := range testCases {
+		// shadow the test case to avoid modifying the test case
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			h, err := tc.params.Hash("password")
+			assert.NoError(t, err)
+			assert.True(t, strings.HasPrefix(h, tc.expectedPrefix), h)
+			assert.True(t, ComparePasswordHash(h, "password"))
+			assert.False(t, ComparePasswordHash(h, "bad"))
+			assert.False(t, tc.params.NeedsRehash(h))
+
+			// salt is random
+			other, _ := tc.params.Hash("password")
+			assert.NotEqual(t, h, other)
+		})
+	}
+}
+
+func TestPasswordHashNeedsRehash(t *testing.T) {
+	bcrypt10 := "$2y$10$t6XPeRTf5.a.Gb3I/lYq7ukuOpx6fsJRstEXNfOP4jXjjGGZ2Af72"
+	argon2 := "$argon2id$v=19$m=65536,t=2,p=1$c29tZXNhbHQ$CTFhFdXPJO1aFaMaO6Mm5c8y7cJHAph8ArZWb2GRPPc"
+
+	testCases := []struct {
+		name     string
+		params   PasswordHash
+		hash     string
+		expected bool
+	}{
+		{"BCRYPT_SAME_COST", PasswordHash{BcryptCost: 10}, bcrypt10, false},
+		{"BCRYPT_OTHER_COST", PasswordHash{BcryptCost: 12}, bcrypt10, true},
+		{"BCRYPT_TO_ARGON2ID", PasswordHash{Algorithm: "argon2id"}, bcrypt10, true},
+		{"ARGON2ID_SAME", PasswordHash{Algorithm: "argon2id", Argon2Memory: 65536, Argon2Iterations: 2, Argon2Parallelism: 1}, argon2, false},
+		{"ARGON2ID_OTHER", PasswordHash{Algorithm: "argon2id"}, argon2, true},
+		{"ARGON2ID_TO_BCRYPT", PasswordHash{Algorithm: "bcrypt"}, argon2, true},
+		{"BAD_ARGON2ID", PasswordHash{Algorithm: "argon2id"}, "$argon2id$nope", true},
+		{"PBKDF2", PasswordHash{}, "$pbkdf2-sha256$29000$c2FsdHNhbHRzYWx0$/RjveGai8gM6Uwobdbg3sMQM9n.VzIHCzMVnqL6MNyo", true},
+		{"SHACRYPT", PasswordHash{}, "$6$saltstring$svn8UoSVapNtMuq1ukKS4tPQd8iKwSMHWjl/O817G3uBnIFNjnQJuesI68u4OTLiBFdcbYEdFCoEOfaS35inz1", true},
+	}
+	for _, tc := range testCases {
+		// shadow the test case to avoid modifying the test case
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			assert.Equal(t, tc.expected, tc.params.NeedsRehash(tc.hash))
+		})
+	}
+}
+
+func TestComparePasswordHash(t *testing.T) {
+	testCases := []struct {
+		name              string
+		hash              string
+		password          string
+		expectedAlgorithm string
+		expected          bool
+	}{
+		{"BCRYPT", "$2y$10$t6XPeRTf5.a.Gb3I/lYq7ukuOpx6fsJRstEXNfOP4jXjjGGZ2Af72", "pass", HashBcrypt, true},
+		{"BCRYPT_BAD", "$2y$10$t6XPeRTf5.a.Gb3I/lYq7ukuOpx6fsJRstEXNfOP4jXjjGGZ2Af72", "bad", HashBcrypt, false},
+		{"ARGON2ID", "$argon2id$v=19$m=65536,t=2,p=1$c29tZXNhbHQ$CTFhFdXPJO1aFaMaO6Mm5c8y7cJHAph8ArZWb2GRPPc", "password", HashArgon2id, true},
+		{"ARGON2ID_BAD", "$argon2id$v=19$m=65536,t=2,p=1$c29tZXNhbHQ$CTFhFdXPJO1aFaMaO6Mm5c8y7cJHAph8ArZWb2GRPPc", "bad", HashArgon2id, false},
+		{"ARGON2ID_BAD_VERSION", "$argon2id$v=16$m=65536,t=2,p=1$c29tZXNhbHQ$CTFhFdXPJO1aFaMaO6Mm5c8y7cJHAph8ArZWb2GRPPc", "password", HashArgon2id, false},
+		{"ARGON2ID_BAD_FORMAT", "$argon2id$v=19$c29tZXNhbHQ$CTFhFdXPJO1aFaMaO6Mm5c8y7cJHAph8ArZWb2GRPPc", "password", HashArgon2id, false},
+		{"ARGON2ID_ZERO_ITERATIONS", "$argon2id$v=19$m=65536,t=0,p=1$c29tZXNhbHQ$CTFhFdXPJO1aFaMaO6Mm5c8y7cJHAph8ArZWb2GRPPc", "password", HashArgon2id, false},
+		{"ARGON2ID_ZERO_PARALLELISM", "$argon2id$v=19$m=65536,t=2,p=0$c29tZXNhbHQ$CTFhFdXPJO1aFaMaO6Mm5c8y7cJHAph8ArZWb2GRPPc", "password", HashArgon2id, false},
+		{"ARGON2ID_HUGE_MEMORY", "$argon2id$v=19$m=4294967295,t=2,p=1$c29tZXNhbHQ$CTFhFdXPJO1aFaMaO6Mm5c8y7cJHAph8ArZWb2GRPPc", "password", HashArgon2id, false},
+		{"PBKDF2_SHA256", "$pbkdf2-sha256$29000$c2FsdHNhbHRzYWx0$/RjveGai8gM6Uwobdbg3sMQM9n.VzIHCzMVnqL6MNyo", "pass", HashPbkdf2, true},
+		{"PBKDF2_SHA256_BAD", "$pbkdf2-sha256$29000$c2FsdHNhbHRzYWx0$/RjveGai8gM6Uwobdbg3sMQM9n.VzIHCzMVnqL6MNyo", "bad", HashPbkdf2, false},
+		{"PBKDF2_BAD_ROUNDS", "$pbkdf2-sha256$zero$c2FsdHNhbHRzYWx0$/RjveGai8gM6Uwobdbg3sMQM9n.VzIHCzMVnqL6MNyo", "pass", HashPbkdf2, false},
+		{"PBKDF2_TOO_MANY_ROUNDS", "$pbkdf2-sha256$2000000000$c2FsdHNhbHRzYWx0$/RjveGai8gM6Uwobdbg3sMQM9n.VzIHCzMVnqL6MNyo", "pass", HashPbkdf2, false},
+		{"PBKDF2_BAD_DIGEST", "$pbkdf2-md5$29000$c2FsdHNhbHRzYWx0$/RjveGai8gM6Uwobdbg3sMQM9n.VzIHCzMVnqL6MNyo", "pass", HashPbkdf2, false},
+		{"SHA256_CRYPT", "$5$saltstring$5B8vYYiY.CVt1RlTTf8KbXBH3hsxY/GNooZaBBGWEc5", "Hello world!", HashShaCrypt, true},
+		{"SHA256_CRYPT_ROUNDS", "$5$rounds=10000$saltstringsaltst$3xv.VbSHBb41AL9AvLeujZkZRBAwqFMz2.opqey6IcA", "Hello world!", HashShaCrypt, true},
+		{"SHA512_CRYPT", "$6$saltstring$svn8UoSVapNtMuq1ukKS4tPQd8iKwSMHWjl/O817G3uBnIFNjnQJuesI68u4OTLiBFdcbYEdFCoEOfaS35inz1", "Hello world!", HashShaCrypt, true},
+		{"SHA512_CRYPT_ROUNDS", "$6$rounds=10000$saltstringsaltst$OW1/O6BYHV6BcXZu8QVeXbDWra3Oeqh0sbHbbMCVNSnCM/UrjmM0Dp8vOuZeHBy/YTBmSK6H9qs/y3RnOaw5v.", "Hello world!", HashShaCrypt, true},
+		{"SHA512_CRYPT_BAD", "$6$saltstring$svn8UoSVapNtMuq1ukKS4tPQd8iKwSMHWjl/O817G3uBnIFNjnQJuesI68u4OTLiBFdcbYEdFCoEOfaS35inz1", "bad", HashShaCrypt, false},
+		{"SHA_CRYPT_TOO_MANY_ROUNDS", "$6$rounds=2000000000$saltstring$svn8UoSVapNtMuq1ukKS4tPQd8iKwSMHWjl", "Hello world!", HashShaCrypt, false},
+		{"SHA_CRYPT_BAD_ROUNDS", "$6$rounds=nope$saltstring$svn8UoSVapNtMuq1ukKS4tPQd8iKwSMHWjl", "Hello world!", HashShaCrypt, false},
+		{"APR1", "$apr1$saltsalt$xx013OFx2cRtDjpNeiZXf.", "pass", HashMd5Crypt, true},
+		{"APR1_BAD", "$apr1$saltsalt$xx013OFx2cRtDjpNeiZXf.", "bad", HashMd5Crypt, false},
+		{"MD5_CRYPT", "$1$saltsalt$hZR.9zfJXcVTsa9iTxnQR1", "pass", HashMd5Crypt, true},
+		{"MD5_CRYPT_BAD_FORMAT", "$1$saltsalt", "pass", HashMd5Crypt, false},
+		{"SHA1", "{SHA}nU4eI71bcnBGqeO0t9tXvY1u5oQ=", "pass", HashSha1, true},
+		{"SHA1_BAD", "{SHA}nU4eI71bcnBGqeO0t9tXvY1u5oQ=", "bad", HashSha1, false},
+		{"UNKNOWN", "$3$saltsalt$nope", "pass", HashUnknown, false},
+		{"EMPTY", "", "", HashUnknown, false},
+	}
+	for _, tc := range testCases {
+		// shadow the test case to avoid modifying the test case
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			assert.Equal(t, tc.expectedAlgorithm, GetHashAlgorithm(tc.hash))
+			assert.Equal(t, tc.expected, ComparePasswordHash(tc.hash, tc.password))
+		})
+	}
+}
+
+func TestShaCrypt(t *testing.T) {
+	assert.Empty(t, ShaCrypt("1", "pass", "salt", 5000, false))
+	// salt is truncated to 16 characters, rounds are clamped
+	assert.Equal(t,
+		"$5$rounds=10000$saltstringsaltst$3xv.VbSHBb41AL9AvLeujZkZRBAwqFMz2.opqey6IcA",
+		ShaCrypt("5", "Hello world!", "saltstringsaltstring", 10000, true))
+	assert.True(t, strings.HasPrefix(ShaCrypt("6", "pass", "salt", 10, true), "$6$rounds=1000$salt$"))
+}
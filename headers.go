@@ -0,0 +1,133 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// replaced in ContentSecurityPolicy by the nonce of the request
+const cspNoncePlaceholder = "{nonce}"
+
+// value omitting a header
+const headerDisabled = "-"
+
+// security headers of every response, "-" omits a header
+type SecurityHeaders struct {
+	Hsts                  string `koanf:"Hsts"`
+	ContentSecurityPolicy string `koanf:"ContentSecurityPolicy"`
+	FrameOptions          string `koanf:"FrameOptions"`
+	ReferrerPolicy        string `koanf:"ReferrerPolicy"`
+	PermissionsPolicy     string `koanf:"PermissionsPolicy"`
+}
+
+// default ContentSecurityPolicy is built from PublicUrl and CookieDomain
+var defaultSecurityHeaders = SecurityHeaders{
+	Hsts:              "max-age=31536000; includeSubDomains",
+	FrameOptions:      "DENY",
+	ReferrerPolicy:    "no-referrer",
+	PermissionsPolicy: "camera=(), microphone=(), geolocation=(), payment=(), usb=()",
+}
+
+var referrerPolicies = []string{"no-referrer", "no-referrer-when-downgrade", "origin", "origin-when-cross-origin", "same-origin", "strict-origin", "strict-origin-when-cross-origin", "unsafe-url", headerDisabled}
+
+type cspNonceKey struct{}
+
+// return policy allowing resources of GFA only, inline script and style with the nonce of the request
+// login pages are shown on protected hosts : GFA host (publicUrl) serves assets and login form,
+// and hosts sharing the cookie are targets of the login form redirection
+func defaultContentSecurityPolicy(publicUrl, cookieDomain string) string {
+	self := "'self'"
+	if u, err := url.Parse(publicUrl); err == nil && u.Scheme != "" && u.Host != "" {
+		self += " " + u.Scheme + "://" + u.Host
+	}
+	forms := self
+	if d := strings.TrimPrefix(cookieDomain, "."); d != "" {
+		forms += " https://" + d + " https://*." + d
+	}
+	return "default-src " + self +
+		"; script-src " + self + " 'nonce-" + cspNoncePlaceholder + "'" +
+		"; style-src " + self + " 'nonce-" + cspNoncePlaceholder + "' https://cdn.jsdelivr.net" +
+		"; img-src " + self + " data: https:" +
+		"; font-src " + self + " https://cdn.jsdelivr.net" +
+		"; form-action " + forms +
+		"; frame-ancestors 'none'; base-uri 'none'; object-src 'none'"
+}
+
+// set default values, and validate headers
+func (h *SecurityHeaders) Valid(init bool) error {
+	if h.Hsts == "" {
+		h.Hsts = defaultSecurityHeaders.Hsts
+	}
+	if h.FrameOptions == "" {
+		h.FrameOptions = defaultSecurityHeaders.FrameOptions
+	}
+	if h.ReferrerPolicy == "" {
+		h.ReferrerPolicy = defaultSecurityHeaders.ReferrerPolicy
+	}
+	if h.PermissionsPolicy == "" {
+		h.PermissionsPolicy = defaultSecurityHeaders.PermissionsPolicy
+	}
+	for _, v := range []string{h.Hsts, h.ContentSecurityPolicy, h.FrameOptions, h.ReferrerPolicy, h.PermissionsPolicy} {
+		if strings.ContainsAny(v, "\r\n") {
+			return errors.New("headers: values must be on one line")
+		}
+	}
+	switch strings.ToUpper(h.FrameOptions) {
+	case "DENY", "SAMEORIGIN", headerDisabled:
+	default:
+		if !init {
+			return errors.New("headers: bad FrameOptions '" + h.FrameOptions + "' (DENY, SAMEORIGIN or -)")
+		}
+		h.FrameOptions = defaultSecurityHeaders.FrameOptions
+	}
+	if !containsString(referrerPolicies, h.ReferrerPolicy) {
+		if !init {
+			return errors.New("headers: bad ReferrerPolicy '" + h.ReferrerPolicy + "'")
+		}
+		h.ReferrerPolicy = defaultSecurityHeaders.ReferrerPolicy
+	}
+	return nil
+}
+
+func containsString(l []string, s string) bool {
+	for _, e := range l {
+		if e == s {
+			return true
+		}
+	}
+	return false
+}
+
+// set security headers on every response, with a new csp nonce for each request
+func SetSecurityHeaders(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		h := configuration.SecurityHeaders
+		nonce := ""
+		if strings.Contains(h.ContentSecurityPolicy, cspNoncePlaceholder) {
+			nonce = base64.RawURLEncoding.EncodeToString(*GenerateRandomBytes(18))
+			r = r.WithContext(context.WithValue(r.Context(), cspNonceKey{}, nonce))
+		}
+		set := func(name, value string) {
+			if value != "" && value != headerDisabled {
+				w.Header().Set(name, value)
+			}
+		}
+		set("Strict-Transport-Security", h.Hsts)
+		set("Content-Security-Policy", strings.ReplaceAll(h.ContentSecurityPolicy, cspNoncePlaceholder, nonce))
+		set("X-Frame-Options", h.FrameOptions)
+		set("Referrer-Policy", h.ReferrerPolicy)
+		set("Permissions-Policy", h.PermissionsPolicy)
+		w.Header().Set("X-Content-Type-Options", "nosniff")
+		next.ServeHTTP(w, r)
+	})
+}
+
+// return csp nonce of request, for inline scripts and styles of templates
+func CspNonce(r *http.Request) string {
+	nonce, _ := r.Context().Value(cspNonceKey{}).(string)
+	return nonce
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDefaultContentSecurityPolicy(t *testing.T) {
+	csp := defaultContentSecurityPolicy("", "")
+	assert.Contains(t, csp, "default-src 'self';")
+	assert.Contains(t, csp, "script-src 'self' 'nonce-{nonce}';")
+	assert.Contains(t, csp, "form-action 'self';")
+	assert.Contains(t, csp, "frame-ancestors 'none'")
+
+	csp = defaultContentSecurityPolicy("https://auth.example.com:8443/gfa", ".example.com")
+	assert.Contains(t, csp, "script-src 'self' https://auth.example.com:8443 'nonce-{nonce}';")
+	assert.Contains(t, csp, "form-action 'self' https://auth.example.com:8443 https://example.com https://*.example.com;")
+}
+
+func TestSecurityHeadersValid(t *testing.T) {
+	testCases := []struct {
+		Name     string
+		Headers  SecurityHeaders
+		Init     bool
+		Valid    bool
+		Expected SecurityHeaders
+	}{
+		{
+			Name:     "DEFAULTS",
+			Valid:    true,
+			Expected: defaultSecurityHeaders,
+		},
+		{
+			Name:     "CUSTOM",
+			Headers:  SecurityHeaders{Hsts: "-", FrameOptions: "sameorigin", ReferrerPolicy: "same-origin", PermissionsPolicy: "-"},
+			Valid:    true,
+			Expected: SecurityHeaders{Hsts: "-", FrameOptions: "sameorigin", ReferrerPolicy: "same-origin", PermissionsPolicy: "-"},
+		},
+		{
+			Name:    "BAD_FRAME_OPTIONS",
+			Headers: SecurityHeaders{FrameOptions: "ALLOW-FROM a.com"},
+			Valid:   false,
+		},
+		{
+			Name:     "BAD_FRAME_OPTIONS_INIT",
+			Headers:  SecurityHeaders{FrameOptions: "ALLOW-FROM a.com"},
+			Init:     true,
+			Valid:    true,
+			Expected: defaultSecurityHeaders,
+		},
+		{
+			Name:    "BAD_REFERRER_POLICY",
+			Headers: SecurityHeaders{ReferrerPolicy: "never"},
+			Valid:   false,
+		},
+		{
+			Name:    "NEW_LINE",
+			Headers: SecurityHeaders{ContentSecurityPolicy: "default-src 'self'\r\nSet-Cookie: a=b"},
+			Init:    true,
+			Valid:   false,
+		},
+	}
+	for _, tc := range testCases {
+		// shadow the test case to avoid modifying the test case
+		tc := tc
+		t.Run(tc.Name, func(t *testing.T) {
+			t.Parallel()
+			err := tc.Headers.Valid(tc.Init)
+			if !tc.Valid {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tc.Expected, tc.Headers)
+		})
+	}
+}
+
+func TestSetSecurityHeaders(t *testing.T) {
+	backup := configuration.SecurityHeaders
+	defer func() { configuration.SecurityHeaders = backup }()
+
+	nonces := []string{}
+	h := SetSecurityHeaders(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		nonces = append(nonces, CspNonce(r))
+	}))
+	serve := func() http.Header {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+		return w.Result().Header
+	}
+
+	header := serve()
+	assert.Equal(t, defaultSecurityHeaders.Hsts, header.Get("Strict-Transport-Security"))
+	assert.Equal(t, "DENY", header.Get("X-Frame-Options"))
+	assert.Equal(t, "no-referrer", header.Get("Referrer-Policy"))
+	assert.Equal(t, defaultSecurityHeaders.PermissionsPolicy, header.Get("Permissions-Policy"))
+	assert.Equal(t, "nosniff", header.Get("X-Content-Type-Options"))
+	// new nonce for each request, matching the policy
+	serve()
+	if assert.Len(t, nonces, 2) {
+		assert.NotEmpty(t, nonces[0])
+		assert.NotEqual(t, nonces[0], nonces[1])
+		assert.Contains(t, header.Get("Content-Security-Policy"), "'nonce-"+nonces[0]+"'")
+	}
+	assert.NotContains(t, header.Get("Content-Security-Policy"), cspNoncePlaceholder)
+
+	// disabled headers and policy without nonce
+	configuration.SecurityHeaders = SecurityHeaders{Hsts: "-", ContentSecurityPolicy: "default-src 'self'", FrameOptions: "-"}
+	header = serve()
+	assert.Empty(t, header.Get("Strict-Transport-Security"))
+	assert.Empty(t, header.Get("X-Frame-Options"))
+	assert.Equal(t, "default-src 'self'", header.Get("Content-Security-Policy"))
+	assert.Empty(t, nonces[2])
+}
+
+func TestTemplateNonce(t *testing.T) {
+	ctx := &Context{State: "out", Nonce: "abc_123-XYZ"}
+	tpl, err := templates.Get(configuration.HtmlFile, "en")
+	if assert.NoError(t, err) {
+		out := &bytes.Buffer{}
+		assert.NoError(t, tpl.Execute(out, ctx.ToMap("en")))
+		// every inline script and style has the nonce
+		assert.Equal(t, strings.Count(out.String(), "<script"), strings.Count(out.String(), `<script nonce="abc_123-XYZ">`))
+		assert.Equal(t, strings.Count(out.String(), "<style"), strings.Count(out.String(), `<style nonce="abc_123-XYZ">`))
+		assert.Contains(t, out.String(), `<script nonce="abc_123-XYZ">`)
+	}
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// delay between two checks of htpasswd files
+const htpasswdCheckInterval = 5 * time.Second
+
+// users read from an Apache htpasswd file, reloaded when file changes
+type HtpasswdFile struct {
+	Path           string   `koanf:"Path"`
+	AllowedDomains []string `koanf:"AllowedDomains"`
+
+	mu        sync.RWMutex
+	hashes    map[string]string
+	modTime   time.Time
+	checkedAt time.Time
+}
+
+// validate configuration and load file a first time
+func (h *HtpasswdFile) Valid() error {
+	if h.Path == "" {
+		return errors.New("htpasswd: missing Path")
+	}
+	return h.Load()
+}
+
+// read users from file
+func (h *HtpasswdFile) Load() error {
+	fi, err := os.Stat(h.Path)
+	if err != nil {
+		return errors.New("htpasswd: error reading file\n\t-> " + err.Error())
+	}
+	f, err := os.Open(h.Path)
+	if err != nil {
+		return errors.New("htpasswd: error reading file\n\t-> " + err.Error())
+	}
+	defer f.Close()
+
+	hashes, err := ParseHtpasswd(f)
+	if err != nil {
+		return errors.New("htpasswd: error parsing " + h.Path + "\n\t-> " + err.Error())
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.hashes = hashes
+	h.modTime = fi.ModTime()
+	h.checkedAt = time.Now()
+	return nil
+}
+
+// reload file if it changed since last load
+func (h *HtpasswdFile) Refresh() {
+	h.mu.Lock()
+	if time.Since(h.checkedAt) < htpasswdCheckInterval {
+		h.mu.Unlock()
+		return
+	}
+	h.checkedAt = time.Now()
+	modTime := h.modTime
+	h.mu.Unlock()
+
+	fi, err := os.Stat(h.Path)
+	if err != nil || fi.ModTime().Equal(modTime) {
+		return
+	}
+	// keep previous users if new file is invalid
+	if err := h.Load(); err != nil {
+		log.Error("htpasswd: error reloading file", zap.Error(err))
+		return
+	}
+	log.Info("htpasswd: file reloaded", zap.String("file", h.Path))
+}
+
+// return users of file, ordered by name
+func (h *HtpasswdFile) Usernames() []string {
+	h.Refresh()
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	names := make([]string, 0, len(h.hashes))
+	for n := range h.hashes {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// return user from file, with the file allowed domains
+func (h *HtpasswdFile) GetUser(username string) *User {
+	h.Refresh()
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	hash, ok := h.hashes[username]
+	if !ok {
+		return nil
+	}
+	return &User{
+		Username:       username,
+		Password:       hash,
+		AllowedDomains: h.AllowedDomains,
+	}
+}
+
+// parse "username:hash" lines, ignoring comments and empty lines
+func ParseHtpasswd(f io.Reader) (map[string]string, error) {
+	hashes := map[string]string{}
+	scanner := bufio.NewScanner(f)
+	line := 0
+	for scanner.Scan() {
+		line++
+		l := strings.TrimSpace(scanner.Text())
+		if l == "" || strings.HasPrefix(l, "#") {
+			continue
+		}
+		username, hash, found := strings.Cut(l, ":")
+		if !found || username == "" || hash == "" {
+			return nil, errors.New("htpasswd: bad line " + strconv.Itoa(line))
+		}
+		if GetHashAlgorithm(hash) == HashUnknown {
+			log.Error("htpasswd: unsupported hash format, user ignored", zap.String("username", username), zap.Int("line", line))
+			continue
+		}
+		hashes[username] = hash
+	}
+	return hashes, scanner.Err()
+}
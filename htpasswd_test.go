@@ -0,0 +1,70 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const testHtpasswd = `# users
+alice:$apr1$saltsalt$xx013OFx2cRtDjpNeiZXf.
+
+bob:{SHA}nU4eI71bcnBGqeO0t9tXvY1u5oQ=
+carol:$2y$10$t6XPeRTf5.a.Gb3I/lYq7ukuOpx6fsJRstEXNfOP4jXjjGGZ2Af72
+dave:plaintext
+`
+
+func TestParseHtpasswd(t *testing.T) {
+	hashes, err := ParseHtpasswd(strings.NewReader(testHtpasswd))
+	assert.NoError(t, err)
+	// plain text passwords are ignored
+	assert.Len(t, hashes, 3)
+	assert.Equal(t, "{SHA}nU4eI71bcnBGqeO0t9tXvY1u5oQ=", hashes["bob"])
+
+	_, err = ParseHtpasswd(strings.NewReader("alice:$apr1$saltsalt$xx013OFx2cRtDjpNeiZXf.\nnocolon\n"))
+	assert.ErrorContains(t, err, "bad line 2")
+}
+
+func TestHtpasswdFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), ".htpasswd")
+
+	h := &HtpasswdFile{Path: path, AllowedDomains: []string{"allowed.com"}}
+	assert.Error(t, h.Valid())
+	assert.ErrorContains(t, (&HtpasswdFile{}).Valid(), "missing Path")
+
+	assert.NoError(t, os.WriteFile(path, []byte(testHtpasswd), 0600))
+	assert.NoError(t, h.Valid())
+
+	u := h.GetUser("alice")
+	if assert.NotNil(t, u) {
+		assert.Equal(t, "alice", u.Username)
+		assert.True(t, CompareHash(u.Password, "pass"))
+		assert.True(t, u.Allowed("allowed.com"))
+		assert.False(t, u.Allowed("forbidden.com"))
+		// read-only
+		u.store = h
+		assert.ErrorIs(t, u.SetPassword("hash"), ErrReadOnlyStore)
+	}
+	assert.Nil(t, h.GetUser("dave"))
+	assert.Nil(t, h.GetUser("nope"))
+
+	// file change is picked up after check interval
+	assert.NoError(t, os.WriteFile(path, []byte("erin:{SHA}nU4eI71bcnBGqeO0t9tXvY1u5oQ=\n"), 0600))
+	later := time.Now().Add(time.Minute)
+	assert.NoError(t, os.Chtimes(path, later, later))
+	assert.NotNil(t, h.GetUser("alice"))
+	h.checkedAt = time.Time{}
+	assert.Nil(t, h.GetUser("alice"))
+	assert.NotNil(t, h.GetUser("erin"))
+
+	// invalid file keeps previous users
+	assert.NoError(t, os.WriteFile(path, []byte("broken\n"), 0600))
+	later = later.Add(time.Minute)
+	assert.NoError(t, os.Chtimes(path, later, later))
+	h.checkedAt = time.Time{}
+	assert.NotNil(t, h.GetUser("erin"))
+}
@@ -0,0 +1,184 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// language of messages written in code and templates
+const defaultLanguage = "en"
+
+var ErrUnsupportedLanguage = errors.New("i18n: unsupported language")
+
+// translations of user facing messages by language, keyed by english message
+// parameters of messages are written %s, in keys and translations
+var catalogs = map[string]map[string]string{
+	"en": {},
+	"fr": {
+		// errors
+		"Bad credentials":                              "Identifiants incorrects",
+		"Account disabled":                             "Compte désactivé",
+		"Account expired":                              "Compte expiré",
+		"Account not active yet":                       "Compte pas encore actif",
+		"You must change your password":                "Vous devez changer votre mot de passe",
+		"Password expired, contact an administrator":   "Mot de passe expiré, contactez un administrateur",
+		"Unauthorized access":                          "Accès non autorisé",
+		"Restricted Area":                              "Zone restreinte",
+		"Bad current password":                         "Mot de passe actuel incorrect",
+		"Passwords don't match":                        "Les mots de passe ne correspondent pas",
+		"Password can't be changed for this account":   "Le mot de passe de ce compte ne peut pas être changé",
+		"Error changing password":                      "Erreur lors du changement de mot de passe",
+		"Invalid or expired link":                      "Lien invalide ou expiré",
+		"Too many requests, try again later":           "Trop de demandes, réessayez plus tard",
+		"Password must be at least %s characters long": "Le mot de passe doit contenir au moins %s caractères",
+		"Password must be at most %s bytes long":       "Le mot de passe doit faire au plus %s octets",
+		"Password must not contain username":           "Le mot de passe ne doit pas contenir le nom d'utilisateur",
+		"Password must contain at least %s of : lowercase letters, uppercase letters, digits, symbols": "Le mot de passe doit contenir au moins %s types parmi : minuscules, majuscules, chiffres, symboles",
+		"Password is too common":                                 "Mot de passe trop courant",
+		"Password appeared in a data breach, choose another one": "Mot de passe présent dans une fuite de données, choisissez-en un autre",
+		// messages
+		"Password changed":                     "Mot de passe changé",
+		"Password changed, you can now log in": "Mot de passe changé, vous pouvez maintenant vous connecter",
+		"If this account exists, an email has been sent with a link to reset its password": "Si ce compte existe, un email contenant un lien de réinitialisation a été envoyé",
+		"If this email is allowed, a sign-in link has been sent to it":                     "Si cet email est autorisé, un lien de connexion lui a été envoyé",
+		"You are signed in as %s": "Vous êtes connecté en tant que %s",
+		// login page
+		"Login":                        "Connexion",
+		"Username":                     "Nom d'utilisateur",
+		"Password":                     "Mot de passe",
+		"Stay connected from anywhere": "Rester connecté depuis n'importe où",
+		"Forgot password?":             "Mot de passe oublié ?",
+		"Sign in with an email link":   "Se connecter avec un lien par email",
+		"Forgot password":              "Mot de passe oublié",
+		"Username or email":            "Nom d'utilisateur ou email",
+		"Send reset link":              "Envoyer le lien de réinitialisation",
+		"Reset password":               "Réinitialiser le mot de passe",
+		"New password":                 "Nouveau mot de passe",
+		"Confirm new password":         "Confirmer le nouveau mot de passe",
+		"Sign in by email":             "Connexion par email",
+		"Sign in":                      "Se connecter",
+		"Email":                        "Email",
+		"Send sign-in link":            "Envoyer le lien de connexion",
+		"Back to login":                "Retour à la connexion",
+		"Welcome %s":                   "Bienvenue %s",
+		"Continue to %s":               "Continuer vers %s",
+		"Logout":                       "Déconnexion",
+		"Change password":              "Changer le mot de passe",
+		"Current password":             "Mot de passe actuel",
+		"You are : %s":                 "Vous êtes : %s",
+		"Error during login...":        "Erreur lors de la connexion...",
+		"Error changing password...":   "Erreur lors du changement de mot de passe...",
+	},
+}
+
+// message with parameters, matched against formatted messages
+type catalogPattern struct {
+	re  *regexp.Regexp
+	key string
+}
+
+// patterns of messages with parameters, by language
+var catalogPatterns = compileCatalogPatterns()
+
+func compileCatalogPatterns() map[string][]catalogPattern {
+	patterns := map[string][]catalogPattern{}
+	for lang, c := range catalogs {
+		keys := make([]string, 0, len(c))
+		for k := range c {
+			if strings.Contains(k, "%s") {
+				keys = append(keys, k)
+			}
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			re := regexp.MustCompile("^" + strings.ReplaceAll(regexp.QuoteMeta(k), "%s", "(.+?)") + "$")
+			patterns[lang] = append(patterns[lang], catalogPattern{re: re, key: k})
+		}
+	}
+	return patterns
+}
+
+// return true if messages are available in language
+func SupportedLanguage(lang string) bool {
+	_, ok := catalogs[lang]
+	return ok
+}
+
+// return supported languages, sorted
+func Languages() []string {
+	langs := make([]string, 0, len(catalogs))
+	for l := range catalogs {
+		langs = append(langs, l)
+	}
+	sort.Strings(langs)
+	return langs
+}
+
+// translate message in language, formatted with args
+// messages already formatted in english are translated too, and unknown messages are returned as is
+func Translate(lang, msg string, args ...interface{}) string {
+	format := msg
+	if tr, ok := catalogs[lang][msg]; ok {
+		format = tr
+	} else if len(args) == 0 {
+		for _, p := range catalogPatterns[lang] {
+			if m := p.re.FindStringSubmatch(msg); m != nil {
+				format = catalogs[lang][p.key]
+				for _, a := range m[1:] {
+					args = append(args, a)
+				}
+				break
+			}
+		}
+	}
+	// messages without parameters may contain %
+	if len(args) == 0 {
+		return format
+	}
+	return fmt.Sprintf(format, args...)
+}
+
+// return preferred supported language of Accept-Language header, empty if none
+func GetAcceptLanguage(r *http.Request) string {
+	best, bestQ := "", 0.0
+	for _, part := range strings.Split(r.Header.Get("Accept-Language"), ",") {
+		tag, params, _ := strings.Cut(part, ";")
+		q := 1.0
+		if v, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			f, err := strconv.ParseFloat(v, 64)
+			if err != nil {
+				continue
+			}
+			q = f
+		}
+		// fr-CA is served in fr
+		base, _, _ := strings.Cut(strings.ToLower(strings.TrimSpace(tag)), "-")
+		if SupportedLanguage(base) && q > bestQ {
+			best, bestQ = base, q
+		}
+	}
+	return best
+}
+
+// return language of context : user choice, then browser preference, then configured default
+func (ctx *Context) GetLanguage() string {
+	u := ctx.User
+	if u == nil && ctx.Claims != nil {
+		u = lookupUser(ctx.Claims.Subject)
+	}
+	switch {
+	case u != nil && SupportedLanguage(u.Language):
+		return u.Language
+	case ctx.Language != "":
+		return ctx.Language
+	case configuration.Language != "":
+		return configuration.Language
+	default:
+		return defaultLanguage
+	}
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTranslate(t *testing.T) {
+	testCases := []struct {
+		Name     string
+		Lang     string
+		Msg      string
+		Args     []interface{}
+		Expected string
+	}{
+		{"ENGLISH", "en", "Bad credentials", nil, "Bad credentials"},
+		{"FRENCH", "fr", "Bad credentials", nil, "Identifiants incorrects"},
+		{"UNKNOWN_LANGUAGE", "de", "Bad credentials", nil, "Bad credentials"},
+		{"UNKNOWN_MESSAGE", "fr", "100% unknown", nil, "100% unknown"},
+		{"EMPTY", "fr", "", nil, ""},
+		{"ARGS", "fr", "Welcome %s", []interface{}{"jean"}, "Bienvenue jean"},
+		{"ARGS_ENGLISH", "en", "Welcome %s", []interface{}{"jean"}, "Welcome jean"},
+		{"FORMATTED", "fr", "Password must be at least 12 characters long", nil, "Le mot de passe doit contenir au moins 12 caractères"},
+		{"FORMATTED_NAME", "fr", "You are signed in as jean", nil, "Vous êtes connecté en tant que jean"},
+	}
+	for _, tc := range testCases {
+		// shadow the test case to avoid modifying the test case
+		tc := tc
+		t.Run(tc.Name, func(t *testing.T) {
+			t.Parallel()
+			assert.Equal(t, tc.Expected, Translate(tc.Lang, tc.Msg, tc.Args...))
+		})
+	}
+
+	// every error shown to users is translated
+	for _, err := range []error{ErrBadCredentials, ErrUserDisabled, ErrUserExpired, ErrUserNotYetActive, ErrMustChangePassword, ErrPasswordExpired,
+		ErrUnauthorizedAccess, ErrRestrictedArea, ErrBadCurrentPassword, ErrPasswordMismatch, ErrPasswordReadOnly, ErrInvalidLink, ErrTooManyRequests} {
+		assert.Contains(t, catalogs["fr"], err.Error())
+	}
+	assert.Equal(t, []string{"en", "fr"}, Languages())
+}
+
+func TestGetAcceptLanguage(t *testing.T) {
+	testCases := []struct {
+		Name     string
+		Header   string
+		Expected string
+	}{
+		{"NONE", "", ""},
+		{"FRENCH", "fr", "fr"},
+		{"REGION", "fr-CA,fr;q=0.9", "fr"},
+		{"QUALITY", "de-DE,de;q=0.9,en;q=0.5,fr;q=0.7", "fr"},
+		{"ORDER", "en-US, fr", "en"},
+		{"UNSUPPORTED", "de, *;q=0.1", ""},
+		{"BAD_QUALITY", "fr;q=x, en;q=0.2", "en"},
+	}
+	for _, tc := range testCases {
+		// shadow the test case to avoid modifying the test case
+		tc := tc
+		t.Run(tc.Name, func(t *testing.T) {
+			t.Parallel()
+			r := &http.Request{Header: http.Header{"Accept-Language": []string{tc.Header}}}
+			assert.Equal(t, tc.Expected, GetAcceptLanguage(r))
+		})
+	}
+}
+
+func TestContextLanguage(t *testing.T) {
+	assert.Equal(t, configuration.Language, (&Context{}).GetLanguage())
+	assert.Equal(t, "fr", (&Context{Language: "fr"}).GetLanguage())
+	// user choice overrides browser preference
+	assert.Equal(t, "en", (&Context{Language: "fr", User: &User{Language: "en"}}).GetLanguage())
+	assert.Equal(t, "fr", (&Context{Language: "fr", User: &User{Language: "de"}}).GetLanguage())
+}
+
+func TestTranslatedTemplate(t *testing.T) {
+	ctx := &Context{State: "out", Ip: "1.2.3.4", ErrorMessage: ErrBadCredentials.Error(), Language: "fr"}
+	lang := ctx.GetLanguage()
+	tpl, err := templates.Get(configuration.HtmlFile, lang)
+	if assert.NoError(t, err) {
+		out := &bytes.Buffer{}
+		assert.NoError(t, tpl.Execute(out, ctx.ToMap(lang)))
+		assert.Contains(t, out.String(), `<html lang="fr"`)
+		assert.Contains(t, out.String(), "Identifiants incorrects")
+		assert.Contains(t, out.String(), "Nom d&#39;utilisateur")
+		assert.Contains(t, out.String(), "Vous êtes : 1.2.3.4")
+	}
+
+	// same cached template, in english
+	tpl, err = templates.Get(configuration.HtmlFile, "en")
+	if assert.NoError(t, err) {
+		out := &bytes.Buffer{}
+		assert.NoError(t, tpl.Execute(out, ctx.ToMap("en")))
+		assert.Contains(t, out.String(), "Bad credentials")
+		assert.Contains(t, out.String(), "Stay connected from anywhere")
+	}
+}
@@ -13,6 +13,8 @@ import (
 
 type Claims struct {
 	Ip string
+	// cookie only allows to change password
+	ChangePassword bool `json:",omitempty"`
 	jwt.RegisteredClaims
 }
 
@@ -39,6 +41,15 @@ func ValidateClaims(c *Claims, ip, url string) (err error) {
 // Create claims from User
 // return an error if critic parameters are nil
 func CreateJwtCookie(username, ip string, domains []string) *http.Cookie {
+	return createJwtCookie(username, ip, domains, time.Time{}, false)
+}
+
+// create cookie of user, expiring with its account, and only allowing password change if required
+func CreateUserJwtCookie(u *User, ip string) *http.Cookie {
+	return createJwtCookie(u.Username, ip, u.AllowedDomains, u.ExpiresAt, u.MustChangePassword)
+}
+
+func createJwtCookie(username, ip string, domains []string, until time.Time, changePassword bool) *http.Cookie {
 
 	// uniq id
 	id := GenerateRandomBytes(30)
@@ -47,14 +58,20 @@ func CreateJwtCookie(username, ip string, domains []string) *http.Cookie {
 		return nil
 	}
 
+	expiresAt := time.Now().Add(configuration.TokenExpire * time.Minute)
+	if !until.IsZero() && until.Before(expiresAt) {
+		expiresAt = until
+	}
+
 	cl := &Claims{
 		// custom Claims
-		Ip: ip,
+		Ip:             ip,
+		ChangePassword: changePassword,
 		// registered Claims
 		RegisteredClaims: jwt.RegisteredClaims{
 			Subject:   username,
 			ID:        base64.URLEncoding.EncodeToString(*id),
-			ExpiresAt: jwt.NewNumericDate(time.Now().Add(configuration.TokenExpire * time.Minute)),
+			ExpiresAt: jwt.NewNumericDate(expiresAt),
 			IssuedAt:  jwt.NewNumericDate(time.Now()),
 			NotBefore: jwt.NewNumericDate(time.Now()),
 			Issuer:    "GFA",
@@ -62,6 +79,13 @@ func CreateJwtCookie(username, ip string, domains []string) *http.Cookie {
 		},
 	}
 
+	// register session, to be able to revoke it
+	if configuration.db != nil {
+		if err := configuration.db.CreateSession(cl.ID, username, ip, cl.ExpiresAt.Time); err != nil {
+			log.Error("jwt: failed to register session", zap.Error(err))
+		}
+	}
+
 	// create jwt token and sign it
 	token := jwt.NewWithClaims(jwt.SigningMethodHS256, cl)
 	tokenString, _ := token.SignedString([]byte(configuration.JwtSecretKey))
@@ -71,7 +95,7 @@ func CreateJwtCookie(username, ip string, domains []string) *http.Cookie {
 		Value:    tokenString,
 		Expires:  cl.ExpiresAt.Time,
 		Domain:   configuration.CookieDomain,
-		MaxAge:   int(configuration.TokenExpire) * 60,
+		MaxAge:   int(time.Until(expiresAt).Round(time.Second).Seconds()),
 		Secure:   true,
 		HttpOnly: true,
 		SameSite: http.SameSiteLaxMode,
@@ -110,6 +134,10 @@ func GetValidJwtClaims(c *http.Cookie, ip, url string) (cl *Claims) {
 		log.Error("jwt: invalid claims", zap.String("ip", ip), zap.Error(err))
 		return nil
 	}
+	if configuration.db != nil && configuration.db.SessionRevoked(cl.ID) {
+		log.Error("jwt: session revoked", zap.String("ip", ip), zap.String("username", cl.Subject))
+		return nil
+	}
 
 	return cl
 }
@@ -3,6 +3,7 @@ package main
 import (
 	"net/http"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 )
@@ -76,6 +77,27 @@ func TestCreateJwtCookie(t *testing.T) {
 	}
 }
 
+func TestCreateUserJwtCookie(t *testing.T) {
+	// cookie doesn't outlive account
+	expiresAt := time.Now().Add(time.Minute).Truncate(time.Second)
+	u := &User{Username: "jean", AllowedDomains: []string{"url.net"}, ExpiresAt: expiresAt}
+	cookie := CreateUserJwtCookie(u, "1.2.3.4")
+	assert.InDelta(t, 60, cookie.MaxAge, 1)
+	cl := GetValidJwtClaims(cookie, "1.2.3.4", "url.net")
+	if assert.NotNil(t, cl) {
+		assert.Equal(t, expiresAt, cl.ExpiresAt.Time)
+		assert.False(t, cl.ChangePassword)
+	}
+
+	u = &User{Username: "jean", AllowedDomains: []string{"url.net"}, MustChangePassword: true}
+	cookie = CreateUserJwtCookie(u, "1.2.3.4")
+	assert.Equal(t, int(configuration.TokenExpire)*60, cookie.MaxAge)
+	cl = GetValidJwtClaims(cookie, "1.2.3.4", "url.net")
+	if assert.NotNil(t, cl) {
+		assert.True(t, cl.ChangePassword)
+	}
+}
+
 func TestGetValidJwtClaims(t *testing.T) {
 
 	// Helper to create valid cookie
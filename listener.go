@@ -0,0 +1,104 @@
+package main
+
+import (
+	"crypto/tls"
+	"errors"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+)
+
+type Listener struct {
+	Type       string   `koanf:"Type"`
+	Address    string   `koanf:"Address"`
+	SocketMode string   `koanf:"SocketMode"`
+	Endpoints  []string `koanf:"Endpoints"`
+}
+
+const (
+	ListenerTls  = "tls"
+	ListenerHttp = "http"
+	ListenerUnix = "unix"
+)
+
+// validate listener configuration
+func (l *Listener) Valid() error {
+	if l == nil {
+		return errors.New("listener: empty listener")
+	}
+	switch l.Type {
+	case ListenerTls, ListenerHttp:
+		if _, _, err := net.SplitHostPort(l.Address); err != nil {
+			return errors.New("listener: bad Address for " + l.Type + " listener\n\t-> " + err.Error())
+		}
+	case ListenerUnix:
+		if l.Address == "" {
+			return errors.New("listener: missing socket path for unix listener")
+		}
+		if _, err := l.GetSocketMode(); err != nil {
+			return errors.New("listener: bad SocketMode\n\t-> " + err.Error())
+		}
+	default:
+		return errors.New("listener: bad Type '" + l.Type + "' (tls, http or unix)")
+	}
+	for _, e := range l.Endpoints {
+		if _, ok := Endpoints[e]; !ok {
+			return errors.New("listener: unknown endpoint '" + e + "'")
+		}
+	}
+	return nil
+}
+
+// return socket file mode, 0 if none is provided
+func (l *Listener) GetSocketMode() (os.FileMode, error) {
+	if l.SocketMode == "" {
+		return 0, nil
+	}
+	m, err := strconv.ParseUint(l.SocketMode, 8, 32)
+	return os.FileMode(m), err
+}
+
+// return true if at least one listener serve tls
+func HasTlsListener(listeners []*Listener) bool {
+	for _, l := range listeners {
+		if l != nil && l.Type == ListenerTls {
+			return true
+		}
+	}
+	return false
+}
+
+// open listener and serve handler, blocking until server stops
+func (l *Listener) Serve(h http.Handler, tc *tls.Config) error {
+	srv := &http.Server{Addr: l.Address, Handler: h}
+
+	switch l.Type {
+	case ListenerTls:
+		if tc == nil {
+			return errors.New("listener: missing tls configuration")
+		}
+		srv.TLSConfig = tc
+		// certificate is provided by tls configuration
+		return srv.ListenAndServeTLS("", "")
+	case ListenerHttp:
+		return srv.ListenAndServe()
+	case ListenerUnix:
+		// remove stale socket left by a previous run
+		if fi, err := os.Stat(l.Address); err == nil && fi.Mode()&os.ModeSocket != 0 {
+			os.Remove(l.Address)
+		}
+		ln, err := net.Listen("unix", l.Address)
+		if err != nil {
+			return err
+		}
+		if m, _ := l.GetSocketMode(); m != 0 {
+			if err := os.Chmod(l.Address, m); err != nil {
+				ln.Close()
+				return err
+			}
+		}
+		return srv.Serve(ln)
+	}
+	return errors.New("listener: bad Type '" + l.Type + "'")
+}
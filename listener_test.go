@@ -0,0 +1,96 @@
+package main
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestListenerValid(t *testing.T) {
+	testCases := []struct {
+		name                  string
+		listener              *Listener
+		expectedErrorContains string
+	}{
+		{"TLS", &Listener{Type: "tls", Address: ":8000"}, ""},
+		{"HTTP", &Listener{Type: "http", Address: "127.0.0.1:8080", Endpoints: []string{"/verify", "/health"}}, ""},
+		{"UNIX", &Listener{Type: "unix", Address: "/run/gfa.sock", SocketMode: "0660"}, ""},
+		{"NIL", nil, "empty listener"},
+		{"BAD_TYPE", &Listener{Type: "udp", Address: ":8000"}, "bad Type"},
+		{"BAD_ADDRESS", &Listener{Type: "http", Address: "8000"}, "bad Address"},
+		{"NO_SOCKET", &Listener{Type: "unix"}, "missing socket path"},
+		{"BAD_SOCKETMODE", &Listener{Type: "unix", Address: "/run/gfa.sock", SocketMode: "rw"}, "bad SocketMode"},
+		{"BAD_ENDPOINT", &Listener{Type: "tls", Address: ":8000", Endpoints: []string{"/nope"}}, "unknown endpoint"},
+	}
+	for _, tc := range testCases {
+		// shadow the test case to avoid modifying the test case
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			err := tc.listener.Valid()
+			if tc.expectedErrorContains == "" {
+				assert.NoError(t, err)
+			} else {
+				assert.ErrorContains(t, err, tc.expectedErrorContains)
+			}
+		})
+	}
+}
+
+func TestHasTlsListener(t *testing.T) {
+	assert.False(t, HasTlsListener(nil))
+	assert.False(t, HasTlsListener([]*Listener{nil, {Type: "http"}, {Type: "unix"}}))
+	assert.True(t, HasTlsListener([]*Listener{{Type: "http"}, {Type: "tls"}}))
+}
+
+func TestListenerServeUnix(t *testing.T) {
+	socket := filepath.Join(t.TempDir(), "gfa.sock")
+	l := &Listener{Type: "unix", Address: socket, SocketMode: "0600", Endpoints: []string{"/health"}}
+	go l.Serve(NewRouter(l.Endpoints), nil)
+
+	client := &http.Client{
+		Transport: &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				return (&net.Dialer{}).DialContext(ctx, "unix", socket)
+			},
+		},
+	}
+
+	// wait for socket to be created
+	assert.Eventually(t, func() bool {
+		_, err := os.Stat(socket)
+		return err == nil
+	}, time.Second, 10*time.Millisecond)
+
+	fi, err := os.Stat(socket)
+	if assert.NoError(t, err) {
+		assert.Equal(t, os.FileMode(0600), fi.Mode().Perm())
+	}
+
+	resp, err := client.Get("http://gfa/health")
+	if assert.NoError(t, err) {
+		body, _ := io.ReadAll(resp.Body)
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+		assert.Equal(t, "OK", string(body))
+	}
+
+	// endpoint not served by this listener
+	resp, err = client.Get("http://gfa/verify")
+	if assert.NoError(t, err) {
+		assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+	}
+}
+
+func TestListenerServeError(t *testing.T) {
+	assert.Error(t, (&Listener{Type: "nope"}).Serve(nil, nil))
+	assert.Error(t, (&Listener{Type: "unix", Address: filepath.Join(t.TempDir(), "no", "dir.sock")}).Serve(nil, nil))
+	assert.Error(t, (&Listener{Type: "http", Address: "bad:address:1"}).Serve(nil, nil))
+	assert.ErrorContains(t, (&Listener{Type: "tls", Address: ":0"}).Serve(nil, nil), "missing tls configuration")
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// failed logins of a user
+type Lockout struct {
+	Username    string
+	Failures    uint
+	LastFailure time.Time
+	LockedUntil time.Time
+}
+
+// lock users after too many failed logins
+type LockoutTracker struct {
+	mu      sync.Mutex
+	entries map[string]*Lockout
+}
+
+var lockouts = &LockoutTracker{}
+
+// return true if user is currently locked
+func (l *LockoutTracker) Locked(username string) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	e, ok := l.entries[username]
+	return ok && time.Now().Before(e.LockedUntil)
+}
+
+// record a failed login, lock user when threshold is reached
+// return true if user got locked by this failure
+func (l *LockoutTracker) Fail(username string, threshold uint, duration time.Duration) bool {
+	if threshold == 0 {
+		return false
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := time.Now()
+	if l.entries == nil {
+		l.entries = map[string]*Lockout{}
+	}
+	// forget old failures
+	for k, e := range l.entries {
+		if now.Sub(e.LastFailure) > duration && now.After(e.LockedUntil) {
+			delete(l.entries, k)
+		}
+	}
+	e, ok := l.entries[username]
+	if !ok {
+		e = &Lockout{Username: username}
+		l.entries[username] = e
+	}
+	e.Failures++
+	e.LastFailure = now
+	if e.Failures >= threshold && now.After(e.LockedUntil) {
+		e.LockedUntil = now.Add(duration)
+		e.Failures = 0
+		return true
+	}
+	return false
+}
+
+// forget failures of user, after a successful login or an admin action
+func (l *LockoutTracker) Unlock(username string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	delete(l.entries, username)
+}
+
+// return currently locked users, by name
+func (l *LockoutTracker) List() []Lockout {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	ret := []Lockout{}
+	now := time.Now()
+	for _, e := range l.entries {
+		if now.Before(e.LockedUntil) {
+			ret = append(ret, *e)
+		}
+	}
+	sort.Slice(ret, func(i, j int) bool { return ret[i].Username < ret[j].Username })
+	return ret
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLockoutTracker(t *testing.T) {
+	l := &LockoutTracker{}
+
+	// disabled
+	assert.False(t, l.Fail("jean", 0, time.Minute))
+	assert.False(t, l.Locked("jean"))
+
+	assert.False(t, l.Fail("jean", 3, time.Minute))
+	assert.False(t, l.Fail("jean", 3, time.Minute))
+	assert.False(t, l.Locked("jean"))
+	assert.True(t, l.Fail("jean", 3, time.Minute))
+	assert.True(t, l.Locked("jean"))
+	// already locked
+	assert.False(t, l.Fail("jean", 3, time.Minute))
+	assert.False(t, l.Locked("admin"))
+
+	locked := l.List()
+	if assert.Len(t, locked, 1) {
+		assert.Equal(t, "jean", locked[0].Username)
+	}
+
+	l.Unlock("jean")
+	assert.False(t, l.Locked("jean"))
+	assert.Empty(t, l.List())
+
+	// lock expires
+	assert.True(t, l.Fail("jean", 1, time.Millisecond))
+	time.Sleep(2 * time.Millisecond)
+	assert.False(t, l.Locked("jean"))
+	// expired entries are purged
+	l.Fail("admin", 5, time.Millisecond)
+	assert.Len(t, l.entries, 1)
+}
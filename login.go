@@ -0,0 +1,112 @@
+package main
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/gorilla/csrf"
+	"go.uber.org/zap"
+)
+
+// login form posted in the body, without javascript, on GFA host
+const loginPath = "/login"
+
+// return url if it is allowed as redirection after login, empty otherwise
+func ValidReturnUrl(raw string) string {
+	if raw == "" || strings.ContainsAny(raw, "\r\n") {
+		return ""
+	}
+	u, err := url.Parse(raw)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" || u.User != nil {
+		return ""
+	}
+	if !AllowedRedirectHost(u.Hostname()) {
+		log.Info("login: return url refused", zap.String("host", u.Hostname()))
+		return ""
+	}
+	return u.String()
+}
+
+// hosts GFA can redirect to : hosts sharing the cookie (CookieDomain), and GFA host (PublicUrl)
+func AllowedRedirectHost(host string) bool {
+	host = strings.ToLower(host)
+	if d := strings.ToLower(strings.TrimPrefix(configuration.CookieDomain, ".")); d != "" && (host == d || strings.HasSuffix(host, "."+d)) {
+		return true
+	}
+	if configuration.PublicUrl != "" {
+		if u, err := url.Parse(configuration.PublicUrl); err == nil && strings.EqualFold(u.Hostname(), host) {
+			return true
+		}
+	}
+	return false
+}
+
+// login with credentials posted in the body, then redirect to the return url
+func LoginHandler(w http.ResponseWriter, r *http.Request) {
+
+	// only forms are posted here, show login page
+	if r.Method != http.MethodPost {
+		http.Redirect(w, r, "/", http.StatusFound)
+		return
+	}
+
+	ctx := &Context{
+		CsrfToken: csrf.Token(r),
+		Ip:        GetIp(r),
+		Url:       GetHost(r),
+		Language:  GetAcceptLanguage(r),
+		Nonce:     CspNonce(r),
+		State:     "out",
+	}
+	log.Sugar().Debug("server: login posted", zap.String("ip", ctx.Ip), "request", RedactRequest(r))
+
+	ctx.FormData = GetPostFormData(r)
+	if ctx.FormData != nil {
+		ctx.ReturnUrl = ValidReturnUrl(ctx.FormData.Return)
+	}
+	// domains are checked against the host to return to
+	if ctx.ReturnUrl != "" {
+		ctx.Url = GetDomain(ctx.ReturnUrl)
+	}
+
+	var err error
+	ctx.User, err = GetValidUserFromFormData(ctx.FormData, ctx.Url)
+	if ctx.User == nil {
+		username := ""
+		if ctx.FormData != nil {
+			username = ctx.FormData.Username
+		}
+		auditLog.Add(username, "login failed", ctx.Url, ctx.Ip)
+		time.Sleep(500 * time.Millisecond)
+		ctx.HttpReturnCode = http.StatusUnauthorized
+		ctx.ErrorMessage = err.Error()
+		log.Info("Loading Template", zap.Int("status", ctx.HttpReturnCode), zap.Error(LoadTemplate(&w, ctx)))
+		return
+	}
+
+	log.Info("server: new jwt", zap.String("ip", ctx.Ip))
+	auditLog.Add(ctx.User.Username, "login", ctx.Url, ctx.Ip)
+	// set MagicIp if user allow connection from anyip
+	claimsIp := ctx.Ip
+	if ctx.FormData.AnyIp {
+		claimsIp = configuration.MagicIp
+	}
+	if c := CreateUserJwtCookie(ctx.User, claimsIp); c != nil {
+		http.SetCookie(w, c)
+	}
+
+	// back to the protected page, or to the welcome page
+	// cookie only allows to change password, on the welcome page
+	target := ctx.ReturnUrl
+	if target == "" || ctx.User.MustChangePassword {
+		target = "/"
+	}
+	http.Redirect(w, r, target, http.StatusSeeOther)
+}
+
+// url the login form is posted to without javascript
+func (ctx *Context) LoginUrl() string {
+	return configuration.PublicUrl + loginPath
+}
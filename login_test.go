@@ -0,0 +1,122 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidReturnUrl(t *testing.T) {
+	testCases := []struct {
+		Name     string
+		Url      string
+		Expected string
+	}{
+		{"EMPTY", "", ""},
+		{"COOKIE_DOMAIN", "https://test_domain/", "https://test_domain/"},
+		{"SUBDOMAIN", "https://app.test_domain/path?a=1", "https://app.test_domain/path?a=1"},
+		{"PORT", "http://app.test_domain:8080/", "http://app.test_domain:8080/"},
+		{"CASE", "https://APP.Test_Domain/", "https://APP.Test_Domain/"},
+		{"OTHER_DOMAIN", "https://evil.com/", ""},
+		{"SUFFIX", "https://eviltest_domain/", ""},
+		{"RELATIVE", "/path", ""},
+		{"SCHEME_RELATIVE", "//evil.com/", ""},
+		{"JAVASCRIPT", "javascript:alert(1)", ""},
+		{"USERINFO", "https://app.test_domain@evil.com/", ""},
+		{"NEWLINE", "https://app.test_domain/\r\nSet-Cookie:a=b", ""},
+	}
+	for _, tc := range testCases {
+		// shadow the test case to avoid modifying the test case
+		tc := tc
+		t.Run(tc.Name, func(t *testing.T) {
+			t.Parallel()
+			assert.Equal(t, tc.Expected, ValidReturnUrl(tc.Url))
+		})
+	}
+}
+
+func TestAllowedRedirectHost(t *testing.T) {
+	backup := configuration.PublicUrl
+	defer func() { configuration.PublicUrl = backup }()
+	configuration.PublicUrl = "https://auth.example.com:8443"
+
+	assert.True(t, AllowedRedirectHost("auth.example.com"))
+	assert.True(t, AllowedRedirectHost("sub.test_domain"))
+	assert.False(t, AllowedRedirectHost("example.com"))
+	assert.False(t, AllowedRedirectHost(""))
+}
+
+func TestLoginHandler(t *testing.T) {
+	testCases := []struct {
+		Name             string
+		Method           string
+		Form             url.Values
+		ExpectedHttpCode int
+		ExpectedLocation string
+		ExpectedCookie   bool
+		ExpectedBody     string
+	}{
+		{"GET", "GET", nil, http.StatusFound, "/", false, ""},
+		{"NOMINAL", "POST", url.Values{"username": {"admin"}, "password": {"pass"}, "return": {"https://app.test_domain/page"}}, http.StatusSeeOther, "https://app.test_domain/page", true, ""},
+		{"NO_RETURN", "POST", url.Values{"username": {"admin"}, "password": {"pass"}, "anyip": {"on"}}, http.StatusSeeOther, "/", true, ""},
+		{"BAD_RETURN", "POST", url.Values{"username": {"admin"}, "password": {"pass"}, "return": {"https://evil.com/"}}, http.StatusSeeOther, "/", true, ""},
+		{"BAD_PASSWORD", "POST", url.Values{"username": {"admin"}, "password": {"nope"}, "return": {"https://app.test_domain/page"}}, http.StatusUnauthorized, "", false, "https://app.test_domain/page"},
+		{"DOMAIN_NOT_ALLOWED", "POST", url.Values{"username": {"jean"}, "password": {"pwd"}, "return": {"https://app.test_domain/"}}, http.StatusUnauthorized, "", false, "Bad credentials"},
+		{"NO_FORM", "POST", url.Values{}, http.StatusUnauthorized, "", false, "Bad credentials"},
+	}
+	for _, tc := range testCases {
+		// shadow the test case to avoid modifying the test case
+		tc := tc
+		t.Run(tc.Name, func(t *testing.T) {
+			t.Parallel()
+			req := httptest.NewRequest(tc.Method, "https://auth.test_domain"+loginPath, strings.NewReader(tc.Form.Encode()))
+			req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			w := httptest.NewRecorder()
+			NewRouter(nil).ServeHTTP(w, req)
+			resp := w.Result()
+			body, _ := io.ReadAll(resp.Body)
+
+			assert.Equal(t, tc.ExpectedHttpCode, resp.StatusCode)
+			assert.Equal(t, tc.ExpectedLocation, resp.Header.Get("Location"))
+			cookieSet := false
+			for _, c := range resp.Cookies() {
+				if c.Name == configuration.CookieName && c.Value != "" {
+					cookieSet = true
+				}
+			}
+			assert.Equal(t, tc.ExpectedCookie, cookieSet)
+			assert.Contains(t, string(body), tc.ExpectedBody)
+		})
+	}
+}
+
+func TestLoginHandlerMustChangePassword(t *testing.T) {
+	backup := configuration.db
+	defer func() { configuration.db = backup }()
+	s := newTestSqlStore(t)
+	configuration.db = s
+	assert.NoError(t, s.CreateUser(&User{Username: "carol", Password: GetHash("oldpassword"), AllowedDomains: []string{"*"}, MustChangePassword: true}))
+
+	form := url.Values{"username": {"carol"}, "password": {"oldpassword"}, "return": {"https://app.test_domain/page"}}
+	req := httptest.NewRequest("POST", "https://auth.test_domain"+loginPath, strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.RemoteAddr = "1.2.3.4"
+	w := httptest.NewRecorder()
+	NewRouter(nil).ServeHTTP(w, req)
+	resp := w.Result()
+
+	// sent to the password change page, not to the protected page it can't access
+	assert.Equal(t, http.StatusSeeOther, resp.StatusCode)
+	assert.Equal(t, "/", resp.Header.Get("Location"))
+	if assert.Len(t, resp.Cookies(), 1) {
+		cl := GetValidJwtClaims(resp.Cookies()[0], "1.2.3.4", "app.test_domain")
+		if assert.NotNil(t, cl) {
+			assert.True(t, cl.ChangePassword)
+		}
+	}
+}
@@ -0,0 +1,215 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"net/mail"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/gorilla/csrf"
+	"go.uber.org/zap"
+)
+
+// path of the passwordless login page
+const magicLinkPath = "/magic"
+
+// passwordless login by a link sent by email, for allowed email domains
+type MagicLink struct {
+	// validity of links, in minutes
+	Expire       time.Duration      `koanf:"Expire"`
+	EmailDomains []*MagicLinkDomain `koanf:"EmailDomains"`
+}
+
+// email domain allowed to use magic links, and domains its users can access
+type MagicLinkDomain struct {
+	EmailDomain    string   `koanf:"EmailDomain"`
+	AllowedDomains []string `koanf:"AllowedDomains"`
+}
+
+// ids of magic links already used, until they expire
+type UsedTokens struct {
+	mu  sync.Mutex
+	ids map[string]time.Time
+}
+
+var usedMagicLinks = &UsedTokens{}
+
+// validate magic link settings, and set default values if init is true
+func (m *MagicLink) Valid(init bool) error {
+	if m.Expire < 1 {
+		if !init {
+			return errors.New("magiclink: Expire must be positive")
+		}
+		m.Expire = 15
+		log.Info("config: setting default value", zap.Duration("MagicLink.Expire", m.Expire))
+	}
+	if len(m.EmailDomains) == 0 {
+		return errors.New("magiclink: missing EmailDomains")
+	}
+	for _, d := range m.EmailDomains {
+		if d == nil || d.EmailDomain == "" || strings.Contains(d.EmailDomain, "@") {
+			return errors.New("magiclink: bad EmailDomain, expected a domain like example.com")
+		}
+		if len(d.AllowedDomains) == 0 {
+			return errors.New("magiclink: missing AllowedDomains for " + d.EmailDomain)
+		}
+		d.EmailDomain = strings.ToLower(d.EmailDomain)
+	}
+	return nil
+}
+
+// return settings of email domain, nil if not allowed
+func (m *MagicLink) getDomain(email string) *MagicLinkDomain {
+	i := strings.LastIndex(email, "@")
+	if i < 1 {
+		return nil
+	}
+	for _, d := range m.EmailDomains {
+		if strings.EqualFold(email[i+1:], d.EmailDomain) {
+			return d
+		}
+	}
+	return nil
+}
+
+// magic link users have no password, their username is their email
+// they are known as long as their email domain is allowed, to refresh their cookie
+func (m *MagicLink) GetUser(username string) *User {
+	d := m.getDomain(username)
+	if d == nil {
+		return nil
+	}
+	return &User{Username: username, Email: username, AllowedDomains: d.AllowedDomains}
+}
+
+// mark id as used until exp, return false if it was already used
+func (u *UsedTokens) Use(id string, exp time.Time) bool {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	if u.ids == nil {
+		u.ids = map[string]time.Time{}
+	}
+	for i, e := range u.ids {
+		if time.Now().After(e) {
+			delete(u.ids, i)
+		}
+	}
+	if _, ok := u.ids[id]; ok {
+		return false
+	}
+	u.ids[id] = exp
+	return true
+}
+
+// send a sign-in link to email, if its domain is allowed
+// to avoid disclosing allowed domains, nothing tells the caller if an email was sent
+func RequestMagicLink(email, ip string) error {
+	addr, err := mail.ParseAddress(strings.TrimSpace(email))
+	if err != nil {
+		log.Info("magiclink: bad email", zap.String("email", email))
+		return nil
+	}
+	email = strings.ToLower(addr.Address)
+	window := time.Hour
+	if !mailRateLimiter.Allow("ip:"+ip, configuration.MailRateLimit, window) ||
+		!mailRateLimiter.Allow("user:"+email, configuration.MailRateLimit, window) {
+		log.Error("magiclink: too many requests", zap.String("ip", ip), zap.String("email", email))
+		return ErrTooManyRequests
+	}
+	if configuration.MagicLink.getDomain(email) == nil {
+		log.Info("magiclink: email domain not allowed", zap.String("email", email))
+		return nil
+	}
+
+	token := createMailToken("login", &MailClaims{RegisteredClaims: jwt.RegisteredClaims{Subject: email}}, configuration.MagicLink.Expire)
+	link := configuration.PublicUrl + magicLinkPath + "?token=" + token
+	body := "Hello,\n\n" +
+		"Use the following link within " + strconv.Itoa(int(configuration.MagicLink.Expire)) + " minutes to sign in:\n\n" +
+		link + "\n\n" +
+		"If you didn't request it, you can ignore this email.\n"
+	auditLog.Add(email, "magic link requested", "", ip)
+	// send in background, so response time doesn't disclose allowed domains
+	go func() {
+		if err := configuration.Smtp.Send(email, "Sign in link", body); err != nil {
+			log.Error("magiclink: error sending email", zap.String("email", email), zap.Error(err))
+		}
+	}()
+	return nil
+}
+
+// return user of a valid, unused magic link token, nil otherwise
+func GetUserFromMagicLink(token string) *User {
+	cl := parseMailToken("login", token)
+	if cl == nil {
+		return nil
+	}
+	// accounts named like an email must log in with their password
+	u := GetUser(cl.Subject)
+	if u == nil || u.store != UserStore(configuration.MagicLink) {
+		log.Error("magiclink: user not allowed", zap.String("email", cl.Subject))
+		return nil
+	}
+	if err := u.Active(); err != nil {
+		log.Error("magiclink: user inactive", zap.String("email", cl.Subject), zap.Error(err))
+		return nil
+	}
+	if !usedMagicLinks.Use(cl.ID, cl.ExpiresAt.Time) {
+		log.Error("magiclink: link already used", zap.String("email", cl.Subject))
+		return nil
+	}
+	return u
+}
+
+// passwordless login page : ask for a link, then sign in from the link
+func MagicLinkHandler(w http.ResponseWriter, r *http.Request) {
+
+	if configuration.Smtp == nil || configuration.MagicLink == nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	ctx := &Context{
+		CsrfToken:      csrf.Token(r),
+		Ip:             GetIp(r),
+		Url:            GetHost(r),
+		Language:       GetAcceptLanguage(r),
+		Nonce:          CspNonce(r),
+		State:          "magic",
+		HttpReturnCode: http.StatusOK,
+	}
+	log.Sugar().Debug("server: magic link requested", zap.String("ip", ctx.Ip), "request", RedactRequest(r))
+
+	token := r.FormValue("token")
+	switch {
+	// ask for a link
+	case r.Method == http.MethodPost && token == "":
+		if err := RequestMagicLink(r.PostFormValue("email"), ctx.Ip); err != nil {
+			ctx.HttpReturnCode = http.StatusTooManyRequests
+			ctx.ErrorMessage = err.Error()
+			break
+		}
+		ctx.State = "info"
+		ctx.Message = "If this email is allowed, a sign-in link has been sent to it"
+	// sign in, only on POST so that links opened by mail scanners are not consumed
+	case r.Method == http.MethodPost:
+		ctx.User = GetUserFromMagicLink(token)
+		if ctx.User == nil {
+			time.Sleep(500 * time.Millisecond)
+			ctx.HttpReturnCode = http.StatusBadRequest
+			ctx.ErrorMessage = ErrInvalidLink.Error()
+			break
+		}
+		auditLog.Add(ctx.User.Username, "login", "magic link", ctx.Ip)
+		ctx.GeneratedCookie = CreateUserJwtCookie(ctx.User, ctx.Ip)
+		ctx.State = "info"
+		ctx.Message = "You are signed in as " + ctx.User.Username
+	// link clicked, ask confirmation
+	case token != "":
+		ctx.Token = token
+	}
+	log.Info("Loading Template", zap.Int("status", ctx.HttpReturnCode), zap.Error(LoadTemplate(&w, ctx)))
+}
@@ -0,0 +1,192 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMagicLinkValid(t *testing.T) {
+	testCases := []struct {
+		name          string
+		magicLink     *MagicLink
+		init          bool
+		expectedError string
+	}{
+		{"NOMINAL", &MagicLink{Expire: 10, EmailDomains: []*MagicLinkDomain{{EmailDomain: "Example.com", AllowedDomains: []string{"url.net"}}}}, false, ""},
+		{"DEFAULT_EXPIRE", &MagicLink{EmailDomains: []*MagicLinkDomain{{EmailDomain: "example.com", AllowedDomains: []string{"url.net"}}}}, true, ""},
+		{"BAD_EXPIRE", &MagicLink{EmailDomains: []*MagicLinkDomain{{EmailDomain: "example.com", AllowedDomains: []string{"url.net"}}}}, false, "Expire must be positive"},
+		{"NO_DOMAINS", &MagicLink{Expire: 10}, false, "missing EmailDomains"},
+		{"BAD_DOMAIN", &MagicLink{Expire: 10, EmailDomains: []*MagicLinkDomain{{EmailDomain: "jean@example.com", AllowedDomains: []string{"url.net"}}}}, false, "bad EmailDomain"},
+		{"NO_ALLOWED_DOMAINS", &MagicLink{Expire: 10, EmailDomains: []*MagicLinkDomain{{EmailDomain: "example.com"}}}, false, "missing AllowedDomains"},
+	}
+	for _, tc := range testCases {
+		// shadow the test case to avoid modifying the test case
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			err := tc.magicLink.Valid(tc.init)
+			if tc.expectedError != "" {
+				assert.ErrorContains(t, err, tc.expectedError)
+				return
+			}
+			assert.NoError(t, err)
+			assert.GreaterOrEqual(t, tc.magicLink.Expire, time.Duration(1))
+			assert.Equal(t, "example.com", tc.magicLink.EmailDomains[0].EmailDomain)
+		})
+	}
+}
+
+func TestMagicLinkGetUser(t *testing.T) {
+	m := &MagicLink{EmailDomains: []*MagicLinkDomain{{EmailDomain: "example.com", AllowedDomains: []string{"url.net"}}}}
+
+	if u := m.GetUser("alice@EXAMPLE.com"); assert.NotNil(t, u) {
+		assert.Equal(t, "alice@EXAMPLE.com", u.Username)
+		assert.Equal(t, "alice@EXAMPLE.com", u.Email)
+		assert.Equal(t, []string{"url.net"}, u.AllowedDomains)
+		assert.Empty(t, u.Password)
+	}
+	assert.Nil(t, m.GetUser("alice@other.com"))
+	assert.Nil(t, m.GetUser("alice@sub.example.com"))
+	assert.Nil(t, m.GetUser("@example.com"))
+	assert.Nil(t, m.GetUser("jean"))
+}
+
+func TestUsedTokens(t *testing.T) {
+	u := &UsedTokens{}
+	assert.True(t, u.Use("a", time.Now().Add(time.Hour)))
+	assert.False(t, u.Use("a", time.Now().Add(time.Hour)))
+	assert.True(t, u.Use("b", time.Now().Add(-time.Second)))
+	// expired ids are forgotten
+	assert.True(t, u.Use("b", time.Now().Add(time.Hour)))
+}
+
+// configure smtp and magic links, restored at the end of test
+func setupTestMagicLink(t *testing.T) chan string {
+	smtpBackup, urlBackup, magicBackup, limiterBackup := configuration.Smtp, configuration.PublicUrl, configuration.MagicLink, mailRateLimiter
+	t.Cleanup(func() {
+		configuration.Smtp, configuration.PublicUrl, configuration.MagicLink, mailRateLimiter = smtpBackup, urlBackup, magicBackup, limiterBackup
+	})
+	addr, msgs := newTestSmtpServer(t)
+	configuration.Smtp = &Smtp{Address: addr, From: "gfa@example.com"}
+	configuration.PublicUrl = "https://auth.example.com"
+	configuration.MagicLink = &MagicLink{Expire: 15, EmailDomains: []*MagicLinkDomain{{EmailDomain: "example.com", AllowedDomains: []string{"url.net"}}}}
+	mailRateLimiter = &RateLimiter{}
+	return msgs
+}
+
+// extract token of sign-in link sent by email
+func getMagicLinkToken(t *testing.T, mail string) string {
+	m := regexp.MustCompile(`https://auth\.example\.com/magic\?token=(\S+)`).FindStringSubmatch(mail)
+	if m == nil {
+		t.Fatal("no sign-in link in email")
+	}
+	return m[1]
+}
+
+func TestRequestMagicLink(t *testing.T) {
+	msgs := setupTestMagicLink(t)
+
+	assert.NoError(t, RequestMagicLink("bad", "1.2.3.4"))
+	assert.NoError(t, RequestMagicLink("alice@other.com", "1.2.3.4"))
+	assert.Empty(t, msgs)
+
+	assert.NoError(t, RequestMagicLink("Alice <Alice@Example.com>", "1.2.3.4"))
+	m := receiveTestMail(t, msgs)
+	assert.Contains(t, m, "To: <alice@example.com>")
+	if u := GetUserFromMagicLink(getMagicLinkToken(t, m)); assert.NotNil(t, u) {
+		assert.Equal(t, "alice@example.com", u.Username)
+	}
+
+	// rate limited
+	limitBackup := configuration.MailRateLimit
+	defer func() { configuration.MailRateLimit = limitBackup }()
+	configuration.MailRateLimit = 1
+	assert.ErrorIs(t, RequestMagicLink("alice@example.com", "5.6.7.8"), ErrTooManyRequests)
+}
+
+func TestGetUserFromMagicLink(t *testing.T) {
+	setupTestMagicLink(t)
+
+	assert.Nil(t, GetUserFromMagicLink("bad"))
+	// reset tokens are not sign-in tokens
+	assert.Nil(t, GetUserFromMagicLink(CreateResetToken(&User{Username: "alice@example.com"})))
+
+	// email domain no longer allowed
+	cl := &MailClaims{}
+	cl.Subject = "alice@other.com"
+	assert.Nil(t, GetUserFromMagicLink(createMailToken("login", cl, 15)))
+
+	// magic link users have no password
+	assert.NotNil(t, GetUser("alice@example.com"))
+	assert.Nil(t, GetValidUser("alice@example.com", "", "url.net"))
+
+	// accounts can't be used
+	configuration.MagicLink.EmailDomains[0].EmailDomain = "allowed.com"
+	configuration.Users["jean@allowed.com"] = &User{Password: GetHash("pwd"), AllowedDomains: []string{".*"}}
+	defer delete(configuration.Users, "jean@allowed.com")
+	cl.Subject = "jean@allowed.com"
+	assert.Nil(t, GetUserFromMagicLink(createMailToken("login", cl, 15)))
+}
+
+func TestMagicLinkHandler(t *testing.T) {
+	msgs := setupTestMagicLink(t)
+	dbBackup := configuration.db
+	defer func() { configuration.db = dbBackup }()
+	s := newTestSqlStore(t)
+	configuration.db = s
+
+	request := func(method, target string, form url.Values) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(method, target, strings.NewReader(form.Encode()))
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		r.Header.Set("X-Real-IP", "1.2.3.4")
+		w := httptest.NewRecorder()
+		MagicLinkHandler(w, r)
+		return w
+	}
+
+	w := request("GET", "/magic", nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), "Send sign-in link")
+
+	w = request("POST", "/magic", url.Values{"email": {"alice@example.com"}})
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), "If this email is allowed")
+	token := getMagicLinkToken(t, receiveTestMail(t, msgs))
+
+	// opening the link doesn't sign in
+	w = request("GET", "/magic?token="+token, nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), token)
+	assert.Empty(t, w.Result().Cookies())
+
+	w = request("POST", "/magic", url.Values{"token": {token}})
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), "You are signed in as alice@example.com")
+	if assert.Len(t, w.Result().Cookies(), 1) {
+		cl := GetValidJwtClaims(w.Result().Cookies()[0], "1.2.3.4", "url.net")
+		if assert.NotNil(t, cl) {
+			assert.Equal(t, "alice@example.com", cl.Subject)
+		}
+	}
+	// session is registered, so it can be revoked
+	sessions, err := s.ListSessions("alice@example.com")
+	assert.NoError(t, err)
+	assert.Len(t, sessions, 1)
+	assert.Equal(t, "login", auditLog.Recent()[0].Action)
+
+	// single use
+	w = request("POST", "/magic", url.Values{"token": {token}})
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Empty(t, w.Result().Cookies())
+
+	// disabled without MagicLink
+	configuration.MagicLink = nil
+	assert.Equal(t, http.StatusNotFound, request("GET", "/magic", nil).Code)
+}
@@ -15,8 +15,11 @@ var log *zap.Logger
 
 // initialize global configuration and logging
 func LoadConfigurationAndLogger() error {
+	return loadConfigurationAndLogger(flag.NewFlagSet("config", flag.ExitOnError))
+}
 
-	// init logger
+// initialize global logger writing to w
+func initLogger(w zapcore.WriteSyncer) zap.AtomicLevel {
 	atom := zap.NewAtomicLevel()
 	encoderCfg := zapcore.EncoderConfig{
 		TimeKey:        "T",
@@ -35,15 +38,21 @@ func LoadConfigurationAndLogger() error {
 
 	log = zap.New(zapcore.NewCore(
 		zapcore.NewConsoleEncoder(encoderCfg),
-		zapcore.Lock(os.Stdout),
+		zapcore.Lock(w),
 		atom,
 	))
+	return atom
+}
 
+// initialize global configuration from command line flags f, and logging
+func loadConfigurationAndLogger(f *flag.FlagSet) error {
+
+	// init logger
+	atom := initLogger(os.Stdout)
 	log.Info("main: logger initialized")
 
 	// init configuration
 	k := koanf.New(".")
-	f := flag.NewFlagSet("config", flag.ExitOnError)
 	if configuration == nil {
 		configuration = &Config{}
 	}
@@ -65,10 +74,8 @@ func LoadConfigurationAndLogger() error {
 }
 
 func main() {
-	defer log.Sync()
-	// Load server
-	if err := LoadConfigurationAndLogger(); err != nil {
-		log.Fatal("main: error loading configuration", zap.Error(err))
+	if err := RunCommand(os.Args[1:], os.Stdin, os.Stdout); err != nil {
+		fmt.Fprintln(os.Stderr, "Error: "+err.Error())
+		os.Exit(1)
 	}
-	log.Fatal("main: error loading server", zap.Error(LoadServer()))
 }
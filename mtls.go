@@ -0,0 +1,226 @@
+package main
+
+import (
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+
+	"go.uber.org/zap"
+)
+
+type ClientCert struct {
+	CaFile               string `koanf:"CaFile"`
+	Identity             string `koanf:"Identity"`
+	TrustForwardedHeader bool   `koanf:"TrustForwardedHeader"`
+	// addresses (ip or cidr) of proxies allowed to forward certificates
+	TrustedProxies []string `koanf:"TrustedProxies"`
+	// pool of trusted CA, loaded from CaFile
+	pool *x509.CertPool
+	// parsed TrustedProxies
+	proxies []*net.IPNet
+}
+
+// header set by Traefik when passTLSClientCert is enabled
+const clientCertHeader = "X-Forwarded-Tls-Client-Cert"
+
+const (
+	ClientCertIdentityCn    = "cn"
+	ClientCertIdentityEmail = "email"
+	ClientCertIdentityDns   = "dns"
+)
+
+// validate client certificate configuration and load CA bundle
+func (cc *ClientCert) Valid() error {
+	if cc.CaFile == "" {
+		return errors.New("mtls: missing CaFile")
+	}
+	switch cc.Identity {
+	case "":
+		cc.Identity = ClientCertIdentityCn
+	case ClientCertIdentityCn, ClientCertIdentityEmail, ClientCertIdentityDns:
+	default:
+		return errors.New("mtls: bad Identity '" + cc.Identity + "' (cn, email or dns)")
+	}
+	// anyone can send a certificate in the header, as certificates are public
+	if cc.TrustForwardedHeader && len(cc.TrustedProxies) == 0 {
+		return errors.New("mtls: TrustedProxies is required with TrustForwardedHeader")
+	}
+	cc.proxies = nil
+	for _, p := range cc.TrustedProxies {
+		n, err := ParseIpNet(p)
+		if err != nil {
+			return errors.New("mtls: bad TrustedProxies\n\t-> " + err.Error())
+		}
+		cc.proxies = append(cc.proxies, n)
+	}
+	b, err := os.ReadFile(cc.CaFile)
+	if err != nil {
+		return errors.New("mtls: error reading CaFile\n\t-> " + err.Error())
+	}
+	cc.pool = x509.NewCertPool()
+	if !cc.pool.AppendCertsFromPEM(b) {
+		return errors.New("mtls: no certificate found in CaFile")
+	}
+	return nil
+}
+
+// return verified client certificate from tls connection or forwarded header, nil if none
+func (cc *ClientCert) GetCertificate(r *http.Request) *x509.Certificate {
+	// certificate already verified during handshake
+	if r.TLS != nil && len(r.TLS.VerifiedChains) > 0 {
+		return r.TLS.VerifiedChains[0][0]
+	}
+	if !cc.TrustForwardedHeader || r.Header.Get(clientCertHeader) == "" {
+		return nil
+	}
+	if !cc.TrustedProxy(r.RemoteAddr) {
+		log.Error("mtls: forwarded certificate from untrusted address", zap.String("address", r.RemoteAddr))
+		return nil
+	}
+
+	certs, err := ParseForwardedCertificates(r.Header.Get(clientCertHeader))
+	if err != nil {
+		log.Error("mtls: bad forwarded certificate", zap.Error(err))
+		return nil
+	}
+	intermediates := x509.NewCertPool()
+	for _, c := range certs[1:] {
+		intermediates.AddCert(c)
+	}
+	if _, err := certs[0].Verify(x509.VerifyOptions{
+		Roots:         cc.pool,
+		Intermediates: intermediates,
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}); err != nil {
+		log.Error("mtls: forwarded certificate not trusted", zap.String("subject", certs[0].Subject.String()), zap.Error(err))
+		return nil
+	}
+	return certs[0]
+}
+
+// return true if remote address (ip or ip:port) is one of TrustedProxies
+func (cc *ClientCert) TrustedProxy(addr string) bool {
+	if host, _, err := net.SplitHostPort(addr); err == nil {
+		addr = host
+	}
+	ip := net.ParseIP(addr)
+	if ip == nil {
+		return false
+	}
+	for _, n := range cc.proxies {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// parse ip network given as cidr ("10.0.0.0/8") or single ip ("10.0.0.1")
+func ParseIpNet(s string) (*net.IPNet, error) {
+	if !strings.Contains(s, "/") {
+		ip := net.ParseIP(s)
+		if ip == nil {
+			return nil, errors.New("mtls: bad address '" + s + "'")
+		}
+		bits := 8 * net.IPv4len
+		if ip.To4() == nil {
+			bits = 8 * net.IPv6len
+		}
+		return &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)}, nil
+	}
+	_, n, err := net.ParseCIDR(s)
+	if err != nil {
+		return nil, errors.New("mtls: bad address '" + s + "'\n\t-> " + err.Error())
+	}
+	return n, nil
+}
+
+// return candidate usernames of certificate, according to configured identity
+func (cc *ClientCert) GetUsernames(c *x509.Certificate) []string {
+	if c == nil {
+		return nil
+	}
+	switch cc.Identity {
+	case ClientCertIdentityEmail:
+		return c.EmailAddresses
+	case ClientCertIdentityDns:
+		return c.DNSNames
+	default:
+		if c.Subject.CommonName == "" {
+			return nil
+		}
+		return []string{c.Subject.CommonName}
+	}
+}
+
+// parse certificates forwarded by proxy (escaped pem, comma separated, with or without pem headers)
+func ParseForwardedCertificates(header string) ([]*x509.Certificate, error) {
+	unescaped, err := url.QueryUnescape(header)
+	if err != nil {
+		return nil, err
+	}
+
+	var certs []*x509.Certificate
+	// full pem blocks
+	if strings.Contains(unescaped, "-----BEGIN") {
+		rest := []byte(unescaped)
+		for {
+			var block *pem.Block
+			block, rest = pem.Decode(rest)
+			if block == nil {
+				break
+			}
+			c, err := x509.ParseCertificate(block.Bytes)
+			if err != nil {
+				return nil, err
+			}
+			certs = append(certs, c)
+		}
+	} else {
+		// base64 der, without pem headers
+		for _, part := range strings.Split(unescaped, ",") {
+			der, err := base64.StdEncoding.DecodeString(strings.Join(strings.Fields(part), ""))
+			if err != nil {
+				return nil, err
+			}
+			c, err := x509.ParseCertificate(der)
+			if err != nil {
+				return nil, err
+			}
+			certs = append(certs, c)
+		}
+	}
+	if len(certs) == 0 {
+		return nil, errors.New("mtls: no certificate found")
+	}
+	return certs, nil
+}
+
+// return user mapped to the client certificate of the request, nil if none or not allowed
+func GetValidUserFromClientCert(r *http.Request, url string) *User {
+	cc := configuration.ClientCert
+	if cc == nil {
+		return nil
+	}
+	c := cc.GetCertificate(r)
+	if c == nil {
+		return nil
+	}
+	for _, username := range cc.GetUsernames(c) {
+		if u := GetUser(username); u != nil {
+			if !u.Allowed(url) {
+				return nil
+			}
+			log.Info("mtls: user authenticated", zap.String("username", u.Username), zap.String("subject", c.Subject.String()))
+			return u
+		}
+	}
+	log.Error("mtls: no user mapped to certificate", zap.String("subject", c.Subject.String()))
+	return nil
+}
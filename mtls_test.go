@@ -0,0 +1,258 @@
+package main
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/pem"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// create a CA file in dir, and return it with a client certificate signed by this CA
+func createTestClientCert(t *testing.T, dir string, cn string, emails []string) (caFile string, client *x509.Certificate) {
+	caKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	caTemplate := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "GFA Test CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+	}
+	caDer, err := x509.CreateCertificate(rand.Reader, caTemplate, caTemplate, &caKey.PublicKey, caKey)
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	ca, _ := x509.ParseCertificate(caDer)
+
+	clientKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	clientTemplate := &x509.Certificate{
+		SerialNumber:   big.NewInt(2),
+		Subject:        pkix.Name{CommonName: cn},
+		EmailAddresses: emails,
+		NotBefore:      time.Now().Add(-time.Hour),
+		NotAfter:       time.Now().Add(time.Hour),
+		KeyUsage:       x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:    []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	clientDer, err := x509.CreateCertificate(rand.Reader, clientTemplate, ca, &clientKey.PublicKey, caKey)
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	client, _ = x509.ParseCertificate(clientDer)
+
+	caFile = filepath.Join(dir, "ca.crt")
+	os.WriteFile(caFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: caDer}), 0600)
+	return caFile, client
+}
+
+// encode certificate as traefik does
+func forwardedCert(c *x509.Certificate) string {
+	return url.QueryEscape(base64.StdEncoding.EncodeToString(c.Raw))
+}
+
+func TestClientCertValid(t *testing.T) {
+	dir := t.TempDir()
+	caFile, _ := createTestClientCert(t, dir, "jean", nil)
+	emptyFile := filepath.Join(dir, "empty.crt")
+	os.WriteFile(emptyFile, []byte("nothing"), 0600)
+
+	testCases := []struct {
+		name                  string
+		clientCert            *ClientCert
+		expectedErrorContains string
+	}{
+		{"NOMINAL", &ClientCert{CaFile: caFile}, ""},
+		{"EMAIL", &ClientCert{CaFile: caFile, Identity: "email"}, ""},
+		{"NO_CA", &ClientCert{}, "missing CaFile"},
+		{"BAD_IDENTITY", &ClientCert{CaFile: caFile, Identity: "uid"}, "bad Identity"},
+		{"MISSING_CA", &ClientCert{CaFile: "nope"}, "error reading CaFile"},
+		{"EMPTY_CA", &ClientCert{CaFile: emptyFile}, "no certificate"},
+		{"PROXIES", &ClientCert{CaFile: caFile, TrustForwardedHeader: true, TrustedProxies: []string{"10.0.0.0/8", "192.0.2.1", "::1"}}, ""},
+		{"NO_PROXIES", &ClientCert{CaFile: caFile, TrustForwardedHeader: true}, "TrustedProxies is required"},
+		{"BAD_PROXIES", &ClientCert{CaFile: caFile, TrustForwardedHeader: true, TrustedProxies: []string{"10.0.0.0/33"}}, "bad TrustedProxies"},
+	}
+	for _, tc := range testCases {
+		// shadow the test case to avoid modifying the test case
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			err := tc.clientCert.Valid()
+			if tc.expectedErrorContains == "" {
+				assert.NoError(t, err)
+				assert.NotEmpty(t, tc.clientCert.Identity)
+				assert.NotNil(t, tc.clientCert.pool)
+			} else {
+				assert.ErrorContains(t, err, tc.expectedErrorContains)
+			}
+		})
+	}
+}
+
+func TestParseForwardedCertificates(t *testing.T) {
+	_, c := createTestClientCert(t, t.TempDir(), "jean", nil)
+	pemCert := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: c.Raw})
+
+	testCases := []struct {
+		name          string
+		header        string
+		expectedCount int
+	}{
+		{"BASE64", forwardedCert(c), 1},
+		{"BASE64_CHAIN", forwardedCert(c) + "," + forwardedCert(c), 2},
+		{"PEM", url.QueryEscape(string(pemCert)), 1},
+		{"PEM_CHAIN", url.QueryEscape(string(bytes.Repeat(pemCert, 2))), 2},
+		{"BAD_ESCAPE", "%zz", 0},
+		{"BAD_BASE64", "!!!", 0},
+		{"BAD_DER", base64.StdEncoding.EncodeToString([]byte("nope")), 0},
+		{"EMPTY_PEM", "-----BEGIN nothing", 0},
+	}
+	for _, tc := range testCases {
+		// shadow the test case to avoid modifying the test case
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			certs, err := ParseForwardedCertificates(tc.header)
+			assert.Len(t, certs, tc.expectedCount)
+			if tc.expectedCount == 0 {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+				assert.Equal(t, "jean", certs[0].Subject.CommonName)
+			}
+		})
+	}
+}
+
+func TestClientCertGetCertificate(t *testing.T) {
+	dir := t.TempDir()
+	caFile, c := createTestClientCert(t, dir, "jean", nil)
+	_, untrusted := createTestClientCert(t, t.TempDir(), "jean", nil)
+	cc := &ClientCert{CaFile: caFile, TrustForwardedHeader: true, TrustedProxies: []string{"192.0.2.0/24"}}
+	if !assert.NoError(t, cc.Valid()) {
+		t.FailNow()
+	}
+
+	// verified during tls handshake
+	req := httptest.NewRequest("GET", "/verify", nil)
+	req.TLS = &tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{c}}}
+	assert.Equal(t, c, cc.GetCertificate(req))
+
+	// forwarded by proxy
+	req = httptest.NewRequest("GET", "/verify", nil)
+	assert.Nil(t, cc.GetCertificate(req))
+	req.Header.Set(clientCertHeader, forwardedCert(c))
+	assert.Equal(t, c.Raw, cc.GetCertificate(req).Raw)
+
+	// forwarded but not trusted
+	req.Header.Set(clientCertHeader, forwardedCert(untrusted))
+	assert.Nil(t, cc.GetCertificate(req))
+	req.Header.Set(clientCertHeader, "bad")
+	assert.Nil(t, cc.GetCertificate(req))
+
+	// header ignored from other addresses, as anyone can send a certificate
+	req.Header.Set(clientCertHeader, forwardedCert(c))
+	req.RemoteAddr = "203.0.113.1:1234"
+	assert.Nil(t, cc.GetCertificate(req))
+	req.RemoteAddr = "192.0.2.1:1234"
+	assert.Equal(t, c.Raw, cc.GetCertificate(req).Raw)
+
+	// header ignored
+	cc.TrustForwardedHeader = false
+	req.Header.Set(clientCertHeader, forwardedCert(c))
+	assert.Nil(t, cc.GetCertificate(req))
+}
+
+func TestClientCertTrustedProxy(t *testing.T) {
+	cc := &ClientCert{CaFile: "unused", TrustForwardedHeader: true, TrustedProxies: []string{"10.0.0.0/8", "192.0.2.1", "fd00::/8"}}
+	for _, p := range cc.TrustedProxies {
+		n, err := ParseIpNet(p)
+		if assert.NoError(t, err) {
+			cc.proxies = append(cc.proxies, n)
+		}
+	}
+	assert.True(t, cc.TrustedProxy("10.1.2.3:443"))
+	assert.True(t, cc.TrustedProxy("192.0.2.1"))
+	assert.True(t, cc.TrustedProxy("[fd00::1]:443"))
+	assert.False(t, cc.TrustedProxy("192.0.2.2:443"))
+	assert.False(t, cc.TrustedProxy("bad"))
+	assert.False(t, (&ClientCert{}).TrustedProxy("10.1.2.3:443"))
+
+	_, err := ParseIpNet("nope")
+	assert.ErrorContains(t, err, "bad address")
+}
+
+func TestClientCertGetUsernames(t *testing.T) {
+	_, c := createTestClientCert(t, t.TempDir(), "jean", []string{"jean@url.net"})
+	c.DNSNames = []string{"laptop.url.net"}
+
+	assert.Equal(t, []string{"jean"}, (&ClientCert{Identity: "cn"}).GetUsernames(c))
+	assert.Equal(t, []string{"jean@url.net"}, (&ClientCert{Identity: "email"}).GetUsernames(c))
+	assert.Equal(t, []string{"laptop.url.net"}, (&ClientCert{Identity: "dns"}).GetUsernames(c))
+	assert.Nil(t, (&ClientCert{Identity: "cn"}).GetUsernames(nil))
+	c.Subject.CommonName = ""
+	assert.Nil(t, (&ClientCert{Identity: "cn"}).GetUsernames(c))
+}
+
+func TestGetValidUserFromClientCert(t *testing.T) {
+	dir := t.TempDir()
+	caFile, jean := createTestClientCert(t, dir, "jean", nil)
+	cc := &ClientCert{CaFile: caFile, TrustForwardedHeader: true, TrustedProxies: []string{"192.0.2.0/24"}}
+	if !assert.NoError(t, cc.Valid()) {
+		t.FailNow()
+	}
+
+	backup := configuration.ClientCert
+	defer func() { configuration.ClientCert = backup }()
+
+	req := httptest.NewRequest("GET", "/verify", nil)
+	req.Header.Set(clientCertHeader, forwardedCert(jean))
+
+	// disabled
+	configuration.ClientCert = nil
+	assert.Nil(t, GetValidUserFromClientCert(req, "url.net"))
+
+	configuration.ClientCert = cc
+	u := GetValidUserFromClientCert(req, "url.net")
+	if assert.NotNil(t, u) {
+		assert.Equal(t, "jean", u.Username)
+	}
+	assert.Nil(t, GetValidUserFromClientCert(req, "forbidden.com"))
+	assert.Nil(t, GetValidUserFromClientCert(httptest.NewRequest("GET", "/verify", nil), "url.net"))
+
+	// unknown user
+	caFile, unknown := createTestClientCert(t, dir, "unknown", nil)
+	cc = &ClientCert{CaFile: caFile, TrustForwardedHeader: true, TrustedProxies: []string{"192.0.2.0/24"}}
+	cc.Valid()
+	configuration.ClientCert = cc
+	req.Header.Set(clientCertHeader, forwardedCert(unknown))
+	assert.Nil(t, GetValidUserFromClientCert(req, "url.net"))
+
+	// verify handler accept client certificate
+	caFile, jean = createTestClientCert(t, dir, "jean", nil)
+	cc = &ClientCert{CaFile: caFile, TrustForwardedHeader: true, TrustedProxies: []string{"192.0.2.0/24"}}
+	cc.Valid()
+	configuration.ClientCert = cc
+	req = httptest.NewRequest("GET", "/verify", nil)
+	req.Host = "url.net"
+	req.Header.Set(clientCertHeader, forwardedCert(jean))
+	w := httptest.NewRecorder()
+	http.HandlerFunc(VerifyHandler)(w, req)
+	assert.Equal(t, http.StatusOK, w.Result().StatusCode)
+	assert.Equal(t, "jean", w.Result().Header.Get("Remote-User"))
+}
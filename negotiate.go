@@ -0,0 +1,63 @@
+package main
+
+import (
+	"mime"
+	"net/http"
+	"strings"
+)
+
+// status returned to API clients instead of login pages
+type JsonStatus struct {
+	Status   int    `json:"status"`
+	State    string `json:"state"`
+	Reason   string `json:"reason"`
+	Username string `json:"username,omitempty"`
+	Error    string `json:"error,omitempty"`
+	Message  string `json:"message,omitempty"`
+	LoginUrl string `json:"loginUrl,omitempty"`
+}
+
+// return true if client asks for json rather than html (fetch and xhr of single-page apps)
+func WantsJson(r *http.Request) bool {
+	if r.Header.Get("X-Requested-With") != "" {
+		return true
+	}
+	// first of html or json in Accept wins, browsers list html first
+	for _, a := range strings.Split(r.Header.Get("Accept"), ",") {
+		mediaType, params, err := mime.ParseMediaType(strings.TrimSpace(a))
+		if err != nil || params["q"] == "0" {
+			continue
+		}
+		switch mediaType {
+		case "text/html":
+			return false
+		case "application/json":
+			return true
+		}
+	}
+	return false
+}
+
+// return status of the request, with messages translated in lang
+func (ctx *Context) ToJson(lang string) *JsonStatus {
+	s := &JsonStatus{
+		Status:   ctx.HttpReturnCode,
+		State:    ctx.State,
+		Reason:   ctx.Reason,
+		Username: ctx.GetUsername(),
+		Error:    Translate(lang, ctx.ErrorMessage),
+		Message:  Translate(lang, ctx.Message),
+	}
+	if ctx.State != "in" {
+		s.LoginUrl = ctx.LoginPageUrl()
+	}
+	return s
+}
+
+// page to send the user to for login : GFA home if public, the requested page otherwise
+func (ctx *Context) LoginPageUrl() string {
+	if configuration.PublicUrl != "" {
+		return configuration.PublicUrl + "/"
+	}
+	return ctx.ReturnUrl
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWantsJson(t *testing.T) {
+	testCases := []struct {
+		Name     string
+		Header   http.Header
+		Expected bool
+	}{
+		{"NONE", http.Header{}, false},
+		{"JSON", http.Header{"Accept": {"application/json"}}, true},
+		{"AXIOS", http.Header{"Accept": {"application/json, text/plain, */*"}}, true},
+		{"BROWSER", http.Header{"Accept": {"text/html,application/xhtml+xml,application/xml;q=0.9,*/*;q=0.8"}}, false},
+		{"HTML_FIRST", http.Header{"Accept": {"text/html, application/json"}}, false},
+		{"REFUSED", http.Header{"Accept": {"application/json;q=0, text/html"}}, false},
+		{"ANY", http.Header{"Accept": {"*/*"}}, false},
+		{"XHR", http.Header{"X-Requested-With": {"XMLHttpRequest"}}, true},
+	}
+	for _, tc := range testCases {
+		// shadow the test case to avoid modifying the test case
+		tc := tc
+		t.Run(tc.Name, func(t *testing.T) {
+			t.Parallel()
+			req := httptest.NewRequest("GET", "/", nil)
+			req.Header = tc.Header
+			assert.Equal(t, tc.Expected, WantsJson(req))
+		})
+	}
+}
+
+func TestToJson(t *testing.T) {
+	backup := configuration.PublicUrl
+	defer func() { configuration.PublicUrl = backup }()
+
+	ctx := &Context{HttpReturnCode: http.StatusUnauthorized, State: "out", Reason: "bad_credentials", ErrorMessage: ErrBadCredentials.Error(), ReturnUrl: "https://app.test_domain/"}
+	configuration.PublicUrl = ""
+	assert.Equal(t, &JsonStatus{Status: 401, State: "out", Reason: "bad_credentials", Error: "Identifiants incorrects", LoginUrl: "https://app.test_domain/"}, ctx.ToJson("fr"))
+	configuration.PublicUrl = "https://auth.test_domain"
+	assert.Equal(t, "https://auth.test_domain/", ctx.ToJson("en").LoginUrl)
+
+	// no login url once authenticated
+	ctx = &Context{HttpReturnCode: http.StatusOK, State: "in", Reason: "authenticated", User: &User{Username: "jean"}}
+	assert.Equal(t, &JsonStatus{Status: 200, State: "in", Reason: "authenticated", Username: "jean"}, ctx.ToJson("en"))
+}
+
+func TestJsonResponses(t *testing.T) {
+	testCases := []struct {
+		Name             string
+		Path             string
+		Cookie           *http.Cookie
+		ExpectedHttpCode int
+		ExpectedState    string
+		ExpectedReason   string
+	}{
+		{"HOME_NO_COOKIE", "/", nil, http.StatusUnauthorized, "out", "unauthenticated"},
+		{"HOME_ALTERED", "/", TestCookie["altered"], http.StatusForbidden, "out", "domain_not_allowed"},
+		{"HOME_VALID", "/", TestCookie["valid"], http.StatusOK, "in", "authenticated"},
+		{"VERIFY_NO_COOKIE", "/verify", nil, http.StatusForbidden, "out", "unauthenticated"},
+		{"VERIFY_ALTERED", "/verify", TestCookie["altered"], http.StatusForbidden, "out", "session_invalid"},
+	}
+	for _, tc := range testCases {
+		// shadow the test case to avoid modifying the test case
+		tc := tc
+		t.Run(tc.Name, func(t *testing.T) {
+			t.Parallel()
+			req := httptest.NewRequest("GET", tc.Path, nil)
+			req.Host = "url.net"
+			req.RemoteAddr = "1.2.3.4"
+			req.Header.Set("Accept", "application/json")
+			req.Header.Set("X-Original-URL", "https://url.net/page")
+			if tc.Cookie != nil {
+				req.AddCookie(tc.Cookie)
+			}
+			w := httptest.NewRecorder()
+			NewRouter(nil).ServeHTTP(w, req)
+			resp := w.Result()
+
+			assert.Equal(t, tc.ExpectedHttpCode, resp.StatusCode)
+			assert.Equal(t, "application/json", resp.Header.Get("Content-Type"))
+			status := &JsonStatus{}
+			if assert.NoError(t, json.NewDecoder(resp.Body).Decode(status)) {
+				assert.Equal(t, tc.ExpectedHttpCode, status.Status)
+				assert.Equal(t, tc.ExpectedState, status.State)
+				assert.Equal(t, tc.ExpectedReason, status.Reason)
+				if tc.ExpectedState == "out" {
+					assert.Equal(t, "https://url.net/page", status.LoginUrl)
+				} else {
+					assert.Empty(t, status.LoginUrl)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,234 @@
+package main
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"go.uber.org/zap"
+)
+
+// bcrypt ignores bytes after 72, longer passwords are refused
+const passwordMaxLength = 72
+
+// user facing errors of password change
+var (
+	ErrBadCurrentPassword = errors.New("Bad current password")
+	ErrPasswordMismatch   = errors.New("Passwords don't match")
+	ErrPasswordReadOnly   = errors.New("Password can't be changed for this account")
+)
+
+// rules new passwords must follow
+type PasswordPolicy struct {
+	MinLength uint `koanf:"MinLength"`
+	// number of character classes required : lowercase, uppercase, digits, others
+	MinClasses   uint     `koanf:"MinClasses"`
+	DenyList     []string `koanf:"DenyList"`
+	DenyListFile string   `koanf:"DenyListFile"`
+	// directory of SHA1 hash range files, named after the 5 first hex chars of hashes (HIBP format)
+	BreachedDir string `koanf:"BreachedDir"`
+	// lowercased DenyList and DenyListFile entries
+	denied map[string]struct{}
+}
+
+// password change form, sent via "Password-Form" header like login form
+type PasswordFormData struct {
+	Current string `schema:"current,required"`
+	New     string `schema:"new,required"`
+	Confirm string `schema:"confirm,required"`
+	Csrf    string `schema:"csrf"`
+}
+
+// validate policy, and set default values if init is true
+// unset MinLength always takes its default value
+func (p *PasswordPolicy) Valid(init bool) error {
+	if p.MinLength == 0 {
+		p.MinLength = 8
+		log.Info("config: setting default value", zap.Uint("PasswordPolicy.MinLength", p.MinLength))
+	}
+	if p.MinLength > passwordMaxLength {
+		if !init {
+			return errors.New("password: MinLength must be between 1 and " + strconv.Itoa(passwordMaxLength))
+		}
+		p.MinLength = 8
+		log.Info("config: setting default value", zap.Uint("PasswordPolicy.MinLength", p.MinLength))
+	}
+	if p.MinClasses > 4 {
+		return errors.New("password: MinClasses must be at most 4")
+	}
+	p.denied = map[string]struct{}{}
+	for _, d := range p.DenyList {
+		p.denied[strings.ToLower(d)] = struct{}{}
+	}
+	if p.DenyListFile != "" {
+		f, err := os.Open(p.DenyListFile)
+		if err != nil {
+			return errors.New("password: bad DenyListFile\n\t-> " + err.Error())
+		}
+		defer f.Close()
+		s := bufio.NewScanner(f)
+		for s.Scan() {
+			if d := strings.TrimSpace(s.Text()); d != "" {
+				p.denied[strings.ToLower(d)] = struct{}{}
+			}
+		}
+		if err := s.Err(); err != nil {
+			return errors.New("password: bad DenyListFile\n\t-> " + err.Error())
+		}
+	}
+	if p.BreachedDir != "" {
+		if fi, err := os.Stat(p.BreachedDir); err != nil || !fi.IsDir() {
+			return errors.New("password: BreachedDir must be a directory")
+		}
+	}
+	return nil
+}
+
+// return an user facing error if password doesn't follow policy
+func (p *PasswordPolicy) Check(username, password string) error {
+	switch {
+	case uint(utf8.RuneCountInString(password)) < p.MinLength:
+		return errors.New("Password must be at least " + strconv.Itoa(int(p.MinLength)) + " characters long")
+	case len(password) > passwordMaxLength:
+		return errors.New("Password must be at most " + strconv.Itoa(passwordMaxLength) + " bytes long")
+	case username != "" && strings.Contains(strings.ToLower(password), strings.ToLower(username)):
+		return errors.New("Password must not contain username")
+	case countCharClasses(password) < p.MinClasses:
+		return errors.New("Password must contain at least " + strconv.Itoa(int(p.MinClasses)) + " of : lowercase letters, uppercase letters, digits, symbols")
+	}
+	if _, ok := p.denied[strings.ToLower(password)]; ok {
+		return errors.New("Password is too common")
+	}
+	if p.Breached(password) {
+		return errors.New("Password appeared in a data breach, choose another one")
+	}
+	return nil
+}
+
+// number of character classes used by password
+func countCharClasses(password string) uint {
+	var lower, upper, digit, other uint
+	for _, r := range password {
+		switch {
+		case unicode.IsLower(r):
+			lower = 1
+		case unicode.IsUpper(r):
+			upper = 1
+		case unicode.IsDigit(r):
+			digit = 1
+		default:
+			other = 1
+		}
+	}
+	return lower + upper + digit + other
+}
+
+// look for password in BreachedDir, only the file of its hash prefix is read
+func (p *PasswordPolicy) Breached(password string) bool {
+	if p.BreachedDir == "" {
+		return false
+	}
+	sum := sha1.Sum([]byte(password))
+	h := strings.ToUpper(hex.EncodeToString(sum[:]))
+	prefix, suffix := h[:5], h[5:]
+
+	f, err := os.Open(filepath.Join(p.BreachedDir, prefix+".txt"))
+	if errors.Is(err, os.ErrNotExist) {
+		f, err = os.Open(filepath.Join(p.BreachedDir, prefix))
+	}
+	if err != nil {
+		// corpus may be partial
+		if !errors.Is(err, os.ErrNotExist) {
+			log.Error("password: error reading breached passwords", zap.Error(err))
+		}
+		return false
+	}
+	defer f.Close()
+	s := bufio.NewScanner(f)
+	for s.Scan() {
+		// lines are <hash suffix>:<count>, padding lines have a count of 0
+		sfx, count, _ := strings.Cut(strings.TrimSpace(s.Text()), ":")
+		if strings.EqualFold(sfx, suffix) {
+			return count != "0"
+		}
+	}
+	if err := s.Err(); err != nil {
+		log.Error("password: error reading breached passwords", zap.Error(err))
+	}
+	return false
+}
+
+// Extract PasswordFormData from request HEADER, nil if none
+func GetPasswordFormData(r *http.Request) *PasswordFormData {
+	h := r.Header.Get("Password-Form")
+	if h == "" {
+		return nil
+	}
+	values, _ := url.ParseQuery(h)
+	f := &PasswordFormData{}
+	if err := decoder.Decode(f, values); err != nil {
+		log.Error("formdata: error decoding password form", zap.Error(err))
+		return nil
+	}
+	return f
+}
+
+// change password of user after checking current one, and revoke user other sessions
+func ChangePassword(username string, f *PasswordFormData, url, sessionId string) error {
+	if GetValidUser(username, f.Current, url) == nil {
+		return ErrBadCurrentPassword
+	}
+	if f.New != f.Confirm {
+		return ErrPasswordMismatch
+	}
+	if err := configuration.PasswordPolicy.Check(username, f.New); err != nil {
+		return err
+	}
+
+	u := GetUser(username)
+	if u == nil {
+		return ErrBadCurrentPassword
+	}
+	return u.ChangePassword(f.New, sessionId)
+}
+
+// hash and save new password, and revoke user sessions except the given one (may be empty)
+func (u *User) ChangePassword(password, sessionId string) error {
+	if _, ok := u.store.(PasswordStore); !ok {
+		log.Error("user: password change on read-only store", zap.String("username", u.Username))
+		return ErrPasswordReadOnly
+	}
+	h, err := configuration.PasswordHash.Hash(password)
+	if err != nil {
+		log.Error("user: error hashing password", zap.String("username", u.Username), zap.Error(err))
+		return errors.New("Error changing password")
+	}
+	if err := u.SetPassword(h); err != nil {
+		log.Error("user: error saving password", zap.String("username", u.Username), zap.Error(err))
+		return errors.New("Error changing password")
+	}
+	if db, ok := u.store.(*SqlStore); ok && u.MustChangePassword {
+		if err := db.SetMustChangePassword(u.Username, false); err != nil {
+			log.Error("user: error clearing password change requirement", zap.String("username", u.Username), zap.Error(err))
+			return errors.New("Error changing password")
+		}
+		u.MustChangePassword = false
+	}
+
+	// other sessions may have been opened with the old password
+	if configuration.db != nil {
+		if err := configuration.db.RevokeUserSessions(u.Username, sessionId); err != nil {
+			log.Error("user: error revoking sessions", zap.String("username", u.Username), zap.Error(err))
+		}
+	}
+	return nil
+}
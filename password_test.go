@@ -0,0 +1,227 @@
+package main
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPasswordPolicyValid(t *testing.T) {
+	p := &PasswordPolicy{}
+	assert.NoError(t, p.Valid(false))
+	assert.Equal(t, uint(8), p.MinLength)
+	p.MinLength = 100
+	assert.ErrorContains(t, p.Valid(false), "MinLength")
+	assert.NoError(t, p.Valid(true))
+	assert.Equal(t, uint(8), p.MinLength)
+
+	p = &PasswordPolicy{MinLength: 8, MinClasses: 5}
+	assert.ErrorContains(t, p.Valid(false), "MinClasses")
+	p = &PasswordPolicy{MinLength: 8, DenyListFile: "missing"}
+	assert.ErrorContains(t, p.Valid(false), "bad DenyListFile")
+	p = &PasswordPolicy{MinLength: 8, BreachedDir: "password.go"}
+	assert.ErrorContains(t, p.Valid(false), "BreachedDir must be a directory")
+
+	denyList := filepath.Join(t.TempDir(), "denylist.txt")
+	assert.NoError(t, os.WriteFile(denyList, []byte("Password123\n\n  qwertyuiop  \n"), 0600))
+	p = &PasswordPolicy{MinLength: 8, DenyList: []string{"Azertyuiop"}, DenyListFile: denyList}
+	assert.NoError(t, p.Valid(false))
+	assert.Equal(t, map[string]struct{}{"azertyuiop": {}, "password123": {}, "qwertyuiop": {}}, p.denied)
+}
+
+// write a breached passwords corpus containing "hunter2hunter2", and a padding line for "correct horse"
+func newTestBreachedDir(t *testing.T) string {
+	dir := t.TempDir()
+	write := func(password, count, name string) {
+		sum := sha1.Sum([]byte(password))
+		h := strings.ToUpper(hex.EncodeToString(sum[:]))
+		content := "0000000000000000000000000000000000A:3\r\n" + strings.ToLower(h[5:]) + ":" + count + "\r\n"
+		assert.NoError(t, os.WriteFile(filepath.Join(dir, h[:5]+name), []byte(content), 0600))
+	}
+	write("hunter2hunter2", "42", ".txt")
+	write("correct horse", "0", "")
+	return dir
+}
+
+func TestPasswordPolicyBreached(t *testing.T) {
+	p := &PasswordPolicy{}
+	assert.False(t, p.Breached("hunter2hunter2"))
+
+	p.BreachedDir = newTestBreachedDir(t)
+	assert.True(t, p.Breached("hunter2hunter2"))
+	assert.False(t, p.Breached("correct horse"))
+	assert.False(t, p.Breached("not in corpus"))
+}
+
+func TestPasswordPolicyCheck(t *testing.T) {
+	p := &PasswordPolicy{MinLength: 8, MinClasses: 2, DenyList: []string{"Password123"}, BreachedDir: newTestBreachedDir(t)}
+	assert.NoError(t, p.Valid(false))
+	testCases := []struct {
+		name                  string
+		password              string
+		expectedErrorContains string
+	}{
+		{"NOMINAL", "correct horse", ""},
+		{"UNICODE", "Éééééééé", ""},
+		{"TOO_SHORT", "short", "at least 8"},
+		{"TOO_LONG", strings.Repeat("a", 73), "at most 72"},
+		{"USERNAME", "my-JeanJean!", "must not contain username"},
+		{"ONE_CLASS", "onlylowercase", "at least 2 of"},
+		{"DENIED", "PASSWORD123", "too common"},
+		{"BREACHED", "hunter2hunter2", "data breach"},
+	}
+	for _, tc := range testCases {
+		// shadow the test case to avoid modifying the test case
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			err := p.Check("jeanjean", tc.password)
+			if tc.expectedErrorContains == "" {
+				assert.NoError(t, err)
+				return
+			}
+			assert.ErrorContains(t, err, tc.expectedErrorContains)
+		})
+	}
+}
+
+func TestGetPasswordFormData(t *testing.T) {
+	r := httptest.NewRequest("POST", "/", nil)
+	assert.Nil(t, GetPasswordFormData(r))
+	r.Header.Set("Password-Form", "current=old&new=newpassword")
+	assert.Nil(t, GetPasswordFormData(r))
+	r.Header.Set("Password-Form", "current=old&new=newpassword&confirm=newpassword&csrf=token")
+	assert.Equal(t, &PasswordFormData{Current: "old", New: "newpassword", Confirm: "newpassword", Csrf: "token"}, GetPasswordFormData(r))
+}
+
+func TestChangePassword(t *testing.T) {
+	backup := configuration.db
+	defer func() { configuration.db = backup }()
+	s := newTestSqlStore(t)
+	configuration.db = s
+	hashBackup := configuration.PasswordHash
+	defer func() { configuration.PasswordHash = hashBackup }()
+	configuration.PasswordHash = PasswordHash{Algorithm: HashBcrypt, BcryptCost: 4}
+
+	assert.NoError(t, s.CreateUser(&User{Username: "bob", Password: GetHash("oldpassword"), AllowedDomains: []string{"url.net"}}))
+	assert.NoError(t, s.CreateSession("current", "bob", "1.2.3.4", time.Now().Add(time.Hour)))
+	assert.NoError(t, s.CreateSession("other", "bob", "1.2.3.4", time.Now().Add(time.Hour)))
+
+	testCases := []struct {
+		name          string
+		username      string
+		form          *PasswordFormData
+		expectedError error
+	}{
+		{"BAD_CURRENT", "bob", &PasswordFormData{Current: "bad", New: "newpassword", Confirm: "newpassword"}, ErrBadCurrentPassword},
+		{"MISMATCH", "bob", &PasswordFormData{Current: "oldpassword", New: "newpassword", Confirm: "other"}, ErrPasswordMismatch},
+		{"READ_ONLY", "jean", &PasswordFormData{Current: "pwd", New: "newpassword", Confirm: "newpassword"}, ErrPasswordReadOnly},
+		{"NOMINAL", "bob", &PasswordFormData{Current: "oldpassword", New: "newpassword", Confirm: "newpassword"}, nil},
+	}
+	// steps depend on each other, so no parallel run
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.expectedError, ChangePassword(tc.username, tc.form, "url.net", "current"))
+		})
+	}
+
+	// policy error
+	err := ChangePassword("bob", &PasswordFormData{Current: "newpassword", New: "short", Confirm: "short"}, "url.net", "current")
+	assert.ErrorContains(t, err, "at least")
+
+	assert.True(t, CompareHash(s.GetUser("bob").Password, "newpassword"))
+	assert.False(t, s.SessionRevoked("current"))
+	assert.True(t, s.SessionRevoked("other"))
+}
+
+func TestShowHomeHandlerPasswordChange(t *testing.T) {
+	backup := configuration.db
+	defer func() { configuration.db = backup }()
+	s := newTestSqlStore(t)
+	configuration.db = s
+	assert.NoError(t, s.CreateUser(&User{Username: "bob", Password: GetHash("oldpassword"), AllowedDomains: []string{"url.net"}}))
+
+	request := func(form url.Values) *httptest.ResponseRecorder {
+		r := httptest.NewRequest("POST", "/", nil)
+		r.Header.Set("X-Real-IP", "1.2.3.4")
+		r.Header.Set("X-Forwarded-Host", "url.net")
+		r.Header.Set("Password-Form", form.Encode())
+		r.AddCookie(CreateJwtCookie("bob", "1.2.3.4", []string{"url.net"}))
+		w := httptest.NewRecorder()
+		ShowHomeHandler(w, r)
+		return w
+	}
+
+	w := request(url.Values{"current": {"bad"}, "new": {"newpassword"}, "confirm": {"newpassword"}})
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Contains(t, w.Body.String(), ErrBadCurrentPassword.Error())
+
+	w = request(url.Values{"current": {"oldpassword"}, "new": {"newpassword"}, "confirm": {"newpassword"}})
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), "Password changed")
+	assert.Equal(t, "password changed", auditLog.Recent()[0].Action)
+}
+
+func TestShowHomeHandlerMustChangePassword(t *testing.T) {
+	backup := configuration.db
+	defer func() { configuration.db = backup }()
+	s := newTestSqlStore(t)
+	configuration.db = s
+	assert.NoError(t, s.CreateUser(&User{Username: "carol", Password: GetHash("oldpassword"), AllowedDomains: []string{"url.net"}, MustChangePassword: true}))
+
+	request := func(handler http.HandlerFunc, header string, form url.Values, cookie *http.Cookie) *httptest.ResponseRecorder {
+		r := httptest.NewRequest("POST", "/", nil)
+		r.Header.Set("X-Real-IP", "1.2.3.4")
+		r.Header.Set("X-Forwarded-Host", "url.net")
+		if header != "" {
+			r.Header.Set(header, form.Encode())
+		}
+		if cookie != nil {
+			r.AddCookie(cookie)
+		}
+		w := httptest.NewRecorder()
+		handler(w, r)
+		return w
+	}
+
+	// login gives a cookie only allowing password change
+	w := request(ShowHomeHandler, "Auth-Form", url.Values{"username": {"carol"}, "password": {"oldpassword"}}, nil)
+	assert.Equal(t, http.StatusMultipleChoices, w.Code)
+	cookie := w.Result().Cookies()[0]
+	assert.Equal(t, http.StatusForbidden, request(VerifyHandler, "", nil, cookie).Code)
+	w = request(ShowHomeHandler, "", nil, cookie)
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	assert.Contains(t, w.Body.String(), ErrMustChangePassword.Error())
+
+	// password change gives full access
+	w = request(ShowHomeHandler, "Password-Form", url.Values{"current": {"oldpassword"}, "new": {"newpassword"}, "confirm": {"newpassword"}}, cookie)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.False(t, s.GetUser("carol").MustChangePassword)
+	if assert.Len(t, w.Result().Cookies(), 1) {
+		assert.Equal(t, http.StatusOK, request(VerifyHandler, "", nil, w.Result().Cookies()[0]).Code)
+	}
+
+	// inactive account is told why it is logged out on refresh
+	refreshBackup := configuration.TokenRefresh
+	defer func() { configuration.TokenRefresh = refreshBackup }()
+	configuration.TokenRefresh = configuration.TokenExpire + 1
+	cookie = CreateJwtCookie("carol", "1.2.3.4", []string{"url.net"})
+	assert.NoError(t, s.SetUserValidity("carol", time.Time{}, time.Now().Add(-time.Minute)))
+	w = request(ShowHomeHandler, "", nil, cookie)
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	assert.Contains(t, w.Body.String(), ErrUserExpired.Error())
+
+	// and can't log in again
+	w = request(ShowHomeHandler, "Auth-Form", url.Values{"username": {"carol"}, "password": {"newpassword"}}, nil)
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+	assert.Contains(t, w.Body.String(), ErrUserExpired.Error())
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+// sliding window rate limiter, by key
+type RateLimiter struct {
+	mu   sync.Mutex
+	hits map[string][]time.Time
+}
+
+// limit emails sent by GFA, by ip and by user
+var mailRateLimiter = &RateLimiter{}
+
+// record a hit for key, return false if max hits in window is exceeded
+func (l *RateLimiter) Allow(key string, max uint, window time.Duration) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := time.Now()
+	if l.hits == nil {
+		l.hits = map[string][]time.Time{}
+	}
+	// forget old hits, of every key
+	for k, hits := range l.hits {
+		recent := hits[:0]
+		for _, h := range hits {
+			if now.Sub(h) < window {
+				recent = append(recent, h)
+			}
+		}
+		if len(recent) == 0 {
+			delete(l.hits, k)
+			continue
+		}
+		l.hits[k] = recent
+	}
+	if uint(len(l.hits[key])) >= max {
+		return false
+	}
+	l.hits[key] = append(l.hits[key], now)
+	return true
+}
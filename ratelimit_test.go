@@ -0,0 +1,23 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRateLimiter(t *testing.T) {
+	l := &RateLimiter{}
+
+	assert.True(t, l.Allow("a", 2, time.Hour))
+	assert.True(t, l.Allow("a", 2, time.Hour))
+	assert.False(t, l.Allow("a", 2, time.Hour))
+	// keys are independent
+	assert.True(t, l.Allow("b", 2, time.Hour))
+
+	// old hits are forgotten
+	time.Sleep(5 * time.Millisecond)
+	assert.True(t, l.Allow("a", 2, time.Millisecond))
+	assert.NotContains(t, l.hits, "b")
+}
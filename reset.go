@@ -0,0 +1,230 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/gorilla/csrf"
+	"go.uber.org/zap"
+)
+
+// path of the password reset page
+const resetPath = "/reset"
+
+// user facing errors of links sent by email
+var (
+	ErrInvalidLink     = errors.New("Invalid or expired link")
+	ErrTooManyRequests = errors.New("Too many requests, try again later")
+)
+
+// claims of links sent by email
+type MailClaims struct {
+	// fingerprint of the password hash, so a reset link is usable only once
+	Pwd string `json:",omitempty"`
+	jwt.RegisteredClaims
+}
+
+// key signing links sent by email, distinct for each purpose and from the cookie key
+func mailTokenKey(purpose string) []byte {
+	m := hmac.New(sha256.New, []byte(configuration.JwtSecretKey))
+	m.Write([]byte("gfa-mail-token:" + purpose))
+	return m.Sum(nil)
+}
+
+// fingerprint of a password hash, changes when password changes
+func passwordFingerprint(hash string) string {
+	m := hmac.New(sha256.New, []byte(configuration.JwtSecretKey))
+	m.Write([]byte(hash))
+	return hex.EncodeToString(m.Sum(nil))[:32]
+}
+
+// return a signed token to send by email, expiring after expire minutes
+func createMailToken(purpose string, cl *MailClaims, expire time.Duration) string {
+	id := GenerateRandomBytes(16)
+	cl.ID = base64.RawURLEncoding.EncodeToString(*id)
+	cl.ExpiresAt = jwt.NewNumericDate(time.Now().Add(expire * time.Minute))
+	cl.IssuedAt = jwt.NewNumericDate(time.Now())
+	cl.Issuer = "GFA"
+	token, _ := jwt.NewWithClaims(jwt.SigningMethodHS256, cl).SignedString(mailTokenKey(purpose))
+	return token
+}
+
+// return a signed, time-limited, single-use password reset token for user
+func CreateResetToken(u *User) string {
+	cl := &MailClaims{
+		Pwd:              passwordFingerprint(u.Password),
+		RegisteredClaims: jwt.RegisteredClaims{Subject: u.Username},
+	}
+	return createMailToken("reset", cl, configuration.ResetTokenExpire)
+}
+
+// parse and verify a token sent by email
+func parseMailToken(purpose, token string) *MailClaims {
+	cl := &MailClaims{}
+	t, err := jwt.ParseWithClaims(token, cl, func(t *jwt.Token) (interface{}, error) {
+		// Validate alg for security ("none" is not allowed)
+		if _, ok := t.Method.(*jwt.SigningMethodHMAC); !ok {
+			return nil, fmt.Errorf("unexpected signing method: %v", t.Header["alg"])
+		}
+		return mailTokenKey(purpose), nil
+	})
+	if err != nil || !t.Valid || cl.Subject == "" {
+		log.Error("mail: invalid token", zap.String("purpose", purpose), zap.Error(err))
+		return nil
+	}
+	return cl
+}
+
+// return user of a valid reset token, nil if invalid, expired or already used
+func GetUserFromResetToken(token string) *User {
+	cl := parseMailToken("reset", token)
+	if cl == nil {
+		return nil
+	}
+	u := GetUser(cl.Subject)
+	if u == nil {
+		return nil
+	}
+	if !hmac.Equal([]byte(cl.Pwd), []byte(passwordFingerprint(u.Password))) {
+		log.Error("reset: link already used", zap.String("username", u.Username))
+		return nil
+	}
+	return u
+}
+
+// send a reset link to the user matching username or email, if any
+// to avoid disclosing accounts, nothing tells the caller if an email was sent
+func RequestPasswordReset(identifier, ip string) error {
+	identifier = strings.TrimSpace(identifier)
+	if identifier == "" {
+		return nil
+	}
+	if !mailRateLimiter.Allow("ip:"+ip, configuration.MailRateLimit, time.Hour) {
+		log.Error("reset: too many requests", zap.String("ip", ip), zap.String("identifier", identifier))
+		return ErrTooManyRequests
+	}
+
+	u := GetUser(identifier)
+	if u == nil {
+		u = GetUserByEmail(identifier)
+	}
+	switch {
+	case u == nil:
+		log.Info("reset: user not found", zap.String("identifier", identifier))
+		return nil
+	case u.Email == "":
+		log.Info("reset: user has no email", zap.String("username", u.Username))
+		return nil
+	}
+	if _, ok := u.store.(PasswordStore); !ok {
+		log.Info("reset: user store is read-only", zap.String("username", u.Username))
+		return nil
+	}
+	// only mails really sent count for the user, and hitting its limit is silent so accounts aren't disclosed
+	if !mailRateLimiter.Allow("user:"+strings.ToLower(u.Username), configuration.MailRateLimit, time.Hour) {
+		log.Error("reset: too many requests", zap.String("ip", ip), zap.String("username", u.Username))
+		return nil
+	}
+
+	link := configuration.PublicUrl + resetPath + "?token=" + CreateResetToken(u)
+	body := "Hello " + u.Username + ",\n\n" +
+		"A password reset was requested for your account. " +
+		"Use the following link within " + strconv.Itoa(int(configuration.ResetTokenExpire)) + " minutes to choose a new password:\n\n" +
+		link + "\n\n" +
+		"If you didn't request it, you can ignore this email.\n"
+	auditLog.Add(u.Username, "password reset requested", "", ip)
+	// send in background, so response time doesn't disclose accounts
+	go func() {
+		if err := configuration.Smtp.Send(u.Email, "Password reset", body); err != nil {
+			log.Error("reset: error sending email", zap.String("username", u.Username), zap.Error(err))
+		}
+	}()
+	return nil
+}
+
+// set new password of user of reset token, and revoke all its sessions
+func ResetPassword(token, password, confirm, ip string) error {
+	u := GetUserFromResetToken(token)
+	if u == nil {
+		return ErrInvalidLink
+	}
+	if password != confirm {
+		return ErrPasswordMismatch
+	}
+	if err := configuration.PasswordPolicy.Check(u.Username, password); err != nil {
+		return err
+	}
+	if err := u.ChangePassword(password, ""); err != nil {
+		return err
+	}
+	lockouts.Unlock(u.Username)
+	auditLog.Add(u.Username, "password reset", "", ip)
+	return nil
+}
+
+// forgotten password page : ask for a reset link, then set a new password from the link
+func ResetHandler(w http.ResponseWriter, r *http.Request) {
+
+	if configuration.Smtp == nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	ctx := &Context{
+		CsrfToken:      csrf.Token(r),
+		Ip:             GetIp(r),
+		Url:            GetHost(r),
+		Language:       GetAcceptLanguage(r),
+		Nonce:          CspNonce(r),
+		State:          "forgot",
+		HttpReturnCode: http.StatusOK,
+	}
+	log.Sugar().Debug("server: reset requested", zap.String("ip", ctx.Ip), "request", RedactRequest(r))
+
+	token := r.FormValue("token")
+	switch {
+	// ask for a link
+	case r.Method == http.MethodPost && token == "":
+		if err := RequestPasswordReset(r.PostFormValue("username"), ctx.Ip); err != nil {
+			ctx.HttpReturnCode = http.StatusTooManyRequests
+			ctx.ErrorMessage = err.Error()
+			break
+		}
+		ctx.State = "info"
+		ctx.Message = "If this account exists, an email has been sent with a link to reset its password"
+	// set new password
+	case r.Method == http.MethodPost:
+		if err := ResetPassword(token, r.PostFormValue("new"), r.PostFormValue("confirm"), ctx.Ip); err != nil {
+			// prevent bruteforce with sleeptime
+			time.Sleep(500 * time.Millisecond)
+			ctx.HttpReturnCode = http.StatusBadRequest
+			ctx.ErrorMessage = err.Error()
+			if err != ErrInvalidLink {
+				ctx.State = "reset"
+				ctx.Token = token
+			}
+			break
+		}
+		ctx.State = "info"
+		ctx.Message = "Password changed, you can now log in"
+	// link clicked
+	case token != "":
+		if GetUserFromResetToken(token) == nil {
+			ctx.HttpReturnCode = http.StatusBadRequest
+			ctx.ErrorMessage = ErrInvalidLink.Error()
+			break
+		}
+		ctx.State = "reset"
+		ctx.Token = token
+	}
+	log.Info("Loading Template", zap.Int("status", ctx.HttpReturnCode), zap.Error(LoadTemplate(&w, ctx)))
+}
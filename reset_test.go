@@ -0,0 +1,162 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/stretchr/testify/assert"
+)
+
+// configure smtp and a database with user bob, restored at the end of test
+func setupTestReset(t *testing.T) (*SqlStore, chan string) {
+	dbBackup, smtpBackup, urlBackup, limiterBackup := configuration.db, configuration.Smtp, configuration.PublicUrl, mailRateLimiter
+	t.Cleanup(func() {
+		configuration.db, configuration.Smtp, configuration.PublicUrl, mailRateLimiter = dbBackup, smtpBackup, urlBackup, limiterBackup
+	})
+	s := newTestSqlStore(t)
+	configuration.db = s
+	addr, msgs := newTestSmtpServer(t)
+	configuration.Smtp = &Smtp{Address: addr, From: "gfa@example.com"}
+	configuration.PublicUrl = "https://auth.example.com"
+	mailRateLimiter = &RateLimiter{}
+	assert.NoError(t, s.CreateUser(&User{Username: "bob", Password: GetHash("oldpassword"), Email: "bob@example.com", AllowedDomains: []string{"url.net"}}))
+	assert.NoError(t, s.CreateUser(&User{Username: "noemail", Password: GetHash("oldpassword")}))
+	return s, msgs
+}
+
+// extract reset token of link sent by email
+func getResetToken(t *testing.T, mail string) string {
+	m := regexp.MustCompile(`https://auth\.example\.com/reset\?token=(\S+)`).FindStringSubmatch(mail)
+	if m == nil {
+		t.Fatal("no reset link in email")
+	}
+	return m[1]
+}
+
+func TestResetToken(t *testing.T) {
+	s, _ := setupTestReset(t)
+
+	token := CreateResetToken(s.GetUser("bob"))
+	if u := GetUserFromResetToken(token); assert.NotNil(t, u) {
+		assert.Equal(t, "bob", u.Username)
+	}
+	assert.Nil(t, GetUserFromResetToken("bad"))
+	assert.Nil(t, GetUserFromResetToken(token+"x"))
+
+	// cookie key can't sign reset links
+	cl := &MailClaims{Pwd: passwordFingerprint(s.GetUser("bob").Password), RegisteredClaims: jwt.RegisteredClaims{Subject: "bob"}}
+	forged, _ := jwt.NewWithClaims(jwt.SigningMethodHS256, cl).SignedString([]byte(configuration.JwtSecretKey))
+	assert.Nil(t, GetUserFromResetToken(forged))
+
+	// expired
+	cl.ExpiresAt = jwt.NewNumericDate(time.Now().Add(-time.Minute))
+	expired, _ := jwt.NewWithClaims(jwt.SigningMethodHS256, cl).SignedString(mailTokenKey("reset"))
+	assert.Nil(t, GetUserFromResetToken(expired))
+
+	// link is unusable once password changed
+	assert.NoError(t, s.SetPassword("bob", GetHash("newpassword")))
+	assert.Nil(t, GetUserFromResetToken(token))
+}
+
+func TestRequestPasswordReset(t *testing.T) {
+	_, msgs := setupTestReset(t)
+
+	testCases := []struct {
+		name       string
+		identifier string
+		expectMail bool
+	}{
+		{"EMPTY", "", false},
+		{"UNKNOWN", "nope", false},
+		{"NO_EMAIL", "noemail", false},
+		{"CONFIG_USER", "jean", false},
+		{"USERNAME", "bob", true},
+		{"EMAIL", "BOB@example.com", true},
+	}
+	// mails are received in order, so no parallel run
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.NoError(t, RequestPasswordReset(tc.identifier, "1.2.3."+tc.name))
+			if !tc.expectMail {
+				return
+			}
+			m := receiveTestMail(t, msgs)
+			assert.Contains(t, m, "To: <bob@example.com>")
+			assert.Equal(t, "bob", GetUserFromResetToken(getResetToken(t, m)).Username)
+		})
+	}
+	assert.Empty(t, msgs)
+
+	// rate limited by ip, then silently by user once mails are sent
+	limitBackup := configuration.MailRateLimit
+	defer func() { configuration.MailRateLimit = limitBackup }()
+	configuration.MailRateLimit = 1
+	assert.ErrorIs(t, RequestPasswordReset("other", "1.2.3.USERNAME"), ErrTooManyRequests)
+	assert.NoError(t, RequestPasswordReset("bob", "9.9.9.9"))
+	assert.Empty(t, msgs)
+
+	// unknown users and users without email don't use the user limit
+	mailRateLimiter = &RateLimiter{}
+	assert.NoError(t, RequestPasswordReset("nope", "9.9.9.1"))
+	assert.NoError(t, RequestPasswordReset("nope", "9.9.9.2"))
+	assert.NoError(t, RequestPasswordReset("noemail", "9.9.9.3"))
+	assert.NoError(t, RequestPasswordReset("bob", "9.9.9.4"))
+	assert.Contains(t, receiveTestMail(t, msgs), "To: <bob@example.com>")
+}
+
+func TestResetHandler(t *testing.T) {
+	s, msgs := setupTestReset(t)
+	assert.NoError(t, s.CreateSession("session", "bob", "1.2.3.4", time.Now().Add(time.Hour)))
+
+	request := func(method, target string, form url.Values) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(method, target, strings.NewReader(form.Encode()))
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		r.Header.Set("X-Real-IP", "1.2.3.4")
+		w := httptest.NewRecorder()
+		ResetHandler(w, r)
+		return w
+	}
+
+	w := request("GET", "/reset", nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), "Send reset link")
+
+	w = request("POST", "/reset", url.Values{"username": {"bob"}})
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), "If this account exists")
+	token := getResetToken(t, receiveTestMail(t, msgs))
+
+	w = request("GET", "/reset?token=bad", nil)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Contains(t, w.Body.String(), ErrInvalidLink.Error())
+
+	w = request("GET", "/reset?token="+token, nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), token)
+
+	w = request("POST", "/reset", url.Values{"token": {token}, "new": {"newpassword"}, "confirm": {"other"}})
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Contains(t, w.Body.String(), "match")
+
+	w = request("POST", "/reset", url.Values{"token": {token}, "new": {"newpassword"}, "confirm": {"newpassword"}})
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), "Password changed")
+	assert.True(t, CompareHash(s.GetUser("bob").Password, "newpassword"))
+	assert.True(t, s.SessionRevoked("session"))
+	assert.Equal(t, "password reset", auditLog.Recent()[0].Action)
+
+	// single use
+	w = request("POST", "/reset", url.Values{"token": {token}, "new": {"otherpassword"}, "confirm": {"otherpassword"}})
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.True(t, CompareHash(s.GetUser("bob").Password, "newpassword"))
+
+	// disabled without smtp
+	configuration.Smtp = nil
+	assert.Equal(t, http.StatusNotFound, request("GET", "/reset", nil).Code)
+}
@@ -1,9 +1,8 @@
 package main
 
 import (
+	"crypto/tls"
 	"errors"
-	"fmt"
-	"html/template"
 	"net/http"
 	"time"
 
@@ -22,7 +21,52 @@ type Context struct {
 	Ip              string
 	State           string
 	Url             string
-	ErrorMessage    string
+	// url requested before authentication
+	ReturnUrl    string
+	ErrorMessage string
+	Message      string
+	// language preferred by browser, empty if none supported
+	Language string
+	// nonce of inline scripts and styles, allowed by Content-Security-Policy
+	Nonce string
+	// password reset token, from emailed link
+	Token string
+	// reason of the response, for API clients
+	Reason string
+	// client asked for json instead of html
+	Json bool
+}
+
+// endpoints served by GFA
+var Endpoints = map[string]http.HandlerFunc{
+	"/":           ShowHomeHandler,
+	"/verify":     VerifyHandler,
+	"/logout":     LogoutHandler,
+	"/health":     HealthHandler,
+	resetPath:     ResetHandler,
+	magicLinkPath: MagicLinkHandler,
+	staticPrefix:  StaticHandler,
+	loginPath:     LoginHandler,
+	// trailing slash serves the whole subtree
+	adminPagePath:  AdminPageHandler,
+	adminApiPrefix: AdminApiHandler,
+}
+
+// create router serving given endpoints, all endpoints if none provided
+func NewRouter(endpoints []string) *http.ServeMux {
+	r := http.NewServeMux()
+	if len(endpoints) == 0 {
+		for e, h := range Endpoints {
+			r.HandleFunc(e, h)
+		}
+		return r
+	}
+	for _, e := range endpoints {
+		if h, ok := Endpoints[e]; ok {
+			r.HandleFunc(e, h)
+		}
+	}
+	return r
 }
 
 // set handler for and start listening
@@ -34,19 +78,33 @@ func LoadServer() error {
 		csrf.Path("/"),
 		csrf.CookieName(configuration.CookieName+"_csrf"),
 		csrf.Domain(configuration.CookieDomain),
+		// hidden field of templates, for forms posted without javascript
+		csrf.FieldName("csrf"),
 	)
 
-	r := http.NewServeMux()
-	r.HandleFunc("/", ShowHomeHandler)
-	r.HandleFunc("/verify", VerifyHandler)
-	r.HandleFunc("/logout", LogoutHandler)
-	r.HandleFunc("/health", HealthHandler)
+	if len(configuration.Listeners) == 0 {
+		return errors.New("server: no listener configured")
+	}
 
-	log.Info("Loading server...", zap.Uint("port", configuration.Port))
+	// certificate is shared by all tls listeners
+	var tlsConfig *tls.Config
+	if HasTlsListener(configuration.Listeners) {
+		var err error
+		if tlsConfig, err = NewTlsConfig(); err != nil {
+			return err
+		}
+	}
 
-	// transform PORT from int to string like ":<port>"
-	var port = ":" + fmt.Sprint(configuration.Port)
-	return http.ListenAndServeTLS(port, configuration.Certificate, configuration.PrivateKey, CSRF(r))
+	// start every listener, and stop at the first failure
+	errs := make(chan error, len(configuration.Listeners))
+	for _, l := range configuration.Listeners {
+		log.Info("Loading server...", zap.String("type", l.Type), zap.String("address", l.Address), zap.Strings("endpoints", l.Endpoints))
+		go func(l *Listener) {
+			router := NewRouter(l.Endpoints)
+			errs <- l.Serve(SetSecurityHeaders(SkipCsrfForStatic(router, SkipCsrfForAdminApi(CSRF(router)))), tlsConfig)
+		}(l)
+	}
+	return <-errs
 }
 
 // health handler
@@ -63,9 +121,15 @@ func ShowHomeHandler(w http.ResponseWriter, r *http.Request) {
 		Ip:        GetIp(r),
 		State:     "out",
 		Url:       GetHost(r),
+		ReturnUrl: GetReturnUrl(r),
+		Language:  GetAcceptLanguage(r),
+		Nonce:     CspNonce(r),
+		Json:      WantsJson(r),
 	}
+	// response depends on these headers, caches must not mix html and json
+	w.Header().Add("Vary", "Accept, X-Requested-With")
 
-	log.Sugar().Debug("server: home requested", zap.String("ip", ctx.Ip), "request", r)
+	log.Sugar().Debug("server: home requested", zap.String("ip", ctx.Ip), "request", RedactRequest(r))
 
 	// get jwt from cookie
 	ctx.UserCookie, _ = r.Cookie(configuration.CookieName)
@@ -82,14 +146,17 @@ func ShowHomeHandler(w http.ResponseWriter, r *http.Request) {
 			case ctx.UserCookie == nil:
 				ctx.HttpReturnCode = http.StatusUnauthorized
 				ctx.State = "out"
+				ctx.Reason = "unauthenticated"
 			// bad domain (only cookie)
 			case ctx.UserCookie != nil:
 				log.Error("server: bad token", zap.String("ip", ctx.Ip))
 				ctx.HttpReturnCode = http.StatusForbidden
 				ctx.State = "out"
+				ctx.Reason = "session_invalid"
 				// if cookie is still valid, it means the user is trying to access an unauthorized Domain
 				if err := ctx.UserCookie.Valid(); err == nil {
-					ctx.ErrorMessage = "Unauthorized access"
+					ctx.ErrorMessage = ErrUnauthorizedAccess.Error()
+					ctx.Reason = "domain_not_allowed"
 				}
 			}
 			log.Info("Loading Template", zap.Int("status", ctx.HttpReturnCode), zap.Error(LoadTemplate(&w, ctx)))
@@ -97,20 +164,25 @@ func ShowHomeHandler(w http.ResponseWriter, r *http.Request) {
 		}
 
 		// from here formdata is provided
-		ctx.User = GetValidUserFromFormData(ctx.FormData, ctx.Url)
+		var err error
+		ctx.User, err = GetValidUserFromFormData(ctx.FormData, ctx.Url)
 
 		switch {
-		// bad credentials
+		// bad credentials, or inactive account
 		case ctx.User == nil:
+			auditLog.Add(ctx.FormData.Username, "login failed", ctx.Url, ctx.Ip)
 			time.Sleep(500 * time.Millisecond)
 			ctx.HttpReturnCode = http.StatusUnauthorized
 			ctx.State = "out"
-			ctx.ErrorMessage = "Bad credentials"
+			ctx.Reason = "bad_credentials"
+			ctx.ErrorMessage = err.Error()
 		// data provided are valid
 		case ctx.User != nil:
 			log.Info("server: new jwt", zap.String("ip", ctx.Ip))
+			auditLog.Add(ctx.User.Username, "login", ctx.Url, ctx.Ip)
 			ctx.HttpReturnCode = http.StatusMultipleChoices
 			ctx.State = "in"
+			ctx.Reason = "logged_in"
 
 			// set MagicIp if user allow connection from anyip
 			claimsIp := ctx.Ip
@@ -118,13 +190,42 @@ func ShowHomeHandler(w http.ResponseWriter, r *http.Request) {
 				claimsIp = configuration.MagicIp
 			}
 
-			ctx.GeneratedCookie = CreateJwtCookie(ctx.User.Username, claimsIp, ctx.User.AllowedDomains)
+			ctx.GeneratedCookie = CreateUserJwtCookie(ctx.User, claimsIp)
 		}
 		log.Info("Loading Template", zap.Int("status", ctx.HttpReturnCode), zap.Error(LoadTemplate(&w, ctx)))
 		return
 	}
 
 	// from here, we have a valid Jwt
+	// password change requested
+	if pf := GetPasswordFormData(r); pf != nil {
+		ctx.State = "in"
+		if err := ChangePassword(ctx.Claims.Subject, pf, ctx.Url, ctx.Claims.ID); err != nil {
+			ctx.HttpReturnCode = http.StatusBadRequest
+			ctx.Reason = "password_change_failed"
+			ctx.ErrorMessage = err.Error()
+		} else {
+			auditLog.Add(ctx.Claims.Subject, "password changed", ctx.Url, ctx.Ip)
+			ctx.HttpReturnCode = http.StatusOK
+			ctx.Reason = "password_changed"
+			ctx.Message = "Password changed"
+			// password change was required, give access to domains
+			if u := GetUser(ctx.Claims.Subject); ctx.Claims.ChangePassword && u != nil {
+				ctx.GeneratedCookie = CreateUserJwtCookie(u, ctx.Claims.Ip)
+			}
+		}
+		log.Info("Loading Template", zap.Int("status", ctx.HttpReturnCode), zap.Error(LoadTemplate(&w, ctx)))
+		return
+	}
+	// cookie only allows to change password
+	if ctx.Claims.ChangePassword {
+		ctx.HttpReturnCode = http.StatusForbidden
+		ctx.State = "in"
+		ctx.Reason = "password_change_required"
+		ctx.ErrorMessage = ErrMustChangePassword.Error()
+		log.Info("Loading Template", zap.Int("status", ctx.HttpReturnCode), zap.Error(LoadTemplate(&w, ctx)))
+		return
+	}
 	// refresh needed
 	if time.Until(ctx.Claims.ExpiresAt.Time) < (configuration.TokenRefresh * time.Minute) {
 		ctx.User = GetUser(ctx.Claims.Subject)
@@ -134,6 +235,13 @@ func ShowHomeHandler(w http.ResponseWriter, r *http.Request) {
 			log.Error("server: user not found", zap.String("user", ctx.Claims.Subject))
 			ctx.HttpReturnCode = http.StatusForbidden
 			ctx.State = "out"
+			ctx.Reason = "user_disabled"
+			// tell why user is logged out
+			if u := lookupUser(ctx.Claims.Subject); u != nil {
+				if err := u.Active(); err != nil {
+					ctx.ErrorMessage = err.Error()
+				}
+			}
 			ctx.GeneratedCookie = &http.Cookie{
 				Name:     configuration.CookieName,
 				Value:    "",
@@ -149,10 +257,16 @@ func ShowHomeHandler(w http.ResponseWriter, r *http.Request) {
 			log.Info("server: renew jwt", zap.String("ip", ctx.Ip))
 			ctx.HttpReturnCode = http.StatusMultipleChoices
 			ctx.State = "in"
-			ctx.GeneratedCookie = CreateJwtCookie(ctx.User.Username, ctx.Ip, ctx.User.AllowedDomains)
+			ctx.Reason = "refreshed"
+			ctx.GeneratedCookie = CreateUserJwtCookie(ctx.User, ctx.Ip)
 			// validate new cookie domain is allowed
-			if GetValidJwtClaims(ctx.GeneratedCookie, ctx.Ip, ctx.Url) == nil {
-				ctx.ErrorMessage = "Restricted Area"
+			switch {
+			case GetValidJwtClaims(ctx.GeneratedCookie, ctx.Ip, ctx.Url) == nil:
+				ctx.ErrorMessage = ErrRestrictedArea.Error()
+				ctx.Reason = "domain_not_allowed"
+			case ctx.User.MustChangePassword:
+				ctx.ErrorMessage = ErrMustChangePassword.Error()
+				ctx.Reason = "password_change_required"
 			}
 		}
 		log.Info("Loading Template", zap.Int("status", ctx.HttpReturnCode), zap.Error(LoadTemplate(&w, ctx)))
@@ -161,6 +275,7 @@ func ShowHomeHandler(w http.ResponseWriter, r *http.Request) {
 	// all validations passed
 	ctx.HttpReturnCode = http.StatusOK
 	ctx.State = "in"
+	ctx.Reason = "authenticated"
 	log.Debug("Loading Template", zap.Int("status", ctx.HttpReturnCode), zap.Error(LoadTemplate(&w, ctx)))
 }
 
@@ -168,12 +283,22 @@ func ShowHomeHandler(w http.ResponseWriter, r *http.Request) {
 func LogoutHandler(w http.ResponseWriter, r *http.Request) {
 
 	ip := GetIp(r)
-	log.Sugar().Debug("server: logout requested", zap.String("ip", ip), "request", r)
+	log.Sugar().Debug("server: logout requested", zap.String("ip", ip), "request", RedactRequest(r))
 
 	// remove cookie if exists
 	if c, _ := r.Cookie(configuration.CookieName); c != nil {
 		log.Info("server: delete jwt", zap.String("ip", ip))
 
+		if cl := GetValidJwtClaims(c, ip, GetHost(r)); cl != nil {
+			auditLog.Add(cl.Subject, "logout", GetHost(r), ip)
+			// revoke session, so a copy of the cookie can't be reused
+			if configuration.db != nil {
+				if err := configuration.db.RevokeSession(cl.ID); err != nil {
+					log.Error("server: failed to revoke session", zap.Error(err))
+				}
+			}
+		}
+
 		http.SetCookie(w, &http.Cookie{
 			Name:     configuration.CookieName,
 			Value:    "",
@@ -193,30 +318,69 @@ func LogoutHandler(w http.ResponseWriter, r *http.Request) {
 	http.Redirect(w, r, "/", http.StatusFound)
 }
 
-// remove cookie and redirect to home
+// return 200 if request is authenticated, 403 otherwise
 func VerifyHandler(w http.ResponseWriter, r *http.Request) {
 
 	// Init ctx
 	ctx := &Context{
 		Ip:        GetIp(r),
 		Url:       GetHost(r),
+		ReturnUrl: GetReturnUrl(r),
+		Language:  GetAcceptLanguage(r),
+		Json:      WantsJson(r),
 	}
 
-	log.Sugar().Debug("server: verify requested", zap.String("ip", ctx.Ip), "request", r)
+	log.Sugar().Debug("server: verify requested", zap.String("ip", ctx.Ip), "request", RedactRequest(r))
 
 	// get jwt from cookie
 	ctx.UserCookie, _ = r.Cookie(configuration.CookieName)
 	ctx.Claims = GetValidJwtClaims(ctx.UserCookie, ctx.Ip, ctx.Url)
-	
-	// if no valid claims
+	ctx.Reason = "unauthenticated"
+	if ctx.UserCookie != nil {
+		ctx.Reason = "session_invalid"
+	}
+	if ctx.Claims != nil && ctx.Claims.ChangePassword {
+		log.Info("server: password must be changed", zap.String("username", ctx.Claims.Subject))
+		ctx.Claims = nil
+		ctx.Reason = "password_change_required"
+		ctx.ErrorMessage = ErrMustChangePassword.Error()
+	}
+
+	// if no valid claims, try bearer token then client certificate
 	if ctx.Claims == nil {
-		w.WriteHeader(http.StatusForbidden)
-		// no cookie, prevent bruteforce with sleeptime
+		ctx.User = GetValidUserFromToken(r, ctx.Url)
+	}
+	basicAuth := BasicAuthEnabled(ctx.Url)
+	if ctx.Claims == nil && ctx.User == nil && basicAuth {
+		if username, password, ok := r.BasicAuth(); ok {
+			ctx.User = GetValidUserFromBasicAuth(username, password, ctx.Url)
+		}
+	}
+	if ctx.Claims == nil && ctx.User == nil {
+		ctx.User = GetValidUserFromClientCert(r, ctx.Url)
+	}
+
+	if ctx.Claims == nil && ctx.User == nil {
+		// prevent bruteforce with sleeptime
 		time.Sleep(500 * time.Millisecond)
+		ctx.HttpReturnCode = http.StatusForbidden
+		// ask client for credentials
+		if basicAuth {
+			w.Header().Set("WWW-Authenticate", `Basic realm="GFA", charset="UTF-8"`)
+			ctx.HttpReturnCode = http.StatusUnauthorized
+		}
+		// tell single-page apps where to login
+		if ctx.Json {
+			ctx.State = "out"
+			WriteJson(w, ctx.HttpReturnCode, ctx.ToJson(ctx.GetLanguage()))
+			return
+		}
+		w.WriteHeader(ctx.HttpReturnCode)
+		return
 	}
 
+	w.Header().Add("Remote-User", ctx.GetUsername())
 	w.WriteHeader(http.StatusOK)
-	return
 }
 
 // load template and return http code and html
@@ -237,22 +401,72 @@ func LoadTemplate(w *http.ResponseWriter, ctx *Context) error {
 	if ctx.State == "in" {
 		(*w).Header().Add("Remote-User", ctx.GetUsername())
 	}
+	lang := ctx.GetLanguage()
+	// status only for API clients
+	if ctx.Json {
+		WriteJson(*w, ctx.HttpReturnCode, ctx.ToJson(lang))
+		return nil
+	}
+	// template of the requested site, parsed once
+	parsedTemplate, err := templates.Get(configuration.HtmlFileOf(ctx.Url), lang)
+	if err != nil {
+		(*w).WriteHeader(http.StatusInternalServerError)
+		return err
+	}
 	(*w).WriteHeader(ctx.HttpReturnCode)
-	tplData := ctx.ToMap()
-	// parse data in template
-	parsedTemplate, _ := template.ParseFiles(configuration.HtmlFile)
 	// return http code and html
-	return parsedTemplate.Execute(*w, tplData)
+	return parsedTemplate.Execute(*w, ctx.ToMap(lang))
 }
 
-func (ctx *Context) ToMap() map[string]interface{} {
+// return data of templates, with messages translated in lang
+func (ctx *Context) ToMap(lang string) map[string]interface{} {
 	return map[string]interface{}{
 		"username": ctx.GetUsername(),
 		"state":    ctx.State,
 		"csrf":     ctx.CsrfToken,
 		"ip":       ctx.Ip,
-		"error":    ctx.ErrorMessage,
+		"error":    Translate(lang, ctx.ErrorMessage),
+		"message":  Translate(lang, ctx.Message),
+		"token":    ctx.Token,
+		"resetUrl": ctx.ResetUrl(),
+		"magicUrl": ctx.MagicUrl(),
+		// cookie only allows to change password
+		"changePassword": ctx.Claims != nil && ctx.Claims.ChangePassword,
+		"host":           ctx.Url,
+		"returnUrl":      ctx.ReturnUrl,
+		"loginUrl":       ctx.LoginUrl(),
+		"methods":        ctx.LoginMethods(),
+		"branding":       configuration.BrandingOf(ctx.Url),
+		"lang":           lang,
+		"nonce":          ctx.Nonce,
+	}
+}
+
+// login methods available on the requested host
+func (ctx *Context) LoginMethods() map[string]bool {
+	return map[string]bool{
+		"password":    true,
+		"reset":       ctx.ResetUrl() != "",
+		"magicLink":   ctx.MagicUrl() != "",
+		"certificate": configuration.ClientCert != nil,
+		"basic":       BasicAuthEnabled(ctx.Url),
+	}
+}
+
+// link to password reset page, empty if emails can't be sent
+func (ctx *Context) ResetUrl() string {
+	if configuration.Smtp == nil {
+		return ""
+	}
+	return configuration.PublicUrl + resetPath
+}
+
+// link to passwordless login page, empty if disabled
+func (ctx *Context) MagicUrl() string {
+	if configuration.Smtp == nil || configuration.MagicLink == nil {
+		return ""
 	}
+	return configuration.PublicUrl + magicLinkPath
 }
 
 func (ctx *Context) GetUsername() string {
@@ -2,6 +2,7 @@ package main
 
 import (
 	"encoding/base64"
+	"fmt"
 	"io"
 	"net/http"
 	"net/http/httptest"
@@ -10,6 +11,9 @@ import (
 
 	"github.com/golang-jwt/jwt/v4"
 	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
 )
 
 func TestLoadServer(t *testing.T) {
@@ -24,6 +28,32 @@ func TestLoadServer(t *testing.T) {
 	assert.Error(t, LoadServer())
 }
 
+func TestNewRouter(t *testing.T) {
+	testCases := []struct {
+		name             string
+		endpoints        []string
+		path             string
+		expectedHttpCode int
+	}{
+		{"ALL_HEALTH", nil, "/health", http.StatusOK},
+		{"ALL_VERIFY", nil, "/verify", http.StatusForbidden},
+		{"RESTRICTED_HEALTH", []string{"/health"}, "/health", http.StatusOK},
+		{"RESTRICTED_VERIFY", []string{"/health"}, "/verify", http.StatusNotFound},
+		{"RESTRICTED_HOME", []string{"/health"}, "/", http.StatusNotFound},
+	}
+	for _, tc := range testCases {
+		// shadow the test case to avoid modifying the test case
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			req := httptest.NewRequest("GET", tc.path, nil)
+			w := httptest.NewRecorder()
+			NewRouter(tc.endpoints).ServeHTTP(w, req)
+			assert.Equal(t, tc.expectedHttpCode, w.Result().StatusCode)
+		})
+	}
+}
+
 func TestGetUsername(t *testing.T) {
 	u := &User{Username: "User"}
 	c := &Claims{RegisteredClaims: jwt.RegisteredClaims{Subject: "Claims"}}
@@ -72,14 +102,30 @@ func TestToMap(t *testing.T) {
 		CsrfToken:    "TestCsrf",
 		Ip:           "TestIp",
 		ErrorMessage: "TestError",
+		Message:      "TestMessage",
+		Token:        "TestToken",
+		Url:          "TestHost",
+		ReturnUrl:    "https://TestHost/path",
 	}
 
 	expectedMap := map[string]interface{}{
-		"username": "",
-		"state":    ctx.State,
-		"csrf":     ctx.CsrfToken,
-		"ip":       ctx.Ip,
-		"error":    ctx.ErrorMessage,
+		"username":       "",
+		"state":          ctx.State,
+		"csrf":           ctx.CsrfToken,
+		"ip":             ctx.Ip,
+		"error":          ctx.ErrorMessage,
+		"message":        ctx.Message,
+		"token":          ctx.Token,
+		"resetUrl":       "",
+		"magicUrl":       "",
+		"changePassword": false,
+		"host":           ctx.Url,
+		"returnUrl":      ctx.ReturnUrl,
+		"loginUrl":       ctx.LoginUrl(),
+		"methods":        ctx.LoginMethods(),
+		"branding":       configuration.BrandingOf(ctx.Url),
+		"lang":           "en",
+		"nonce":          "",
 	}
 
 	testCases := []struct {
@@ -89,7 +135,7 @@ func TestToMap(t *testing.T) {
 		noFormData bool
 		noUser     bool
 	}{
-		{"empty", &Context{}, map[string]interface{}{"username": "", "state": "", "csrf": "", "ip": "", "error": ""}, true, true},
+		{"empty", &Context{}, map[string]interface{}{"username": "", "state": "", "csrf": "", "ip": "", "error": "", "message": "", "token": "", "resetUrl": "", "magicUrl": "", "changePassword": false, "host": "", "returnUrl": "", "loginUrl": ctx.LoginUrl(), "methods": (&Context{}).LoginMethods(), "branding": configuration.BrandingOf(""), "lang": "en", "nonce": ""}, true, true},
 		{"formdata", ctx, expectedMap, false, true},
 		{"user", ctx, expectedMap, true, false},
 		{"formdata and user", ctx, expectedMap, false, false},
@@ -114,7 +160,7 @@ func TestToMap(t *testing.T) {
 				assert.False(t, tc.noUser)
 				tc.expected["username"] = tc.ctx.User.Username
 			}
-			assert.Equal(t, tc.expected, tc.ctx.ToMap())
+			assert.Equal(t, tc.expected, tc.ctx.ToMap("en"))
 		})
 	}
 }
@@ -261,6 +307,30 @@ func TestVerifyHandler(t *testing.T) {
 	}
 }
 
+func TestVerifyHandlerBasicAuthLog(t *testing.T) {
+	backupLog, backupDomains := log, configuration.BasicAuthDomains
+	defer func() { log, configuration.BasicAuthDomains = backupLog, backupDomains }()
+	core, logs := observer.New(zapcore.DebugLevel)
+	log = zap.New(core)
+	configuration.BasicAuthDomains = []string{"url.net"}
+
+	req := httptest.NewRequest("GET", "/verify", nil)
+	req.Host = "url.net"
+	req.RemoteAddr = "1.2.3.4"
+	req.SetBasicAuth("jean", "pwd")
+	http.HandlerFunc(VerifyHandler)(httptest.NewRecorder(), req)
+
+	// credentials never reach the log
+	secret := base64.StdEncoding.EncodeToString([]byte("jean:pwd"))
+	assert.NotZero(t, logs.Len())
+	for _, e := range logs.All() {
+		assert.NotContains(t, e.Message, secret)
+		for _, f := range e.Context {
+			assert.NotContains(t, fmt.Sprint(f.String, f.Interface), secret, e.Message)
+		}
+	}
+}
+
 func TestShowHomeHandler(t *testing.T) {
 
 	// create needing refresh jwt cookies
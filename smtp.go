@@ -0,0 +1,60 @@
+package main
+
+import (
+	"errors"
+	"mime"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"strings"
+	"time"
+)
+
+// smtp server used to send emails
+type Smtp struct {
+	Address  string `koanf:"Address"`
+	Username string `koanf:"Username"`
+	Password string `koanf:"Password"`
+	From     string `koanf:"From"`
+}
+
+func (s *Smtp) Valid() error {
+	if _, _, err := net.SplitHostPort(s.Address); err != nil {
+		return errors.New("smtp: bad Address, expected <host>:<port>")
+	}
+	if _, err := mail.ParseAddress(s.From); err != nil {
+		return errors.New("smtp: bad From\n\t-> " + err.Error())
+	}
+	return nil
+}
+
+// send a plain text email
+func (s *Smtp) Send(to, subject, body string) error {
+	from, _ := mail.ParseAddress(s.From)
+	rcpt, err := mail.ParseAddress(to)
+	if err != nil {
+		return errors.New("smtp: bad recipient\n\t-> " + err.Error())
+	}
+	// subject comes from GFA, but never allow header injection
+	subject = strings.NewReplacer("\r", "", "\n", "").Replace(subject)
+
+	msg := "From: " + from.String() + "\r\n" +
+		"To: " + rcpt.String() + "\r\n" +
+		"Subject: " + mime.QEncoding.Encode("utf-8", subject) + "\r\n" +
+		"Date: " + time.Now().Format(time.RFC1123Z) + "\r\n" +
+		"MIME-Version: 1.0\r\n" +
+		"Content-Type: text/plain; charset=UTF-8\r\n" +
+		"Content-Transfer-Encoding: 8bit\r\n" +
+		"\r\n" +
+		strings.ReplaceAll(body, "\n", "\r\n")
+
+	var auth smtp.Auth
+	if s.Username != "" {
+		host, _, _ := net.SplitHostPort(s.Address)
+		auth = smtp.PlainAuth("", s.Username, s.Password, host)
+	}
+	if err := smtp.SendMail(s.Address, auth, from.Address, []string{rcpt.Address}, []byte(msg)); err != nil {
+		return errors.New("smtp: error sending email\n\t-> " + err.Error())
+	}
+	return nil
+}
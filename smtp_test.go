@@ -0,0 +1,117 @@
+package main
+
+import (
+	"net"
+	"net/textproto"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// start a minimal smtp server, returning its address and a channel of received messages
+func newTestSmtpServer(t *testing.T) (string, chan string) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+	msgs := make(chan string, 10)
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go serveTestSmtp(conn, msgs)
+		}
+	}()
+	return l.Addr().String(), msgs
+}
+
+func serveTestSmtp(conn net.Conn, msgs chan string) {
+	defer conn.Close()
+	tp := textproto.NewConn(conn)
+	tp.PrintfLine("220 localhost ESMTP")
+	for {
+		line, err := tp.ReadLine()
+		if err != nil {
+			return
+		}
+		switch cmd := strings.ToUpper(strings.SplitN(line, " ", 2)[0]); cmd {
+		case "EHLO", "HELO":
+			tp.PrintfLine("250 localhost")
+		case "MAIL", "RCPT", "RSET", "NOOP":
+			tp.PrintfLine("250 OK")
+		case "DATA":
+			tp.PrintfLine("354 go ahead")
+			data, err := tp.ReadDotBytes()
+			if err != nil {
+				return
+			}
+			msgs <- string(data)
+			tp.PrintfLine("250 OK")
+		case "QUIT":
+			tp.PrintfLine("221 bye")
+			return
+		default:
+			tp.PrintfLine("502 not implemented")
+		}
+	}
+}
+
+// wait for a message sent to test server
+func receiveTestMail(t *testing.T, msgs chan string) string {
+	select {
+	case m := <-msgs:
+		return m
+	case <-time.After(5 * time.Second):
+		t.Fatal("no email received")
+		return ""
+	}
+}
+
+func TestSmtpValid(t *testing.T) {
+	testCases := []struct {
+		name          string
+		smtp          *Smtp
+		expectedError string
+	}{
+		{"NOMINAL", &Smtp{Address: "localhost:25", From: "GFA <gfa@example.com>"}, ""},
+		{"NO_PORT", &Smtp{Address: "localhost", From: "gfa@example.com"}, "bad Address"},
+		{"BAD_FROM", &Smtp{Address: "localhost:25", From: "gfa"}, "bad From"},
+	}
+	for _, tc := range testCases {
+		// shadow the test case to avoid modifying the test case
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			err := tc.smtp.Valid()
+			if tc.expectedError == "" {
+				assert.NoError(t, err)
+			} else {
+				assert.ErrorContains(t, err, tc.expectedError)
+			}
+		})
+	}
+}
+
+func TestSmtpSend(t *testing.T) {
+	addr, msgs := newTestSmtpServer(t)
+	s := &Smtp{Address: addr, From: "GFA <gfa@example.com>"}
+
+	assert.ErrorContains(t, s.Send("bad", "subject", "body"), "bad recipient")
+
+	assert.NoError(t, s.Send("jean@example.com", "Réinitialisation\r\nBcc: evil@example.com", "line 1\nline 2\n"))
+	// dot reader of test server turns CRLF into LF
+	m := receiveTestMail(t, msgs)
+	assert.Contains(t, m, "From: \"GFA\" <gfa@example.com>\n")
+	assert.Contains(t, m, "To: <jean@example.com>\n")
+	assert.Contains(t, m, "Subject: =?utf-8?q?")
+	assert.NotContains(t, m, "\nBcc:")
+	assert.Contains(t, m, "line 1\nline 2\n")
+
+	s.Address = "127.0.0.1:1"
+	assert.ErrorContains(t, s.Send("jean@example.com", "subject", "body"), "error sending email")
+}
@@ -0,0 +1,173 @@
+package main
+
+import (
+	"crypto/sha256"
+	"embed"
+	"encoding/hex"
+	"errors"
+	"io"
+	"io/fs"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// reserved path of static assets, on every served host
+const staticPrefix = "/_gfa/static/"
+
+// cache delay of assets requested with their current hash, which change with content
+const staticImmutableMaxAge = "31536000"
+
+// default assets, overridden by files of StaticDir
+//
+//go:embed static
+var embeddedStatic embed.FS
+
+var ErrAssetNotFound = errors.New("static: asset not found")
+
+// content hash of an asset, recomputed when the file changes
+type assetHash struct {
+	hash    string
+	modTime time.Time
+	size    int64
+}
+
+// assets of StaticDir and embedded defaults
+type StaticAssets struct {
+	mu     sync.Mutex
+	hashes map[string]assetHash
+}
+
+var staticAssets = &StaticAssets{}
+
+// asset file, readable by http.ServeContent
+type assetFile interface {
+	fs.File
+	io.Seeker
+}
+
+// open asset from StaticDir, or from embedded defaults
+func (s *StaticAssets) Open(name string) (assetFile, fs.FileInfo, error) {
+	if !fs.ValidPath(name) || name == "." {
+		return nil, nil, ErrAssetNotFound
+	}
+	// hidden files are never served
+	for _, part := range strings.Split(name, "/") {
+		if strings.HasPrefix(part, ".") {
+			return nil, nil, ErrAssetNotFound
+		}
+	}
+
+	var f fs.File
+	var err error
+	if configuration.StaticDir != "" {
+		f, err = os.DirFS(configuration.StaticDir).Open(name)
+	}
+	if f == nil {
+		if err != nil && !errors.Is(err, fs.ErrNotExist) {
+			log.Error("static: error opening asset", zap.String("name", name), zap.Error(err))
+		}
+		if f, err = embeddedStatic.Open("static/" + name); err != nil {
+			return nil, nil, ErrAssetNotFound
+		}
+	}
+	fi, err := f.Stat()
+	if err != nil || fi.IsDir() {
+		f.Close()
+		return nil, nil, ErrAssetNotFound
+	}
+	af, ok := f.(assetFile)
+	if !ok {
+		f.Close()
+		return nil, nil, ErrAssetNotFound
+	}
+	return af, fi, nil
+}
+
+// return content hash of opened asset, and rewind it
+func (s *StaticAssets) hash(name string, f assetFile, fi fs.FileInfo) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.hashes == nil {
+		s.hashes = map[string]assetHash{}
+	}
+	if h, ok := s.hashes[name]; ok && h.modTime.Equal(fi.ModTime()) && h.size == fi.Size() {
+		return h.hash, nil
+	}
+	sum := sha256.New()
+	if _, err := io.Copy(sum, f); err != nil {
+		return "", errors.New("static: error reading " + name + "\n\t-> " + err.Error())
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return "", errors.New("static: error reading " + name + "\n\t-> " + err.Error())
+	}
+	h := hex.EncodeToString(sum.Sum(nil))[:16]
+	s.hashes[name] = assetHash{hash: h, modTime: fi.ModTime(), size: fi.Size()}
+	return h, nil
+}
+
+// return url of asset with its content hash, for templates
+// absolute if PublicUrl is set, as login pages are also shown on protected hosts
+func (s *StaticAssets) Url(name string) string {
+	url := configuration.PublicUrl + staticPrefix + name
+	f, fi, err := s.Open(name)
+	if err != nil {
+		log.Error("static: unknown asset in template", zap.String("name", name))
+		return url
+	}
+	defer f.Close()
+	h, err := s.hash(name, f, fi)
+	if err != nil {
+		log.Error("static: error hashing asset", zap.Error(err))
+		return url
+	}
+	return url + "?v=" + h
+}
+
+// serve static assets, cached forever when requested with their current hash
+func StaticHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+	name := strings.TrimPrefix(r.URL.Path, staticPrefix)
+	f, fi, err := staticAssets.Open(name)
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	defer f.Close()
+	h, err := staticAssets.hash(name, f, fi)
+	if err != nil {
+		log.Error("static: error hashing asset", zap.Error(err))
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("ETag", `"`+h+`"`)
+	if r.URL.Query().Get("v") == h {
+		w.Header().Set("Cache-Control", "public, max-age="+staticImmutableMaxAge+", immutable")
+	} else {
+		w.Header().Set("Cache-Control", "public, no-cache")
+	}
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	// assets are public, fonts loaded from protected hosts need cors
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	http.ServeContent(w, r, fi.Name(), fi.ModTime(), f)
+}
+
+// static assets are public and cacheable, they skip csrf protection which sets cookies
+func SkipCsrfForStatic(router, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasPrefix(r.URL.Path, staticPrefix) {
+			router.ServeHTTP(w, r)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStaticAssetsOpen(t *testing.T) {
+	testCases := []struct {
+		Name  string
+		Asset string
+		Found bool
+	}{
+		{"EMBEDDED", "gfa.css", true},
+		{"BINARY", "favicon.ico", true},
+		{"MISSING", "nope.css", false},
+		{"ROOT", "", false},
+		{"TRAVERSAL", "../main.go", false},
+		{"ABSOLUTE", "/etc/passwd", false},
+		{"HIDDEN", ".secret", false},
+	}
+	for _, tc := range testCases {
+		// shadow the test case to avoid modifying the test case
+		tc := tc
+		t.Run(tc.Name, func(t *testing.T) {
+			t.Parallel()
+			f, fi, err := staticAssets.Open(tc.Asset)
+			if !tc.Found {
+				assert.ErrorIs(t, err, ErrAssetNotFound)
+				return
+			}
+			if assert.NoError(t, err) {
+				assert.NotZero(t, fi.Size())
+				f.Close()
+			}
+		})
+	}
+}
+
+func TestStaticDir(t *testing.T) {
+	backup := configuration.StaticDir
+	defer func() { configuration.StaticDir = backup }()
+	configuration.StaticDir = t.TempDir()
+	assert.NoError(t, os.WriteFile(filepath.Join(configuration.StaticDir, "gfa.css"), []byte("body{}"), 0600))
+	assert.NoError(t, os.WriteFile(filepath.Join(configuration.StaticDir, "logo.svg"), []byte("<svg></svg>"), 0600))
+	assert.NoError(t, os.Mkdir(filepath.Join(configuration.StaticDir, "fonts"), 0700))
+	assets := &StaticAssets{}
+
+	// StaticDir overrides embedded assets
+	f, fi, err := assets.Open("gfa.css")
+	if assert.NoError(t, err) {
+		b, _ := io.ReadAll(f)
+		assert.Equal(t, "body{}", string(b))
+		assert.Equal(t, int64(6), fi.Size())
+		f.Close()
+	}
+	_, _, err = assets.Open("fonts")
+	assert.ErrorIs(t, err, ErrAssetNotFound)
+	// embedded assets are still served
+	f, _, err = assets.Open("favicon.ico")
+	if assert.NoError(t, err) {
+		f.Close()
+	}
+
+	// hash changes with content
+	first := assets.Url("logo.svg")
+	assert.Regexp(t, `^`+configuration.PublicUrl+`/_gfa/static/logo\.svg\?v=[0-9a-f]{16}$`, first)
+	assert.Equal(t, first, assets.Url("logo.svg"))
+	assert.NoError(t, os.WriteFile(filepath.Join(configuration.StaticDir, "logo.svg"), []byte("<svg><g></g></svg>"), 0600))
+	assert.NoError(t, os.Chtimes(filepath.Join(configuration.StaticDir, "logo.svg"), time.Now(), time.Now().Add(time.Minute)))
+	assert.NotEqual(t, first, assets.Url("logo.svg"))
+
+	// unknown assets have no hash
+	assert.Equal(t, configuration.PublicUrl+"/_gfa/static/nope.js", assets.Url("nope.js"))
+}
+
+func TestStaticHandler(t *testing.T) {
+	url := staticAssets.Url("gfa.css")
+	version := url[strings.Index(url, "?v=")+3:]
+
+	testCases := []struct {
+		Name                 string
+		Method               string
+		Path                 string
+		Header               http.Header
+		ExpectedHttpCode     int
+		ExpectedCacheControl string
+	}{
+		{"HASHED", "GET", "/_gfa/static/gfa.css?v=" + version, nil, http.StatusOK, "public, max-age=31536000, immutable"},
+		{"OLD_HASH", "GET", "/_gfa/static/gfa.css?v=0000", nil, http.StatusOK, "public, no-cache"},
+		{"NO_HASH", "HEAD", "/_gfa/static/gfa.css", nil, http.StatusOK, "public, no-cache"},
+		{"NOT_MODIFIED", "GET", "/_gfa/static/gfa.css", http.Header{"If-None-Match": []string{`"` + version + `"`}}, http.StatusNotModified, "public, no-cache"},
+		{"MISSING", "GET", "/_gfa/static/nope.css", nil, http.StatusNotFound, ""},
+		{"DIRECTORY", "GET", "/_gfa/static/", nil, http.StatusNotFound, ""},
+		{"POST", "POST", "/_gfa/static/gfa.css", nil, http.StatusMethodNotAllowed, ""},
+	}
+	for _, tc := range testCases {
+		// shadow the test case to avoid modifying the test case
+		tc := tc
+		t.Run(tc.Name, func(t *testing.T) {
+			t.Parallel()
+			req := httptest.NewRequest(tc.Method, tc.Path, nil)
+			for k, v := range tc.Header {
+				req.Header[k] = v
+			}
+			w := httptest.NewRecorder()
+			NewRouter(nil).ServeHTTP(w, req)
+			resp := w.Result()
+			assert.Equal(t, tc.ExpectedHttpCode, resp.StatusCode)
+			assert.Equal(t, tc.ExpectedCacheControl, resp.Header.Get("Cache-Control"))
+			if tc.ExpectedHttpCode == http.StatusOK {
+				assert.Equal(t, `"`+version+`"`, resp.Header.Get("ETag"))
+				assert.Contains(t, resp.Header.Get("Content-Type"), "text/css")
+			}
+		})
+	}
+}
+
+func TestSkipCsrfForStatic(t *testing.T) {
+	router := NewRouter(nil)
+	protected := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusTeapot)
+	})
+	h := SkipCsrfForStatic(router, protected)
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "/_gfa/static/gfa.css", nil))
+	assert.Equal(t, http.StatusOK, w.Result().StatusCode)
+
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "/health", nil))
+	assert.Equal(t, http.StatusTeapot, w.Result().StatusCode)
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"errors"
+	"strings"
+
+	"go.uber.org/zap"
+)
+
+var ErrReadOnlyStore = errors.New("user: store is read-only")
+
+// UserStore gives access to users, whatever the backend
+type UserStore interface {
+	// return user, nil if not found
+	GetUser(username string) *User
+}
+
+// PasswordStore is a UserStore able to persist password hashes
+type PasswordStore interface {
+	UserStore
+	SetPassword(username, hash string) error
+}
+
+// users defined in configuration files, read-only
+// returned users are copies, configuration is shared by concurrent requests
+type ConfigUserStore map[string]*User
+
+func (s ConfigUserStore) GetUser(username string) *User {
+	if len(s) == 0 {
+		log.Info("user: no user configured")
+		return nil
+	}
+	u, ok := s[username]
+	if !ok || u == nil {
+		return nil
+	}
+	c := *u
+	c.Username = username
+	return &c
+}
+
+// EmailStore is a UserStore able to find users from their email
+type EmailStore interface {
+	UserStore
+	GetUserByEmail(email string) *User
+}
+
+// TokenStore is a UserStore able to find users from their API token hash
+type TokenStore interface {
+	UserStore
+	GetUserFromTokenHash(hash string) (*User, *ApiToken)
+}
+
+// return user having email (case insensitive), nil if none
+func (s ConfigUserStore) GetUserByEmail(email string) *User {
+	for username, u := range s {
+		if u != nil && u.Email != "" && strings.EqualFold(u.Email, email) {
+			c := *u
+			c.Username = username
+			return &c
+		}
+	}
+	return nil
+}
+
+// return stores in lookup order, configured users first
+func (c *Config) GetUserStores() []UserStore {
+	stores := []UserStore{ConfigUserStore(c.Users)}
+	if c.db != nil {
+		stores = append(stores, c.db)
+	}
+	for _, h := range c.HtpasswdFiles {
+		stores = append(stores, h)
+	}
+	// magic link users last, so they never shadow an account
+	if c.MagicLink != nil {
+		stores = append(stores, c.MagicLink)
+	}
+	return stores
+}
+
+// find active user in stores, first match wins
+func GetUser(username string) *User {
+	u := lookupUser(username)
+	if u == nil {
+		return nil
+	}
+	if err := u.Active(); err != nil {
+		log.Info("user: inactive", zap.String("username", username), zap.Error(err))
+		return nil
+	}
+	return u
+}
+
+// find user in stores, first match wins, whatever its status
+func lookupUser(username string) *User {
+	for _, s := range configuration.GetUserStores() {
+		if u := s.GetUser(username); u != nil {
+			u.store = s
+			return u
+		}
+	}
+	return nil
+}
+
+// find active user by email in stores, first match wins
+func GetUserByEmail(email string) *User {
+	if email == "" {
+		return nil
+	}
+	for _, s := range configuration.GetUserStores() {
+		es, ok := s.(EmailStore)
+		if !ok {
+			continue
+		}
+		if u := es.GetUserByEmail(email); u != nil {
+			if err := u.Active(); err != nil {
+				log.Info("user: inactive", zap.String("username", u.Username), zap.Error(err))
+				return nil
+			}
+			u.store = s
+			return u
+		}
+	}
+	return nil
+}
+
+// replace user password hash in its store, if writable
+func (u *User) SetPassword(hash string) error {
+	ps, ok := u.store.(PasswordStore)
+	if !ok {
+		return ErrReadOnlyStore
+	}
+	if err := ps.SetPassword(u.Username, hash); err != nil {
+		return err
+	}
+	basicAuthCache.Forget(u.Username)
+	u.Password = hash
+	log.Info("user: password updated", zap.String("username", u.Username))
+	return nil
+}
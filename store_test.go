@@ -0,0 +1,99 @@
+package main
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// in memory writable store
+type testPasswordStore struct {
+	users map[string]*User
+	err   error
+}
+
+func (s *testPasswordStore) GetUser(username string) *User {
+	u, ok := s.users[username]
+	if !ok {
+		return nil
+	}
+	u.Username = username
+	return u
+}
+
+func (s *testPasswordStore) SetPassword(username, hash string) error {
+	if s.err != nil {
+		return s.err
+	}
+	s.users[username].Password = hash
+	return nil
+}
+
+func TestConfigUserStore(t *testing.T) {
+	s := ConfigUserStore(configuration.Users)
+	u := s.GetUser("jean")
+	if assert.NotNil(t, u) {
+		assert.Equal(t, "jean", u.Username)
+		// copy, configuration is never modified by requests
+		assert.NotSame(t, configuration.Users["jean"], u)
+	}
+	assert.Nil(t, s.GetUser("nope"))
+	assert.Nil(t, ConfigUserStore(nil).GetUser("jean"))
+	assert.Nil(t, ConfigUserStore{"nil": nil}.GetUser("nil"))
+}
+
+func TestGetUserStores(t *testing.T) {
+	stores := configuration.GetUserStores()
+	if assert.Len(t, stores, 1) {
+		assert.IsType(t, ConfigUserStore{}, stores[0])
+	}
+
+	c := &Config{Users: configuration.Users, HtpasswdFiles: []*HtpasswdFile{{Path: "a"}, {Path: "b"}}, db: &SqlStore{}}
+	stores = c.GetUserStores()
+	if assert.Len(t, stores, 4) {
+		assert.IsType(t, ConfigUserStore{}, stores[0])
+		assert.Equal(t, c.db, stores[1])
+		assert.Equal(t, c.HtpasswdFiles[1], stores[3])
+	}
+}
+
+func TestUserSetPassword(t *testing.T) {
+	// read-only store
+	u := &User{Username: "jean", store: ConfigUserStore{}}
+	assert.ErrorIs(t, u.SetPassword("hash"), ErrReadOnlyStore)
+	assert.Empty(t, u.Password)
+
+	// writable store
+	s := &testPasswordStore{users: map[string]*User{"jean": {Password: "old"}}}
+	u = s.GetUser("jean")
+	u.store = s
+	basicAuthCache.Set("jean", "old", "url.net", time.Hour)
+	assert.NoError(t, u.SetPassword("new"))
+	assert.Equal(t, "new", s.users["jean"].Password)
+	// old password no longer accepted from cache
+	assert.False(t, basicAuthCache.Get("jean", "old", "url.net"))
+
+	// store error
+	s.err = errors.New("disk full")
+	assert.ErrorContains(t, u.SetPassword("other"), "disk full")
+	assert.Equal(t, "new", u.Password)
+}
+
+func TestGetUserByEmail(t *testing.T) {
+	backup := configuration.db
+	defer func() { configuration.db = backup }()
+	s := newTestSqlStore(t)
+	configuration.db = s
+	assert.NoError(t, s.CreateUser(&User{Username: "bob", Password: "hash", Email: "bob@example.com"}))
+	assert.NoError(t, s.CreateUser(&User{Username: "alice", Password: "hash", Email: "alice@example.com", Disabled: true}))
+
+	assert.Nil(t, GetUserByEmail(""))
+	assert.Nil(t, GetUserByEmail("nope@example.com"))
+	assert.Nil(t, GetUserByEmail("alice@example.com"))
+	if u := GetUserByEmail("BOB@example.com"); assert.NotNil(t, u) {
+		assert.Equal(t, "bob", u.Username)
+		assert.Equal(t, s, u.store)
+	}
+}
@@ -0,0 +1,196 @@
+package main
+
+import (
+	"errors"
+	"html/template"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// delay between two checks of template files
+const templateCheckInterval = 2 * time.Second
+
+// css colors accepted in branding : #rgb, #rrggbb, #rrggbbaa or a color name
+var brandingColor = regexp.MustCompile(`^(#[0-9a-fA-F]{3,8}|[a-zA-Z]+)$`)
+
+// branding of login pages, empty values are inherited from default branding
+type Branding struct {
+	Title           string `koanf:"Title"`
+	LogoUrl         string `koanf:"LogoUrl"`
+	PrimaryColor    string `koanf:"PrimaryColor"`
+	BackgroundColor string `koanf:"BackgroundColor"`
+}
+
+var defaultBranding = Branding{
+	Title:           "Login",
+	PrimaryColor:    "#0d6efd",
+	BackgroundColor: "#000",
+}
+
+// login page of some domains
+type Site struct {
+	// patterns of domains, as AllowedDomains
+	Domains []string `koanf:"Domains"`
+	// template replacing HtmlFile (optional)
+	HtmlFile string   `koanf:"HtmlFile"`
+	Branding Branding `koanf:"Branding"`
+}
+
+// parsed template file
+type cachedTemplate struct {
+	tpl       *template.Template
+	modTime   time.Time
+	checkedAt time.Time
+}
+
+// parsed templates by path, reparsed when files change
+type TemplateCache struct {
+	mu      sync.Mutex
+	entries map[string]*cachedTemplate
+}
+
+var templates = &TemplateCache{}
+
+// validate colors
+func (b *Branding) Valid() error {
+	for name, c := range map[string]string{"PrimaryColor": b.PrimaryColor, "BackgroundColor": b.BackgroundColor} {
+		if c != "" && !brandingColor.MatchString(c) {
+			return errors.New("template: bad " + name + " '" + c + "', expected #rrggbb or a color name")
+		}
+	}
+	return nil
+}
+
+// return branding with empty values taken from parent
+func (b Branding) Inherit(parent Branding) Branding {
+	if b.Title == "" {
+		b.Title = parent.Title
+	}
+	if b.LogoUrl == "" {
+		b.LogoUrl = parent.LogoUrl
+	}
+	if b.PrimaryColor == "" {
+		b.PrimaryColor = parent.PrimaryColor
+	}
+	if b.BackgroundColor == "" {
+		b.BackgroundColor = parent.BackgroundColor
+	}
+	return b
+}
+
+// validate site and compile its domains
+func (s *Site) Valid() error {
+	if len(s.Domains) == 0 {
+		return errors.New("template: missing Domains")
+	}
+	for _, d := range s.Domains {
+		if _, err := CompileDomain(d); err != nil {
+			return errors.New("template: bad Domains\n\t-> " + err.Error())
+		}
+	}
+	if s.HtmlFile != "" {
+		if _, err := templates.get(s.HtmlFile); err != nil {
+			return err
+		}
+	}
+	return s.Branding.Valid()
+}
+
+// return first site matching host, nil if none
+func (c *Config) GetSite(host string) *Site {
+	for _, s := range c.Sites {
+		if CompareDomains(s.Domains, host) {
+			return s
+		}
+	}
+	return nil
+}
+
+// functions available in templates, translating messages in lang
+func templateFuncs(lang string) template.FuncMap {
+	return template.FuncMap{
+		"t": func(msg string, args ...interface{}) string {
+			return Translate(lang, msg, args...)
+		},
+		"asset": staticAssets.Url,
+	}
+}
+
+// return template of file translated in lang, reparsed if file changed since last call
+// if the new file is invalid, the previous template is kept
+func (c *TemplateCache) Get(path, lang string) (*template.Template, error) {
+	tpl, err := c.get(path)
+	if err != nil {
+		return nil, err
+	}
+	// cached templates are never executed, so that they can be cloned
+	if tpl, err = tpl.Clone(); err != nil {
+		return nil, errors.New("template: error cloning " + path + "\n\t-> " + err.Error())
+	}
+	return tpl.Funcs(templateFuncs(lang)), nil
+}
+
+func (c *TemplateCache) get(path string) (*template.Template, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.entries == nil {
+		c.entries = map[string]*cachedTemplate{}
+	}
+	e := c.entries[path]
+	if e != nil && time.Since(e.checkedAt) < templateCheckInterval {
+		return e.tpl, nil
+	}
+
+	fi, err := os.Stat(path)
+	if err != nil {
+		if e != nil {
+			log.Error("template: error reading file, keeping previous one", zap.String("file", path), zap.Error(err))
+			e.checkedAt = time.Now()
+			return e.tpl, nil
+		}
+		return nil, errors.New("template: error reading file\n\t-> " + err.Error())
+	}
+	if e != nil && fi.ModTime().Equal(e.modTime) {
+		e.checkedAt = time.Now()
+		return e.tpl, nil
+	}
+
+	tpl, err := template.New(filepath.Base(path)).Funcs(templateFuncs(defaultLanguage)).ParseFiles(path)
+	if err != nil {
+		if e != nil {
+			log.Error("template: error parsing file, keeping previous one", zap.String("file", path), zap.Error(err))
+			// don't parse this version again
+			e.modTime = fi.ModTime()
+			e.checkedAt = time.Now()
+			return e.tpl, nil
+		}
+		return nil, errors.New("template: error parsing " + path + "\n\t-> " + err.Error())
+	}
+	if e != nil {
+		log.Info("template: file reloaded", zap.String("file", path))
+	}
+	c.entries[path] = &cachedTemplate{tpl: tpl, modTime: fi.ModTime(), checkedAt: time.Now()}
+	return tpl, nil
+}
+
+// return path of the login page template of host
+func (c *Config) HtmlFileOf(host string) string {
+	if s := c.GetSite(host); s != nil && s.HtmlFile != "" {
+		return s.HtmlFile
+	}
+	return c.HtmlFile
+}
+
+// return branding of host
+func (c *Config) BrandingOf(host string) Branding {
+	b := c.Branding.Inherit(defaultBranding)
+	if s := c.GetSite(host); s != nil {
+		b = s.Branding.Inherit(b)
+	}
+	return b
+}
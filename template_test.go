@@ -0,0 +1,134 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBranding(t *testing.T) {
+	testCases := []struct {
+		Name     string
+		Branding Branding
+		Valid    bool
+	}{
+		{Name: "EMPTY", Valid: true},
+		{Name: "HEX", Branding: Branding{PrimaryColor: "#ff8800", BackgroundColor: "#fff"}, Valid: true},
+		{Name: "NAME", Branding: Branding{PrimaryColor: "teal"}, Valid: true},
+		{Name: "BAD_HEX", Branding: Branding{PrimaryColor: "#ff"}, Valid: false},
+		{Name: "INJECTION", Branding: Branding{BackgroundColor: "red;}body{display:none"}, Valid: false},
+	}
+	for _, tc := range testCases {
+		// shadow the test case to avoid modifying the test case
+		tc := tc
+		t.Run(tc.Name, func(t *testing.T) {
+			t.Parallel()
+			err := tc.Branding.Valid()
+			if tc.Valid {
+				assert.NoError(t, err)
+			} else {
+				assert.Error(t, err)
+			}
+		})
+	}
+
+	b := Branding{Title: "Wiki"}.Inherit(Branding{Title: "Company", LogoUrl: "/logo.png"}).Inherit(defaultBranding)
+	assert.Equal(t, Branding{Title: "Wiki", LogoUrl: "/logo.png", PrimaryColor: defaultBranding.PrimaryColor, BackgroundColor: defaultBranding.BackgroundColor}, b)
+}
+
+func TestSites(t *testing.T) {
+	c := &Config{
+		HtmlFile: "default.index.html",
+		Branding: Branding{Title: "Company"},
+		Sites: []*Site{
+			{Domains: []string{"wiki.example.com"}, HtmlFile: "wiki.html", Branding: Branding{Title: "Wiki", PrimaryColor: "green"}},
+			{Domains: []string{"*.example.com"}, Branding: Branding{LogoUrl: "/logo.png"}},
+		},
+	}
+
+	testCases := []struct {
+		Name             string
+		Host             string
+		ExpectedSite     int
+		ExpectedHtmlFile string
+		ExpectedBranding Branding
+	}{
+		{"FIRST_MATCH", "wiki.example.com", 0, "wiki.html", Branding{Title: "Wiki", PrimaryColor: "green", BackgroundColor: defaultBranding.BackgroundColor}},
+		{"GLOB", "app.example.com", 1, "default.index.html", Branding{Title: "Company", LogoUrl: "/logo.png", PrimaryColor: defaultBranding.PrimaryColor, BackgroundColor: defaultBranding.BackgroundColor}},
+		{"NONE", "other.com", -1, "default.index.html", Branding{Title: "Company", PrimaryColor: defaultBranding.PrimaryColor, BackgroundColor: defaultBranding.BackgroundColor}},
+	}
+	for _, tc := range testCases {
+		// shadow the test case to avoid modifying the test case
+		tc := tc
+		t.Run(tc.Name, func(t *testing.T) {
+			t.Parallel()
+			if tc.ExpectedSite < 0 {
+				assert.Nil(t, c.GetSite(tc.Host))
+			} else {
+				assert.Same(t, c.Sites[tc.ExpectedSite], c.GetSite(tc.Host))
+			}
+			assert.Equal(t, tc.ExpectedHtmlFile, c.HtmlFileOf(tc.Host))
+			assert.Equal(t, tc.ExpectedBranding, c.BrandingOf(tc.Host))
+		})
+	}
+}
+
+func TestSiteValid(t *testing.T) {
+	assert.ErrorContains(t, (&Site{}).Valid(), "missing Domains")
+	assert.ErrorContains(t, (&Site{Domains: []string{"re:(a"}}).Valid(), "bad Domains")
+	assert.ErrorContains(t, (&Site{Domains: []string{"a.com"}, HtmlFile: "missing.html"}).Valid(), "error reading file")
+	assert.ErrorContains(t, (&Site{Domains: []string{"a.com"}, Branding: Branding{PrimaryColor: "#1"}}).Valid(), "bad PrimaryColor")
+	assert.NoError(t, (&Site{Domains: []string{"a.com"}, HtmlFile: "default.index.html"}).Valid())
+
+	c := &Config{Sites: []*Site{nil, {}}}
+	errs, _ := c.Valid(false).(ConfigErrors)
+	paths := []string{}
+	for _, e := range errs {
+		paths = append(paths, e.Path)
+	}
+	assert.Subset(t, paths, []string{"Sites[0]", "Sites[1]"})
+}
+
+func TestTemplateCache(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "page.html")
+	assert.NoError(t, os.WriteFile(path, []byte("v1 {{ .name }}"), 0600))
+
+	cache := &TemplateCache{}
+	_, err := cache.Get(filepath.Join(t.TempDir(), "missing.html"), "en")
+	assert.ErrorContains(t, err, "error reading file")
+
+	render := func() string {
+		tpl, err := cache.Get(path, "en")
+		if !assert.NoError(t, err) {
+			return ""
+		}
+		out := &bytes.Buffer{}
+		assert.NoError(t, tpl.Execute(out, map[string]string{"name": "gfa"}))
+		return out.String()
+	}
+	assert.Equal(t, "v1 gfa", render())
+
+	// not checked again before templateCheckInterval
+	assert.NoError(t, os.WriteFile(path, []byte("v2 {{ .name }}"), 0600))
+	assert.NoError(t, os.Chtimes(path, time.Now(), time.Now().Add(time.Minute)))
+	assert.Equal(t, "v1 gfa", render())
+
+	// reloaded when changed
+	cache.entries[path].checkedAt = time.Time{}
+	assert.Equal(t, "v2 gfa", render())
+
+	// previous template kept if new one is invalid
+	assert.NoError(t, os.WriteFile(path, []byte("v3 {{ .name "), 0600))
+	assert.NoError(t, os.Chtimes(path, time.Now(), time.Now().Add(2*time.Minute)))
+	cache.entries[path].checkedAt = time.Time{}
+	assert.Equal(t, "v2 gfa", render())
+
+	// previous template kept if file is removed
+	assert.NoError(t, os.Remove(path))
+	cache.entries[path].checkedAt = time.Time{}
+	assert.Equal(t, "v2 gfa", render())
+}
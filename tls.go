@@ -0,0 +1,183 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"os"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// delay between two checks of certificate files
+const certificateCheckInterval = 10 * time.Second
+
+// self-signed certificate is regenerated when expiring in less than this delay
+const selfSignedRenewBefore = time.Hour * 24 * 30
+
+// older versions are insecure, so not supported
+var tlsVersions = map[string]uint16{
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+// keep certificate in memory and reload it when files change
+type CertificateLoader struct {
+	CertificateFile string
+	PrivateKeyFile  string
+	SelfSigned      bool
+
+	mu          sync.RWMutex
+	certificate *tls.Certificate
+	notAfter    time.Time
+	certModTime time.Time
+	keyModTime  time.Time
+	checkedAt   time.Time
+}
+
+// create loader and load certificate a first time
+func NewCertificateLoader(certificateFile, privateKeyFile string, selfSigned bool) (*CertificateLoader, error) {
+	cl := &CertificateLoader{
+		CertificateFile: certificateFile,
+		PrivateKeyFile:  privateKeyFile,
+		SelfSigned:      selfSigned,
+	}
+	if err := cl.Load(); err != nil {
+		return nil, err
+	}
+	return cl, nil
+}
+
+// read key pair from files
+func (cl *CertificateLoader) Load() error {
+	certInfo, err := os.Stat(cl.CertificateFile)
+	if err != nil {
+		return errors.New("tls: error reading certificate\n\t-> " + err.Error())
+	}
+	keyInfo, err := os.Stat(cl.PrivateKeyFile)
+	if err != nil {
+		return errors.New("tls: error reading private key\n\t-> " + err.Error())
+	}
+	cert, err := tls.LoadX509KeyPair(cl.CertificateFile, cl.PrivateKeyFile)
+	if err != nil {
+		return errors.New("tls: bad key pair\n\t-> " + err.Error())
+	}
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		return errors.New("tls: bad certificate\n\t-> " + err.Error())
+	}
+
+	cl.mu.Lock()
+	defer cl.mu.Unlock()
+	cl.certificate = &cert
+	cl.notAfter = leaf.NotAfter
+	cl.certModTime = certInfo.ModTime()
+	cl.keyModTime = keyInfo.ModTime()
+	cl.checkedAt = time.Now()
+	return nil
+}
+
+// reload certificate if files changed, or renew it if self-signed and near expiration
+func (cl *CertificateLoader) Refresh() {
+	cl.mu.Lock()
+	if time.Since(cl.checkedAt) < certificateCheckInterval {
+		cl.mu.Unlock()
+		return
+	}
+	cl.checkedAt = time.Now()
+	notAfter, certModTime, keyModTime := cl.notAfter, cl.certModTime, cl.keyModTime
+	cl.mu.Unlock()
+
+	if cl.SelfSigned && time.Until(notAfter) < selfSignedRenewBefore {
+		log.Info("tls: renewing self-signed certificate", zap.Time("expire", notAfter))
+		// keep serving the current certificate, retried on next check
+		if err := RenewKeyPair(2048, cl.PrivateKeyFile, cl.CertificateFile); err != nil {
+			log.Error("tls: error renewing self-signed certificate", zap.Time("expire", notAfter), zap.Error(err))
+			return
+		}
+	} else {
+		certInfo, errCert := os.Stat(cl.CertificateFile)
+		keyInfo, errKey := os.Stat(cl.PrivateKeyFile)
+		if errCert != nil || errKey != nil || (certInfo.ModTime().Equal(certModTime) && keyInfo.ModTime().Equal(keyModTime)) {
+			return
+		}
+	}
+
+	// keep serving the previous certificate if new one is invalid
+	if err := cl.Load(); err != nil {
+		log.Error("tls: error reloading certificate", zap.Error(err))
+		return
+	}
+	log.Info("tls: certificate reloaded", zap.String("file", cl.CertificateFile))
+}
+
+// callback used by tls.Config, handshake fails once a self-signed certificate expired without renewal
+func (cl *CertificateLoader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	cl.Refresh()
+	cl.mu.RLock()
+	defer cl.mu.RUnlock()
+	if cl.SelfSigned && time.Now().After(cl.notAfter) {
+		return nil, errors.New("tls: self-signed certificate expired and could not be renewed")
+	}
+	return cl.certificate, nil
+}
+
+// return tls version from its name ("1.2", "1.3", ...)
+func ParseTlsVersion(v string) (uint16, error) {
+	version, ok := tlsVersions[v]
+	if !ok {
+		return 0, errors.New("tls: unknown version '" + v + "'")
+	}
+	return version, nil
+}
+
+// return cipher suites ids from their names, only secure ones are allowed
+func ParseCipherSuites(names []string) ([]uint16, error) {
+	if len(names) == 0 {
+		return nil, nil
+	}
+	ids := make([]uint16, 0, len(names))
+	for _, n := range names {
+		found := false
+		for _, cs := range tls.CipherSuites() {
+			if cs.Name == n {
+				ids = append(ids, cs.ID)
+				found = true
+				break
+			}
+		}
+		if !found {
+			return nil, errors.New("tls: unknown or insecure cipher suite '" + n + "'")
+		}
+	}
+	return ids, nil
+}
+
+// create tls configuration from global configuration
+func NewTlsConfig() (*tls.Config, error) {
+	minVersion, err := ParseTlsVersion(configuration.TlsMinVersion)
+	if err != nil {
+		return nil, err
+	}
+	cipherSuites, err := ParseCipherSuites(configuration.TlsCipherSuites)
+	if err != nil {
+		return nil, err
+	}
+	cl, err := NewCertificateLoader(configuration.Certificate, configuration.PrivateKey, configuration.selfSigned)
+	if err != nil {
+		return nil, err
+	}
+	tc := &tls.Config{
+		MinVersion:     minVersion,
+		CipherSuites:   cipherSuites,
+		GetCertificate: cl.GetCertificate,
+	}
+	// ask for client certificate, but keep it optional for browsers without one
+	if configuration.ClientCert != nil {
+		tc.ClientAuth = tls.VerifyClientCertIfGiven
+		tc.ClientCAs = configuration.ClientCert.pool
+	}
+	return tc, nil
+}
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseTlsVersion(t *testing.T) {
+	testCases := []struct {
+		name            string
+		version         string
+		expectedVersion uint16
+		expectedError   bool
+	}{
+		{"TLS12", "1.2", tls.VersionTLS12, false},
+		{"TLS13", "1.3", tls.VersionTLS13, false},
+		{"EMPTY", "", 0, true},
+		{"UNKNOWN", "2.0", 0, true},
+		{"INSECURE", "1.1", 0, true},
+	}
+	for _, tc := range testCases {
+		// shadow the test case to avoid modifying the test case
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			v, err := ParseTlsVersion(tc.version)
+			assert.Equal(t, tc.expectedVersion, v)
+			assert.Equal(t, tc.expectedError, err != nil)
+		})
+	}
+}
+
+func TestParseCipherSuites(t *testing.T) {
+	ids, err := ParseCipherSuites(nil)
+	assert.NoError(t, err)
+	assert.Nil(t, ids)
+
+	ids, err = ParseCipherSuites([]string{"TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256", "TLS_ECDHE_RSA_WITH_CHACHA20_POLY1305_SHA256"})
+	assert.NoError(t, err)
+	assert.Equal(t, []uint16{tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256, tls.TLS_ECDHE_RSA_WITH_CHACHA20_POLY1305_SHA256}, ids)
+
+	_, err = ParseCipherSuites([]string{"TLS_RSA_WITH_RC4_128_SHA"})
+	assert.ErrorContains(t, err, "insecure")
+}
+
+// return the serial number of the certificate served by loader
+func getServedSerial(t *testing.T, cl *CertificateLoader) string {
+	cert, err := cl.GetCertificate(nil)
+	if !assert.NoError(t, err) || !assert.NotNil(t, cert) {
+		t.FailNow()
+	}
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	assert.NoError(t, err)
+	return leaf.SerialNumber.String()
+}
+
+func TestCertificateLoader(t *testing.T) {
+	dir := t.TempDir()
+	key := filepath.Join(dir, "server.key")
+	cert := filepath.Join(dir, "server.crt")
+
+	_, err := NewCertificateLoader(cert, key, false)
+	assert.ErrorContains(t, err, "error reading certificate")
+
+	assert.NoError(t, GenerateKeyPair(2048, key, cert))
+	cl, err := NewCertificateLoader(cert, key, false)
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	first := getServedSerial(t, cl)
+
+	// files changed, but not checked yet
+	time.Sleep(10 * time.Millisecond)
+	assert.NoError(t, RenewKeyPair(2048, key, cert))
+	assert.Equal(t, first, getServedSerial(t, cl))
+
+	// files changed and check delay expired
+	cl.checkedAt = time.Time{}
+	second := getServedSerial(t, cl)
+	assert.NotEqual(t, first, second)
+
+	// unchanged files are not reloaded
+	cl.checkedAt = time.Time{}
+	assert.Equal(t, second, getServedSerial(t, cl))
+}
+
+func TestCertificateLoaderRenewSelfSigned(t *testing.T) {
+	dir := t.TempDir()
+	key := filepath.Join(dir, "server.key")
+	cert := filepath.Join(dir, "server.crt")
+
+	// nearly expired self-signed certificate
+	assert.NoError(t, generateKeyPair(2048, key, cert, 24*time.Hour))
+	cl, err := NewCertificateLoader(cert, key, true)
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	assert.WithinDuration(t, time.Now().Add(24*time.Hour), cl.notAfter, time.Minute)
+
+	cl.checkedAt = time.Time{}
+	cl.GetCertificate(nil)
+	assert.WithinDuration(t, time.Now().Add(selfSignedValidity), cl.notAfter, time.Minute)
+
+	// renewal error keeps serving the certificate until it expires
+	before, err := os.ReadFile(cert)
+	assert.NoError(t, err)
+	served := getServedSerial(t, cl)
+	cl.notAfter = time.Now().Add(time.Hour)
+	cl.PrivateKeyFile = filepath.Join(dir, "nope", "server.key")
+	cl.checkedAt = time.Time{}
+	assert.Equal(t, served, getServedSerial(t, cl))
+	// files are left untouched
+	content, err := os.ReadFile(cert)
+	assert.NoError(t, err)
+	assert.Equal(t, before, content)
+	assert.NoFileExists(t, cert+".tmp")
+	cl.notAfter = time.Now().Add(-time.Minute)
+	cl.checkedAt = time.Time{}
+	c, err := cl.GetCertificate(nil)
+	assert.Nil(t, c)
+	assert.ErrorContains(t, err, "expired")
+
+	// provided certificates are never renewed
+	assert.NoError(t, generateKeyPair(2048, key+".2", cert+".2", 24*time.Hour))
+	cl, err = NewCertificateLoader(cert+".2", key+".2", false)
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	cl.checkedAt = time.Time{}
+	cl.GetCertificate(nil)
+	assert.WithinDuration(t, time.Now().Add(24*time.Hour), cl.notAfter, time.Minute)
+}
+
+func TestNewTlsConfig(t *testing.T) {
+	backup := *configuration
+	defer func() { *configuration = backup }()
+
+	dir := t.TempDir()
+	configuration.PrivateKey = filepath.Join(dir, "server.key")
+	configuration.Certificate = filepath.Join(dir, "server.crt")
+	configuration.TlsMinVersion = "1.2"
+	assert.NoError(t, GenerateKeyPair(2048, configuration.PrivateKey, configuration.Certificate))
+
+	tc, err := NewTlsConfig()
+	if assert.NoError(t, err) {
+		assert.Equal(t, uint16(tls.VersionTLS12), tc.MinVersion)
+		assert.NotNil(t, tc.GetCertificate)
+	}
+
+	configuration.TlsMinVersion = "nope"
+	_, err = NewTlsConfig()
+	assert.ErrorContains(t, err, "unknown version")
+
+	configuration.TlsMinVersion = "1.3"
+	configuration.TlsCipherSuites = []string{"nope"}
+	_, err = NewTlsConfig()
+	assert.ErrorContains(t, err, "cipher suite")
+
+	configuration.TlsCipherSuites = nil
+	configuration.Certificate = "nope"
+	_, err = NewTlsConfig()
+	assert.ErrorContains(t, err, "certificate")
+}
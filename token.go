@@ -0,0 +1,127 @@
+package main
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"net/http"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+type ApiToken struct {
+	Name           string
+	Hash           string    `koanf:"Hash"`
+	AllowedDomains []string  `koanf:"AllowedDomains"`
+	ExpiresAt      time.Time `koanf:"ExpiresAt"`
+	Revoked        bool      `koanf:"Revoked"`
+	// admin tokens are only accepted by the admin API
+	Admin bool `koanf:"Admin"`
+}
+
+// prefix of generated tokens, helps secret scanners
+const apiTokenPrefix = "gfa_"
+
+// generate a new random token, and return it with its hash
+func GenerateApiToken() (token, hash string) {
+	token = apiTokenPrefix + base64.RawURLEncoding.EncodeToString(*GenerateRandomBytes(32))
+	return token, HashApiToken(token)
+}
+
+// return hash of token as stored in configuration
+// tokens are random and long, so a fast hash is enough
+func HashApiToken(token string) string {
+	h := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(h[:])
+}
+
+// return bearer token from Authorization header, empty if none
+func GetBearerToken(r *http.Request) string {
+	h := r.Header.Get("Authorization")
+	if len(h) < 7 || !strings.EqualFold(h[:7], "bearer ") {
+		return ""
+	}
+	return strings.TrimSpace(h[7:])
+}
+
+// check if token is usable for url
+func (t *ApiToken) Valid(url string) bool {
+	switch {
+	case t.Revoked:
+		log.Error("token: revoked", zap.String("name", t.Name))
+		return false
+	case !t.ExpiresAt.IsZero() && time.Now().After(t.ExpiresAt):
+		log.Error("token: expired", zap.String("name", t.Name))
+		return false
+	case len(t.AllowedDomains) > 0 && !CompareDomains(t.AllowedDomains, url):
+		log.Error("token: not allowed", zap.String("name", t.Name), zap.String("url", url))
+		return false
+	}
+	return true
+}
+
+// find active user and token from token value
+func GetUserFromToken(token string) (*User, *ApiToken) {
+	u, t := findToken(token)
+	if u == nil {
+		return nil, nil
+	}
+	if err := u.Active(); err != nil {
+		log.Info("user: inactive", zap.String("username", u.Username), zap.Error(err))
+		return nil, nil
+	}
+	return u, t
+}
+
+// find user and token in configuration, then in stores supporting tokens
+func findToken(token string) (*User, *ApiToken) {
+	if token == "" {
+		return nil, nil
+	}
+	hash := HashApiToken(token)
+	// copies, configuration is shared by concurrent requests
+	for username, u := range configuration.Users {
+		for name, t := range u.Tokens {
+			if subtle.ConstantTimeCompare([]byte(hash), []byte(t.Hash)) == 1 {
+				cu, ct := *u, *t
+				cu.Username = username
+				ct.Name = name
+				return &cu, &ct
+			}
+		}
+	}
+	for _, s := range configuration.GetUserStores() {
+		if ts, ok := s.(TokenStore); ok {
+			if u, t := ts.GetUserFromTokenHash(hash); u != nil {
+				u.store = s
+				return u, t
+			}
+		}
+	}
+	return nil, nil
+}
+
+// return user owning the bearer token of the request, nil if none or not allowed
+func GetValidUserFromToken(r *http.Request, url string) *User {
+	token := GetBearerToken(r)
+	if token == "" {
+		return nil
+	}
+	u, t := GetUserFromToken(token)
+	if u == nil {
+		log.Error("token: not found")
+		return nil
+	}
+	if t.Admin {
+		log.Error("token: admin token used outside admin API", zap.String("username", u.Username), zap.String("token", t.Name))
+		return nil
+	}
+	if !t.Valid(url) || !u.Allowed(url) {
+		return nil
+	}
+	log.Info("token: user authenticated", zap.String("username", u.Username), zap.String("token", t.Name))
+	return u
+}
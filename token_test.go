@@ -0,0 +1,137 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGenerateApiToken(t *testing.T) {
+	token, hash := GenerateApiToken()
+	assert.True(t, strings.HasPrefix(token, apiTokenPrefix))
+	assert.Greater(t, len(token), 40)
+	assert.Equal(t, HashApiToken(token), hash)
+
+	other, otherHash := GenerateApiToken()
+	assert.NotEqual(t, token, other)
+	assert.NotEqual(t, hash, otherHash)
+}
+
+func TestGetBearerToken(t *testing.T) {
+	testCases := []struct {
+		name     string
+		header   string
+		expected string
+	}{
+		{"NOMINAL", "Bearer gfa_test_token", "gfa_test_token"},
+		{"LOWERCASE", "bearer gfa_test_token ", "gfa_test_token"},
+		{"BASIC", "Basic dXNlcjpwYXNz", ""},
+		{"SHORT", "Bear", ""},
+		{"EMPTY", "", ""},
+	}
+	for _, tc := range testCases {
+		// shadow the test case to avoid modifying the test case
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			req := httptest.NewRequest("GET", "/verify", nil)
+			req.Header.Set("Authorization", tc.header)
+			assert.Equal(t, tc.expected, GetBearerToken(req))
+		})
+	}
+}
+
+func TestApiTokenValid(t *testing.T) {
+	testCases := []struct {
+		name     string
+		token    *ApiToken
+		url      string
+		expected bool
+	}{
+		{"NOMINAL", &ApiToken{}, "url.net", true},
+		{"NOT_EXPIRED", &ApiToken{ExpiresAt: time.Now().Add(time.Hour)}, "url.net", true},
+		{"EXPIRED", &ApiToken{ExpiresAt: time.Now().Add(-time.Hour)}, "url.net", false},
+		{"REVOKED", &ApiToken{Revoked: true}, "url.net", false},
+		{"ALLOWED", &ApiToken{AllowedDomains: []string{"url.net"}}, "url.net", true},
+		{"NOT_ALLOWED", &ApiToken{AllowedDomains: []string{"url.net"}}, "allowed.com", false},
+	}
+	for _, tc := range testCases {
+		// shadow the test case to avoid modifying the test case
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			assert.Equal(t, tc.expected, tc.token.Valid(tc.url))
+		})
+	}
+}
+
+func TestGetUserFromToken(t *testing.T) {
+	u, token := GetUserFromToken("gfa_test_token")
+	if assert.NotNil(t, u) && assert.NotNil(t, token) {
+		assert.Equal(t, "jean", u.Username)
+		assert.Equal(t, "ci", token.Name)
+		// copies, configuration is never modified by requests
+		assert.NotSame(t, configuration.Users["jean"], u)
+		assert.NotSame(t, configuration.Users["jean"].Tokens["ci"], token)
+	}
+	u, token = GetUserFromToken("gfa_unknown")
+	assert.Nil(t, u)
+	assert.Nil(t, token)
+	u, token = GetUserFromToken("")
+	assert.Nil(t, u)
+	assert.Nil(t, token)
+
+	// expiration is parsed from configuration
+	_, token = GetUserFromToken("gfa_test_expired")
+	if assert.NotNil(t, token) {
+		assert.Equal(t, time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC), token.ExpiresAt.UTC())
+	}
+}
+
+func TestGetValidUserFromToken(t *testing.T) {
+	testCases := []struct {
+		name             string
+		header           string
+		url              string
+		expectedUsername string
+	}{
+		{"NOMINAL", "Bearer gfa_test_token", "url.net", "jean"},
+		{"NOT_ALLOWED_USER", "Bearer gfa_test_token", "forbidden.net", ""},
+		{"RESTRICTED_ALLOWED", "Bearer gfa_test_restricted", "allowed.com", "jean"},
+		{"RESTRICTED_NOT_ALLOWED", "Bearer gfa_test_restricted", "url.net", ""},
+		{"EXPIRED", "Bearer gfa_test_expired", "url.net", ""},
+		{"REVOKED", "Bearer gfa_test_revoked", "url.net", ""},
+		{"UNKNOWN", "Bearer gfa_unknown", "url.net", ""},
+		{"NONE", "", "url.net", ""},
+	}
+	for _, tc := range testCases {
+		// shadow the test case to avoid modifying the test case
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			req := httptest.NewRequest("GET", "/verify", nil)
+			req.Header.Set("Authorization", tc.header)
+			u := GetValidUserFromToken(req, tc.url)
+			if tc.expectedUsername == "" {
+				assert.Nil(t, u)
+			} else if assert.NotNil(t, u) {
+				assert.Equal(t, tc.expectedUsername, u.Username)
+			}
+
+			// verify handler accept bearer token
+			req.Host = tc.url
+			w := httptest.NewRecorder()
+			http.HandlerFunc(VerifyHandler)(w, req)
+			if tc.expectedUsername == "" {
+				assert.Equal(t, http.StatusForbidden, w.Result().StatusCode)
+			} else {
+				assert.Equal(t, http.StatusOK, w.Result().StatusCode)
+				assert.Equal(t, tc.expectedUsername, w.Result().Header.Get("Remote-User"))
+			}
+		})
+	}
+}
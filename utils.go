@@ -7,15 +7,14 @@ import (
 	"crypto/x509"
 	"crypto/x509/pkix"
 	"encoding/pem"
+	"errors"
 	"html"
 	"math/big"
 	"net/http"
+	"net/url"
 	"os"
-	"regexp"
 	"strings"
 	"time"
-
-	"golang.org/x/crypto/bcrypt"
 )
 
 // return sanitized value
@@ -46,6 +45,22 @@ func GetIp(r *http.Request) (ip string) {
 	return
 }
 
+// headers holding credentials : tokens, passwords and session cookies
+var credentialHeaders = []string{"Authorization", "Cookie", "Auth-Form"}
+
+// return copy of request safe to log, with credentials redacted
+func RedactRequest(r *http.Request) *http.Request {
+	c := r.Clone(r.Context())
+	for _, h := range credentialHeaders {
+		if c.Header.Get(h) != "" {
+			c.Header.Set(h, "[redacted]")
+		}
+	}
+	// posted forms hold passwords
+	c.Form, c.PostForm = nil, nil
+	return c
+}
+
 // get host from request
 func GetHost(r *http.Request) (host string) {
 	host = GetDomain(GetSanitizeHeader(r.Header.Get("X-Original-URL")))
@@ -58,20 +73,43 @@ func GetHost(r *http.Request) (host string) {
 	return host
 }
 
-// return bcrypted hash of string, 12 iterations
+// get url requested before authentication, from proxy headers, empty if unknown or invalid
+func GetReturnUrl(r *http.Request) string {
+	raw := r.Header.Get("X-Original-URL")
+	if raw == "" && r.Header.Get("X-Forwarded-Host") != "" {
+		proto := r.Header.Get("X-Forwarded-Proto")
+		if proto == "" {
+			proto = "https"
+		}
+		uri := r.Header.Get("X-Forwarded-Uri")
+		if uri == "" {
+			uri = "/"
+		}
+		raw = proto + "://" + r.Header.Get("X-Forwarded-Host") + uri
+	}
+	if strings.ContainsAny(raw, "\r\n") {
+		return ""
+	}
+	u, err := url.Parse(raw)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" || u.User != nil {
+		return ""
+	}
+	return u.String()
+}
+
+// return hash of string, with configured algorithm
 // panic in case of error
 func GetHash(s string) string {
-	h, err := bcrypt.GenerateFromPassword([]byte(s), 12)
+	h, err := configuration.PasswordHash.Hash(s)
 	if err != nil {
 		panic(err)
 	}
-	return string(h)
+	return h
 }
 
-// compare a hash with a hashed string
+// compare a hash with a hashed string, whatever the hash format
 func CompareHash(h string, s string) bool {
-	err := bcrypt.CompareHashAndPassword([]byte(h), []byte(s))
-	return err == nil
+	return ComparePasswordHash(h, s)
 }
 
 // generate random bytes
@@ -105,36 +143,67 @@ func GetDomain(url string) string {
 // compare domain from url with domains list
 func CompareDomains(domains []string, url string) bool {
 	domain := GetDomain(url)
-	//valid domain against regexp
 	for _, d := range domains {
-		match, _ := regexp.MatchString(d+"$", domain)
-		if match {
+		if MatchDomain(d, domain) {
 			return true
 		}
 	}
 	return false
 }
 
-// generate private and public keys, return if file exists, panic if impossible to finish
-func GenerateKeyPair(keySize int, privateKeyFile string, certificateFile string) {
+// validity of generated certificates
+const selfSignedValidity = time.Hour * 24 * 180
+
+// generate private and public keys, return if file exists
+func GenerateKeyPair(keySize int, privateKeyFile string, certificateFile string) error {
 	if _, err := os.Stat(privateKeyFile); err == nil {
-		return
+		return nil
 	}
 	if _, err := os.Stat(certificateFile); err == nil {
-		return
+		return nil
 	}
+	return generateKeyPair(keySize, privateKeyFile, certificateFile, selfSignedValidity)
+}
+
+// replace existing private and public keys with new ones
+// existing files are kept if new ones can't be written
+func RenewKeyPair(keySize int, privateKeyFile string, certificateFile string) error {
+	// generated files are read-only, so written aside then moved over existing ones
+	tmpKey, tmpCert := privateKeyFile+".tmp", certificateFile+".tmp"
+	os.Remove(tmpKey)
+	os.Remove(tmpCert)
+	defer os.Remove(tmpKey)
+	defer os.Remove(tmpCert)
+	if err := generateKeyPair(keySize, tmpKey, tmpCert, selfSignedValidity); err != nil {
+		return err
+	}
+	if err := os.Rename(tmpKey, privateKeyFile); err != nil {
+		return errors.New("utils: error replacing private key\n\t-> " + err.Error())
+	}
+	if err := os.Rename(tmpCert, certificateFile); err != nil {
+		return errors.New("utils: error replacing certificate\n\t-> " + err.Error())
+	}
+	return nil
+}
+
+// write a new self-signed key pair valid for the given duration
+func generateKeyPair(keySize int, privateKeyFile string, certificateFile string, validity time.Duration) error {
 
 	priv, err := rsa.GenerateKey(rand.Reader, keySize)
 	if err != nil {
-		panic(err)
+		return errors.New("utils: error generating private key\n\t-> " + err.Error())
+	}
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return errors.New("utils: error generating serial number\n\t-> " + err.Error())
 	}
 	template := x509.Certificate{
-		SerialNumber: big.NewInt(1),
+		SerialNumber: serial,
 		Subject: pkix.Name{
 			Organization: []string{"GFA"},
 		},
 		NotBefore: time.Now(),
-		NotAfter:  time.Now().Add(time.Hour * 24 * 180),
+		NotAfter:  time.Now().Add(validity),
 
 		KeyUsage:              x509.KeyUsageKeyEncipherment | x509.KeyUsageDigitalSignature,
 		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
@@ -142,19 +211,20 @@ func GenerateKeyPair(keySize int, privateKeyFile string, certificateFile string)
 	}
 	derBytes, err := x509.CreateCertificate(rand.Reader, &template, &template, &(priv).PublicKey, priv)
 	if err != nil {
-		panic(err)
+		return errors.New("utils: error creating certificate\n\t-> " + err.Error())
 	}
 	out := &bytes.Buffer{}
 	pem.Encode(out, &pem.Block{Type: "CERTIFICATE", Bytes: derBytes})
 	err = os.WriteFile(certificateFile, out.Bytes(), 0400)
 	if err != nil {
-		panic(err)
+		return errors.New("utils: error writing certificate\n\t-> " + err.Error())
 	}
 
 	out.Reset()
 	pem.Encode(out, &pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(priv)})
 	err = os.WriteFile(privateKeyFile, out.Bytes(), 0400)
 	if err != nil {
-		panic(err)
+		return errors.New("utils: error writing private key\n\t-> " + err.Error())
 	}
+	return nil
 }
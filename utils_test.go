@@ -237,8 +237,21 @@ func TestCompareDomains(t *testing.T) {
 		{[]string{"regex3.com", ".*domain.fr"}, "domain.fr.nope", false},
 		{[]string{"regex4.com", ".*domain.fr.*"}, "domain.fr.yep", true},
 		{[]string{"long.com", "valid.domain.fr"}, "domain.fr", false},
-		{[]string{"short.com", "domain.fr"}, "valid.domain.fr", true},
+		{[]string{"short.com", "domain.fr"}, "valid.domain.fr", false},
+		{[]string{"prefix.com", "domain.fr"}, "evildomain.fr", false},
+		{[]string{"port.com", "domain.fr"}, "DOMAIN.fr:8443", true},
 		{[]string{".*"}, "any.domain.fr", true},
+		{[]string{"*"}, "any.domain.fr", true},
+		{[]string{"*.domain.fr"}, "my.domain.fr", true},
+		{[]string{"*.domain.fr"}, "domain.fr", false},
+		{[]string{"*.domain.fr"}, "a.my.domain.fr", false},
+		{[]string{"*.domain.fr"}, "evil.domain.fr.com", false},
+		{[]string{"app-*.domain.fr"}, "app-1.domain.fr", true},
+		{[]string{"re:(a|b)\\.domain\\.fr"}, "b.domain.fr", true},
+		{[]string{"re:(a|b)\\.domain\\.fr"}, "c.b.domain.fr", false},
+		{[]string{"re:("}, "domain.fr", false},
+		{[]string{""}, "domain.fr", false},
+		{[]string{}, "domain.fr", false},
 	}
 	for _, tc := range testCases {
 		// shadow the test case to avoid modifying the test case
@@ -249,3 +262,58 @@ func TestCompareDomains(t *testing.T) {
 		})
 	}
 }
+
+func TestGetReturnUrl(t *testing.T) {
+	testCases := []struct {
+		name     string
+		header   http.Header
+		expected string
+	}{
+		{"NONE", http.Header{}, ""},
+		{"ORIGINAL_URL", http.Header{"X-Original-Url": []string{"https://app.com/path?a=1"}}, "https://app.com/path?a=1"},
+		{"FORWARDED", http.Header{"X-Forwarded-Proto": []string{"http"}, "X-Forwarded-Host": []string{"app.com:8080"}, "X-Forwarded-Uri": []string{"/path"}}, "http://app.com:8080/path"},
+		{"FORWARDED_DEFAULTS", http.Header{"X-Forwarded-Host": []string{"app.com"}}, "https://app.com/"},
+		{"BAD_SCHEME", http.Header{"X-Original-Url": []string{"javascript:alert(1)"}}, ""},
+		{"RELATIVE", http.Header{"X-Original-Url": []string{"/path"}}, ""},
+		{"BAD_PROTO", http.Header{"X-Forwarded-Proto": []string{"ftp"}, "X-Forwarded-Host": []string{"app.com"}}, ""},
+		{"USERINFO", http.Header{"X-Original-Url": []string{"https://evil.com@app.com/"}}, ""},
+		{"NOT_SANE", http.Header{"X-Forwarded-Host": []string{"app.com"}, "X-Forwarded-Uri": []string{"/\r\nSet-Cookie: a=b"}}, ""},
+	}
+
+	for _, tc := range testCases {
+		// shadow the test case to avoid modifying the test case
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			req := &http.Request{Header: tc.header}
+			assert.Equal(t, tc.expected, GetReturnUrl(req))
+		})
+	}
+}
+
+func TestRedactRequest(t *testing.T) {
+	testCases := []struct {
+		name   string
+		header http.Header
+		secret string
+	}{
+		{"BEARER", http.Header{"Authorization": []string{"Bearer secret-token"}}, "secret-token"},
+		{"COOKIE", http.Header{"Cookie": []string{"session=secret-session"}}, "secret-session"},
+		{"FORM", http.Header{"Auth-Form": []string{"username=user&password=secret-password"}}, "secret-password"},
+	}
+
+	for _, tc := range testCases {
+		// shadow the test case to avoid modifying the test case
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			req, _ := http.NewRequest("GET", "https://app.com/path", nil)
+			req.Header = tc.header
+			redacted := RedactRequest(req)
+			assert.NotContains(t, fmt.Sprint(redacted.Header), tc.secret)
+			assert.Equal(t, "/path", redacted.URL.Path)
+			// original request is unchanged
+			assert.Contains(t, fmt.Sprint(req.Header), tc.secret)
+		})
+	}
+}
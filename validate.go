@@ -0,0 +1,227 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/knadh/koanf/v2"
+)
+
+var ErrUnknownKey = errors.New("config: unknown key")
+
+// problem of a configuration value
+type ConfigError struct {
+	// path of the value, as in configuration files (ex: Users.jean.AllowedDomains[1])
+	Path string
+	Err  error
+}
+
+func (e *ConfigError) Error() string {
+	return e.Path + ": " + e.Err.Error()
+}
+
+func (e *ConfigError) Unwrap() error {
+	return e.Err
+}
+
+// all problems of a configuration
+type ConfigErrors []*ConfigError
+
+func (e ConfigErrors) Error() string {
+	msg := "config: " + strconv.Itoa(len(e)) + " problem(s)"
+	for _, err := range e {
+		msg += "\n\t-> " + err.Error()
+	}
+	return msg
+}
+
+func (e ConfigErrors) Unwrap() []error {
+	errs := make([]error, len(e))
+	for i, err := range e {
+		errs[i] = err
+	}
+	return errs
+}
+
+func (e *ConfigErrors) add(path string, err error) {
+	*e = append(*e, &ConfigError{Path: path, Err: err})
+}
+
+// return nil if there is no problem, so that a nil slice is not returned as a non nil error
+func (e ConfigErrors) Err() error {
+	if len(e) == 0 {
+		return nil
+	}
+	return e
+}
+
+// validate configuration without default values, and reject unknown keys of k
+func (c *Config) ValidStrict(k *koanf.Koanf) error {
+	errs := c.UnknownKeys(k)
+	if err := c.Valid(false); err != nil {
+		var ce ConfigErrors
+		if !errors.As(err, &ce) {
+			return err
+		}
+		errs = append(errs, ce...)
+	}
+	return errs.Err()
+}
+
+// return keys of k matching no configuration field
+func (c *Config) UnknownKeys(k *koanf.Koanf) ConfigErrors {
+	errs := ConfigErrors{}
+	if k != nil {
+		unknownKeys(k.Raw(), reflect.TypeOf(c), "", &errs)
+	}
+	return errs
+}
+
+// check keys of value v, read from configuration, against type t
+func unknownKeys(v interface{}, t reflect.Type, path string, errs *ConfigErrors) {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	switch t.Kind() {
+	case reflect.Struct:
+		m, ok := v.(map[string]interface{})
+		// type errors are reported when parsing configuration
+		if !ok || t == reflect.TypeOf(time.Time{}) {
+			return
+		}
+		for _, key := range sortedKeys(m) {
+			f, ok := configField(t, key)
+			if !ok {
+				errs.add(joinPath(path, key), ErrUnknownKey)
+				continue
+			}
+			unknownKeys(m[key], f.Type, joinPath(path, key), errs)
+		}
+	case reflect.Map:
+		maps := []map[string]interface{}{}
+		switch m := v.(type) {
+		case map[string]interface{}:
+			maps = append(maps, m)
+		// maps can be written as a list of single-key maps, as Users
+		case []interface{}:
+			for _, e := range m {
+				if em, ok := e.(map[string]interface{}); ok {
+					maps = append(maps, em)
+				}
+			}
+		}
+		for _, m := range maps {
+			for _, key := range sortedKeys(m) {
+				unknownKeys(m[key], t.Elem(), joinPath(path, key), errs)
+			}
+		}
+	case reflect.Slice:
+		if l, ok := v.([]interface{}); ok {
+			for i, e := range l {
+				unknownKeys(e, t.Elem(), fmt.Sprintf("%s[%d]", path, i), errs)
+			}
+		}
+	}
+}
+
+// return field of struct t set by key, case insensitive as when parsing configuration
+func configField(t reflect.Type, key string) (reflect.StructField, bool) {
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if !f.IsExported() {
+			continue
+		}
+		name := f.Tag.Get("koanf")
+		if name == "" {
+			name = f.Name
+		}
+		if strings.EqualFold(name, key) {
+			return f, true
+		}
+	}
+	return reflect.StructField{}, false
+}
+
+func sortedKeys(m map[string]interface{}) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func joinPath(path, key string) string {
+	if path == "" {
+		return key
+	}
+	return path + "." + key
+}
+
+// validate users defined in configuration, and domains they are allowed
+func (c *Config) validUsers(errs *ConfigErrors) {
+	names := make([]string, 0, len(c.Users))
+	for n := range c.Users {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	for _, n := range names {
+		u, path := c.Users[n], "Users."+n
+		if u == nil {
+			errs.add(path, errors.New("config: empty user"))
+			continue
+		}
+		if u.Password != "" && GetHashAlgorithm(u.Password) == HashUnknown {
+			errs.add(path+".Password", errors.New("config: unknown password hash format, expected a hash (see hash command)"))
+		}
+		validDomains(errs, path+".AllowedDomains", u.AllowedDomains)
+		if u.Language != "" && !SupportedLanguage(u.Language) {
+			errs.add(path+".Language", errors.New("config: unsupported Language, expected one of "+strings.Join(Languages(), ", ")))
+		}
+		for tn, t := range u.Tokens {
+			if t == nil || !validTokenHash(t.Hash) {
+				errs.add(path+".Tokens."+tn+".Hash", errors.New("config: bad token hash, expected 64 hexadecimal characters"))
+				continue
+			}
+			validDomains(errs, path+".Tokens."+tn+".AllowedDomains", t.AllowedDomains)
+		}
+	}
+	validDomains(errs, "BasicAuthDomains", c.BasicAuthDomains)
+	for i, h := range c.HtpasswdFiles {
+		validDomains(errs, fmt.Sprintf("HtpasswdFiles[%d].AllowedDomains", i), h.AllowedDomains)
+	}
+	if c.MagicLink != nil {
+		for i, d := range c.MagicLink.EmailDomains {
+			if d != nil {
+				validDomains(errs, fmt.Sprintf("MagicLink.EmailDomains[%d].AllowedDomains", i), d.AllowedDomains)
+			}
+		}
+	}
+}
+
+// check allowed domains are valid patterns, compiling them once for all
+func validDomains(errs *ConfigErrors, path string, domains []string) {
+	for i, d := range domains {
+		if _, err := CompileDomain(d); err != nil {
+			errs.add(fmt.Sprintf("%s[%d]", path, i), errors.New("config: bad domain pattern\n\t-> "+err.Error()))
+		}
+	}
+}
+
+// sha256 of a token, as returned by HashApiToken
+func validTokenHash(h string) bool {
+	if len(h) != 64 {
+		return false
+	}
+	for _, r := range h {
+		if !strings.ContainsRune("0123456789abcdefABCDEF", r) {
+			return false
+		}
+	}
+	return true
+}
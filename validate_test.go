@@ -0,0 +1,201 @@
+package main
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+
+	flag "github.com/spf13/pflag" // POSIX compliant
+
+	"github.com/knadh/koanf/v2"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestConfigErrors(t *testing.T) {
+	errs := ConfigErrors{}
+	assert.NoError(t, errs.Err())
+
+	errs.add("Port", errors.New("config: bad Port"))
+	errs.add("Users.jean.Nope", ErrUnknownKey)
+	err := errs.Err()
+	assert.EqualError(t, err, "config: 2 problem(s)\n\t-> Port: config: bad Port\n\t-> Users.jean.Nope: config: unknown key")
+	assert.ErrorIs(t, err, ErrUnknownKey)
+
+	var ce ConfigErrors
+	if assert.ErrorAs(t, err, &ce) {
+		assert.Equal(t, "Users.jean.Nope", ce[1].Path)
+	}
+}
+
+func TestUnknownKeys(t *testing.T) {
+	testCases := []struct {
+		Name          string
+		Set           map[string]interface{}
+		ExpectedPaths []string
+	}{
+		{
+			Name:          "NONE",
+			ExpectedPaths: []string{},
+		},
+		{
+			Name: "CASE_INSENSITIVE",
+			Set: map[string]interface{}{
+				"jwtsecretkey":             "11111111111111111111111111111111",
+				"passwordpolicy.minLength": 10,
+			},
+			ExpectedPaths: []string{},
+		},
+		{
+			Name: "UNKNOWN",
+			Set: map[string]interface{}{
+				"JwtSecretKy":              "1",
+				"Smtp.Adress":              "localhost:25",
+				"PasswordPolicy.MinLength": 10,
+			},
+			ExpectedPaths: []string{"JwtSecretKy", "Smtp.Adress"},
+		},
+		{
+			Name: "NESTED",
+			Set: map[string]interface{}{
+				"Listeners": []interface{}{
+					map[string]interface{}{"Type": "http", "Address": ":80"},
+					map[string]interface{}{"Type": "http", "Adress": ":81"},
+				},
+				"MagicLink.EmailDomains": []interface{}{
+					map[string]interface{}{"EmailDomain": "a.com", "Allowed": []interface{}{"a"}},
+				},
+			},
+			ExpectedPaths: []string{"Listeners[1].Adress", "MagicLink.EmailDomains[0].Allowed"},
+		},
+		{
+			Name: "USERS",
+			Set: map[string]interface{}{
+				"Users.paul.Pasword":         "hash",
+				"Users.paul.Tokens.ci.Hsh":   "hash",
+				"Users.paul.Tokens.ci.Admin": true,
+				"Users.paul.ExpiresAt":       "2030-01-01T00:00:00Z",
+			},
+			ExpectedPaths: []string{"Users.paul.Pasword", "Users.paul.Tokens.ci.Hsh"},
+		},
+	}
+	for _, tc := range testCases {
+		// shadow the test case to avoid modifying the test case
+		tc := tc
+		t.Run(tc.Name, func(t *testing.T) {
+			t.Parallel()
+			k := koanf.New(".")
+			c := &Config{ConfigurationFile: []string{"test.config.yml"}}
+			_, err := c.LoadFile(k)
+			assert.NoError(t, err)
+			for key, v := range tc.Set {
+				assert.NoError(t, k.Set(key, v))
+			}
+
+			paths := []string{}
+			for _, e := range c.UnknownKeys(k) {
+				assert.ErrorIs(t, e, ErrUnknownKey)
+				paths = append(paths, e.Path)
+			}
+			assert.ElementsMatch(t, tc.ExpectedPaths, paths)
+		})
+	}
+	assert.Empty(t, (&Config{}).UnknownKeys(nil))
+}
+
+func TestValidUsers(t *testing.T) {
+	c := &Config{
+		Users: map[string]*User{
+			"ok": {
+				Password:       "$2y$10$t6XPeRTf5.a.Gb3I/lYq7ukuOpx6fsJRstEXNfOP4jXjjGGZ2Af72",
+				AllowedDomains: []string{".*", "a.com"},
+				Tokens:         map[string]*ApiToken{"ci": {Hash: HashApiToken("gfa_test")}},
+			},
+			"tokenonly": {
+				Tokens: map[string]*ApiToken{"ci": {Hash: HashApiToken("gfa_test"), AllowedDomains: []string{"(a"}}},
+			},
+			"plain": {Password: "pass", AllowedDomains: []string{"*.a.com", "re:*.b.com"}, Language: "xx"},
+			"badtoken": {
+				Password: "{SHA}W6ph5Mm5Pz8GgiULbPgzG37mj9g=",
+				Tokens:   map[string]*ApiToken{"ci": {Hash: "gfa_test"}, "nil": nil},
+			},
+			"nil": nil,
+		},
+		HtpasswdFiles: []*HtpasswdFile{{Path: "users.htpasswd", AllowedDomains: []string{"[a-"}}},
+		MagicLink:     &MagicLink{EmailDomains: []*MagicLinkDomain{{EmailDomain: "a.com", AllowedDomains: []string{"ok", "+"}}}},
+	}
+
+	errs := ConfigErrors{}
+	c.validUsers(&errs)
+	paths := []string{}
+	for _, e := range errs {
+		paths = append(paths, e.Path)
+	}
+	assert.ElementsMatch(t, []string{
+		"Users.badtoken.Tokens.ci.Hash",
+		"Users.badtoken.Tokens.nil.Hash",
+		"Users.nil",
+		"Users.plain.Password",
+		"Users.plain.AllowedDomains[1]",
+		"Users.plain.Language",
+		"Users.tokenonly.Tokens.ci.AllowedDomains[0]",
+		"HtpasswdFiles[0].AllowedDomains[0]",
+		"MagicLink.EmailDomains[0].AllowedDomains[1]",
+	}, paths)
+}
+
+func TestValidAllProblems(t *testing.T) {
+	c := &Config{}
+	err := c.Valid(false)
+
+	var errs ConfigErrors
+	if assert.ErrorAs(t, err, &errs) {
+		paths := []string{}
+		for _, e := range errs {
+			paths = append(paths, e.Path)
+		}
+		assert.Subset(t, paths, []string{"Port", "Certificate", "CookieName", "TokenExpire", "HtmlFile", "JwtSecretKey", "CsrfSecretKey", "LogLevel"})
+	}
+	assert.ErrorContains(t, err, "HtmlFile: config: missing HtmlFile")
+	// nothing was replaced by default values
+	assert.Empty(t, c.CookieName)
+	assert.Empty(t, c.JwtSecretKey)
+}
+
+func TestLoadStrict(t *testing.T) {
+	f := flag.NewFlagSet("strict", flag.ContinueOnError)
+	c := &Config{}
+	c.defineFlags(f)
+	assert.NoError(t, f.Parse([]string{"--strict", "--config", "test.config.yml"}))
+
+	k := koanf.New(".")
+	assert.NoError(t, k.Set("Nope", true))
+	err := c.Load(k, f)
+	assert.ErrorContains(t, err, "Nope: config: unknown key")
+	assert.ErrorContains(t, err, "Certificate: config: bad key pair")
+	assert.True(t, c.Strict)
+	// default values are not used
+	assert.Empty(t, c.Certificate)
+
+	// without strict mode, default values are used
+	f = flag.NewFlagSet("lenient", flag.ContinueOnError)
+	c = &Config{}
+	c.defineFlags(f)
+	assert.NoError(t, f.Parse([]string{"--config", "test.config.yml"}))
+	assert.NoError(t, c.Load(koanf.New("."), f))
+	assert.NotEmpty(t, c.Certificate)
+
+	// unset optional keys are not errors, a complete configuration is valid
+	dir := t.TempDir()
+	key, cert := filepath.Join(dir, "server.key"), filepath.Join(dir, "server.crt")
+	assert.NoError(t, GenerateKeyPair(2048, key, cert))
+	f = flag.NewFlagSet("complete", flag.ContinueOnError)
+	c = &Config{}
+	c.defineFlags(f)
+	assert.NoError(t, f.Parse([]string{"--strict", "--config", "test.config.yml"}))
+	k = koanf.New(".")
+	assert.NoError(t, k.Set("PrivateKey", key))
+	assert.NoError(t, k.Set("Certificate", cert))
+	assert.NoError(t, c.Load(k, f))
+	assert.Equal(t, defaultAdminHtmlFile, c.AdminHtmlFile)
+	assert.Equal(t, uint(5), c.MailRateLimit)
+}